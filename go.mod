@@ -3,11 +3,11 @@ module github.com/inercia/go-values-yaml
 go 1.22
 
 require (
-	dario.cat/mergo v1.0.2 // indirect
-	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/psanford/memfs v0.0.0-20241019191636-4ef911798f9b // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
-	sigs.k8s.io/yaml v1.4.0 // indirect
+	dario.cat/mergo v1.0.2
+	github.com/davecgh/go-spew v1.1.1
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/stretchr/testify v1.10.0
+	sigs.k8s.io/yaml v1.4.0
 )
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,278 @@
+package argocd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"text/template"
+
+	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
+	syaml "sigs.k8s.io/yaml"
+)
+
+// Application is the part of an Argo CD Application relevant to its Helm
+// values.
+type Application struct {
+	// Name is the name of the Application.
+	Name string `json:"name"`
+	// Sources are the sources of the Application: spec.source, or the list in
+	// spec.sources for multi-source Applications.
+	Sources []Source `json:"sources"`
+}
+
+// Source is an Application source.
+type Source struct {
+	RepoURL string `json:"repoURL"`
+	// Path is the directory of the chart in the repository, for charts stored
+	// in Git.
+	Path string `json:"path,omitempty"`
+	// Chart is the name of the chart, for charts stored in a Helm repository.
+	Chart string `json:"chart,omitempty"`
+	// Ref names the source so that value files can be read from it with
+	// "$<ref>/path".
+	Ref  string      `json:"ref,omitempty"`
+	Helm *HelmSource `json:"helm,omitempty"`
+}
+
+// HelmSource holds the Helm settings of a source.
+type HelmSource struct {
+	// ValueFiles are the value files, merged in order.
+	ValueFiles []string `json:"valueFiles,omitempty"`
+	// Values are inline values as a YAML string, merged after the files.
+	Values string `json:"values,omitempty"`
+	// ValuesObject are inline values as an object. They take precedence over
+	// Values.
+	ValuesObject map[string]any `json:"valuesObject,omitempty"`
+	// IgnoreMissingValueFiles skips the value files that do not exist.
+	IgnoreMissingValueFiles bool `json:"ignoreMissingValueFiles,omitempty"`
+}
+
+// apiVersion is the API version of the Argo CD resources.
+const apiVersion = "argoproj.io/v1alpha1"
+
+// manifest is a Kubernetes manifest holding an Application or ApplicationSet.
+type manifest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec map[string]any `json:"spec"`
+}
+
+// LoadApplications reads the manifest file at name in repo and returns its
+// Applications. See ParseApplications.
+func LoadApplications(repo fs.FS, name string) ([]Application, error) {
+	b, err := fs.ReadFile(repo, name)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := ParseApplications(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return apps, nil
+}
+
+// ParseApplications parses a YAML stream of manifests and returns the
+// Applications found, ignoring any other kind of resource. Only the resources
+// of Argo CD, with apiVersion argoproj.io/v1alpha1, are read: kinds of the same
+// name from other API groups are ignored, and other versions of argoproj.io
+// are reported as errors.
+//
+// ApplicationSets are expanded into the Applications generated by their list
+// generators, rendering the template with the parameters of every element
+// (with Go templates when spec.goTemplate is set). Other generators need a
+// cluster or a remote repository and are ignored.
+func ParseApplications(b []byte) ([]Application, error) {
+	var apps []Application
+	for i, doc := range yamllib.SplitDocuments(b) {
+		var m manifest
+		if err := syaml.Unmarshal(doc, &m); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if m.Kind != "Application" && m.Kind != "ApplicationSet" {
+			continue
+		}
+		if group, _, _ := strings.Cut(m.APIVersion, "/"); group != "argoproj.io" {
+			continue
+		}
+		if m.APIVersion != apiVersion {
+			return nil, fmt.Errorf("%s %q: unsupported apiVersion %s", strings.ToLower(m.Kind), m.Metadata.Name, m.APIVersion)
+		}
+		switch m.Kind {
+		case "Application":
+			app, err := newApplication(m.Metadata.Name, m.Spec)
+			if err != nil {
+				return nil, fmt.Errorf("application %q: %w", m.Metadata.Name, err)
+			}
+			apps = append(apps, app)
+		case "ApplicationSet":
+			generated, err := expandApplicationSet(m.Spec)
+			if err != nil {
+				return nil, fmt.Errorf("applicationset %q: %w", m.Metadata.Name, err)
+			}
+			apps = append(apps, generated...)
+		}
+	}
+	return apps, nil
+}
+
+// newApplication builds an Application from its spec.
+func newApplication(name string, spec map[string]any) (Application, error) {
+	app := Application{Name: name}
+	var raw []any
+	if src, ok := spec["source"]; ok && src != nil {
+		raw = append(raw, src)
+	}
+	if srcs, ok := spec["sources"].([]any); ok {
+		raw = append(raw, srcs...)
+	}
+	for i, r := range raw {
+		src, err := newSource(r)
+		if err != nil {
+			return app, fmt.Errorf("source %d: %w", i, err)
+		}
+		app.Sources = append(app.Sources, src)
+	}
+	return app, nil
+}
+
+// newSource decodes a source. The values of the Helm settings are accepted both
+// as a YAML string and as an object, as Argo CD does.
+func newSource(raw any) (Source, error) {
+	var src Source
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return src, fmt.Errorf("not an object")
+	}
+	helm, _ := m["helm"].(map[string]any)
+	if v, ok := helm["values"]; ok && v != nil {
+		if _, isString := v.(string); !isString {
+			b, err := syaml.Marshal(v)
+			if err != nil {
+				return src, err
+			}
+			helm["values"] = string(b)
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return src, err
+	}
+	if err := json.Unmarshal(b, &src); err != nil {
+		return src, err
+	}
+	return src, nil
+}
+
+// expandApplicationSet returns the Applications generated by the list
+// generators of an ApplicationSet.
+func expandApplicationSet(spec map[string]any) ([]Application, error) {
+	tmpl, _ := spec["template"].(map[string]any)
+	if tmpl == nil {
+		return nil, fmt.Errorf("no template")
+	}
+	goTemplate, _ := spec["goTemplate"].(bool)
+	generators, _ := spec["generators"].([]any)
+
+	var apps []Application
+	for g, gen := range generators {
+		m, _ := gen.(map[string]any)
+		list, _ := m["list"].(map[string]any)
+		if list == nil {
+			continue
+		}
+		elements, _ := list["elements"].([]any)
+		for e, el := range elements {
+			params, ok := el.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("generator %d, element %d: not an object", g, e)
+			}
+			rendered, err := render(tmpl, params, goTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("generator %d, element %d: %w", g, e, err)
+			}
+			appManifest := rendered.(map[string]any)
+			metadata, _ := appManifest["metadata"].(map[string]any)
+			name, _ := metadata["name"].(string)
+			appSpec, _ := appManifest["spec"].(map[string]any)
+			app, err := newApplication(name, appSpec)
+			if err != nil {
+				return nil, fmt.Errorf("application %q: %w", name, err)
+			}
+			apps = append(apps, app)
+		}
+	}
+	return apps, nil
+}
+
+// placeholder matches the {{param}} placeholders of non-Go templates.
+var placeholder = regexp.MustCompile(`{{\s*([^{}\s]+)\s*}}`)
+
+// render renders every string found in v with the parameters of a generator
+// element.
+func render(v any, params map[string]any, goTemplate bool) (any, error) {
+	switch vv := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(vv))
+		for k, e := range vv {
+			r, err := render(e, params, goTemplate)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(vv))
+		for i, e := range vv {
+			r, err := render(e, params, goTemplate)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case string:
+		if !strings.Contains(vv, "{{") {
+			return vv, nil
+		}
+		if goTemplate {
+			t, err := template.New("").Option("missingkey=error").Parse(vv)
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, params); err != nil {
+				return nil, err
+			}
+			return buf.String(), nil
+		}
+		flat := make(map[string]string)
+		flattenParams(params, "", flat)
+		return placeholder.ReplaceAllStringFunc(vv, func(s string) string {
+			if p, ok := flat[placeholder.FindStringSubmatch(s)[1]]; ok {
+				return p
+			}
+			return s
+		}), nil
+	default:
+		return v, nil
+	}
+}
+
+// flattenParams stores in out the parameters of a generator element under
+// their dotted names, as non-Go templates refer to them.
+func flattenParams(params map[string]any, prefix string, out map[string]string) {
+	for k, v := range params {
+		if m, ok := v.(map[string]any); ok {
+			flattenParams(m, prefix+k+".", out)
+			continue
+		}
+		out[prefix+k] = fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,222 @@
+package argocd
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var repo = fstest.MapFS{
+	"charts/app/values.yaml": {Data: []byte("replicas: 1\n")},
+	"envs/values.yaml":       {Data: []byte("image:\n  repository: nginx\n  tag: \"1.0\"\nreplicas: 2\n")},
+	"envs/prod/values.yaml":  {Data: []byte("image:\n  tag: \"1.1\"\nregion: eu\n")},
+	"envs/dev/values.yaml":   {Data: []byte("replicas: 1\nregion: us\n")},
+}
+
+func TestEffectiveValues(t *testing.T) {
+	t.Parallel()
+
+	apps, err := ParseApplications([]byte(`
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-prod
+spec:
+  source:
+    repoURL: https://example.com/repo.git
+    path: charts/app
+    helm:
+      ignoreMissingValueFiles: true
+      valueFiles:
+        - ../../envs/values.yaml
+        - ../../envs/prod/values.yaml
+        - ../../envs/prod/missing.yaml
+      values: |
+        replicas: 5
+        region: ignored
+      valuesObject:
+        replicas: 3
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+`))
+	require.NoError(t, err)
+	require.Len(t, apps, 1)
+	assert.Equal(t, "app-prod", apps[0].Name)
+
+	v, err := EffectiveValues(repo, apps[0])
+	require.NoError(t, err)
+	y, err := v.ToYAML()
+	require.NoError(t, err)
+	assert.YAMLEq(t, "image:\n  repository: nginx\n  tag: \"1.1\"\nregion: eu\nreplicas: 3\n", string(y))
+
+	// Without ignoreMissingValueFiles a missing file is an error
+	apps[0].Sources[0].Helm.IgnoreMissingValueFiles = false
+	_, err = EffectiveValues(repo, apps[0])
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestEffectiveValuesMultiSource(t *testing.T) {
+	t.Parallel()
+
+	apps, err := ParseApplications([]byte(`
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app-dev
+spec:
+  sources:
+    - repoURL: https://charts.example.com
+      chart: app
+      targetRevision: 1.0.0
+      helm:
+        valueFiles:
+          - $values/envs/values.yaml
+          - $values/envs/dev/values.yaml
+        values:
+          debug: true
+    - repoURL: https://example.com/config.git
+      ref: values
+`))
+	require.NoError(t, err)
+	require.Len(t, apps, 1)
+
+	config := fstest.MapFS{"envs/values.yaml": {Data: []byte("replicas: 7\n")}, "envs/dev/values.yaml": {Data: []byte("region: dev\n")}}
+	v, err := EffectiveValues(repo, apps[0], WithRepository("https://example.com/config", config))
+	require.NoError(t, err)
+	y, err := v.ToYAML()
+	require.NoError(t, err)
+	assert.YAMLEq(t, "replicas: 7\nregion: dev\ndebug: true\n", string(y))
+
+	// Every source with Helm settings renders a chart of its own
+	apps[0].Sources[1].Helm = &HelmSource{Values: "replicas: 2\n"}
+	_, err = EffectiveValues(repo, apps[0], WithRepository("https://example.com/config", config))
+	assert.ErrorIs(t, err, ErrMultipleHelmSources)
+	for i, want := range []string{"replicas: 7\nregion: dev\ndebug: true\n", "replicas: 2\n"} {
+		v, err := SourceValues(repo, apps[0], i, WithRepository("https://example.com/config", config))
+		require.NoError(t, err)
+		y, err := v.ToYAML()
+		require.NoError(t, err)
+		assert.YAMLEq(t, want, string(y))
+	}
+	_, err = SourceValues(repo, apps[0], 2)
+	assert.ErrorContains(t, err, "no source 2")
+	apps[0].Sources[1].Helm = nil
+
+	// Relative files of a chart from a Helm repository cannot be read
+	apps[0].Sources[0].Helm.ValueFiles = []string{"values-prod.yaml"}
+	_, err = EffectiveValues(repo, apps[0])
+	assert.ErrorContains(t, err, "cannot be read offline")
+}
+
+func TestParseApplicationsAPIVersion(t *testing.T) {
+	t.Parallel()
+
+	apps, err := ParseApplications([]byte(`
+apiVersion: app.k8s.io/v1beta1
+kind: Application
+metadata:
+  name: other
+spec:
+  descriptor:
+    type: app
+---
+kind: Application
+metadata:
+  name: unversioned
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: app
+spec:
+  source:
+    repoURL: https://example.com/repo.git
+    path: charts/app
+`))
+	require.NoError(t, err)
+	require.Len(t, apps, 1)
+	assert.Equal(t, "app", apps[0].Name)
+
+	_, err = ParseApplications([]byte(`
+apiVersion: argoproj.io/v1beta1
+kind: ApplicationSet
+metadata:
+  name: apps
+`))
+	assert.ErrorContains(t, err, "unsupported apiVersion argoproj.io/v1beta1")
+}
+
+func TestApplicationSet(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		manifest string
+	}{
+		{"placeholders", `
+apiVersion: argoproj.io/v1alpha1
+kind: ApplicationSet
+metadata:
+  name: apps
+spec:
+  generators:
+    - list:
+        elements:
+          - env: prod
+          - env: dev
+    - clusters: {}
+  template:
+    metadata:
+      name: 'app-{{env}}'
+    spec:
+      source:
+        repoURL: https://example.com/repo.git
+        path: charts/app
+        helm:
+          valueFiles: ['../../envs/values.yaml', '../../envs/{{ env }}/values.yaml']
+`},
+		{"go templates", `
+apiVersion: argoproj.io/v1alpha1
+kind: ApplicationSet
+metadata:
+  name: apps
+spec:
+  goTemplate: true
+  generators:
+    - list:
+        elements:
+          - env: prod
+          - env: dev
+  template:
+    metadata:
+      name: 'app-{{.env}}'
+    spec:
+      source:
+        repoURL: https://example.com/repo.git
+        path: charts/app
+        helm:
+          valueFiles: ['../../envs/values.yaml', '../../envs/{{ .env }}/values.yaml']
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			apps, err := ParseApplications([]byte(tc.manifest))
+			require.NoError(t, err)
+			require.Len(t, apps, 2)
+			assert.Equal(t, "app-prod", apps[0].Name)
+			assert.Equal(t, "app-dev", apps[1].Name)
+
+			v, err := EffectiveValues(repo, apps[1])
+			require.NoError(t, err)
+			y, err := v.ToYAML()
+			require.NoError(t, err)
+			assert.YAMLEq(t, "image:\n  repository: nginx\n  tag: \"1.0\"\nreplicas: 1\nregion: us\n", string(y))
+		})
+	}
+}
@@ -0,0 +1,5 @@
+// Package argocd resolves the Helm values of Argo CD Applications and
+// ApplicationSets stored in a repository, merging their valueFiles, values and
+// valuesObject the way Argo CD does, without contacting any cluster or remote
+// repository.
+package argocd
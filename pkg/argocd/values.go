@@ -0,0 +1,172 @@
+package argocd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/inercia/go-values-yaml/pkg/values"
+	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
+	syaml "sigs.k8s.io/yaml"
+)
+
+// Options controls how the values of an Application are resolved.
+type Options struct {
+	// Repositories maps the repoURL of sources to the filesystem holding a
+	// checkout of the repository. Sources from other repositories are read from
+	// the repository given to EffectiveValues.
+	Repositories map[string]fs.FS
+}
+
+// Option is a functional option for EffectiveValues.
+type Option func(*Options)
+
+// WithRepository reads the sources with the given repoURL from repo, for
+// Applications whose value files live in several repositories.
+func WithRepository(repoURL string, repo fs.FS) Option {
+	return func(o *Options) {
+		if o.Repositories == nil {
+			o.Repositories = make(map[string]fs.FS)
+		}
+		o.Repositories[normalizeRepoURL(repoURL)] = repo
+	}
+}
+
+// repository returns the filesystem the sources with the given repoURL are
+// read from.
+func (o Options) repository(repoURL string, repo fs.FS) fs.FS {
+	if r, ok := o.Repositories[normalizeRepoURL(repoURL)]; ok {
+		return r
+	}
+	return repo
+}
+
+// normalizeRepoURL returns the URL of a repository in a canonical form, so
+// that "https://host/repo.git/" and "https://host/repo" are the same.
+func normalizeRepoURL(u string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(u), "/"), ".git")
+}
+
+// ErrMultipleHelmSources is returned by EffectiveValues for Applications with
+// several sources with Helm settings, each one rendering a chart with values of
+// its own. Use SourceValues to resolve them one by one.
+var ErrMultipleHelmSources = errors.New("several sources with Helm settings")
+
+// EffectiveValues returns the values Argo CD passes to Helm for app, using repo
+// as the checkout of the repository of its sources (see WithRepository).
+//
+// The values are taken from the source with Helm settings, and are empty when
+// there is none. Applications with several of them fail with
+// ErrMultipleHelmSources. See SourceValues for how the values are merged.
+func EffectiveValues(repo fs.FS, app Application, opts ...Option) (*values.Values, error) {
+	index := -1
+	for i := range app.Sources {
+		if app.Sources[i].Helm == nil {
+			continue
+		}
+		if index >= 0 {
+			return nil, fmt.Errorf("application %q: %w: sources %d and %d", app.Name, ErrMultipleHelmSources, index, i)
+		}
+		index = i
+	}
+	if index < 0 {
+		return values.NewValues(), nil
+	}
+	return SourceValues(repo, app, index, opts...)
+}
+
+// SourceValues returns the values Argo CD passes to Helm for the source of app
+// with the given index, using repo as the checkout of the repository of its
+// sources (see WithRepository).
+//
+// The values are merged as Argo CD does: the value files in order, and then
+// valuesObject or, when not set, values. Maps are merged and anything else is
+// replaced; nulls are kept, as they also remove the defaults of the chart. The
+// chart defaults themselves and the Helm parameters are not included, and a
+// source without Helm settings has no values.
+//
+// Value files are relative to the path of the source in the repository, and
+// "$<ref>/file" reads a file from the root of the source with that ref.
+// Remote value files, and relative files of charts from Helm repositories,
+// cannot be resolved offline and are reported as errors, unless the source
+// ignores missing value files.
+func SourceValues(repo fs.FS, app Application, index int, opts ...Option) (*values.Values, error) {
+	options := Options{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if index < 0 || index >= len(app.Sources) {
+		return nil, fmt.Errorf("application %q: no source %d", app.Name, index)
+	}
+	src := &app.Sources[index]
+	if src.Helm == nil {
+		return values.NewValues(), nil
+	}
+
+	merged := []byte("{}")
+	for _, file := range src.Helm.ValueFiles {
+		b, err := options.readValueFile(repo, app, *src, file)
+		if errors.Is(err, fs.ErrNotExist) && src.Helm.IgnoreMissingValueFiles {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("application %q: %w", app.Name, err)
+		}
+		if merged, err = yamllib.MergeYAML(merged, b); err != nil {
+			return nil, fmt.Errorf("application %q: %s: %w", app.Name, file, err)
+		}
+	}
+
+	inline := []byte(src.Helm.Values)
+	if src.Helm.ValuesObject != nil {
+		b, err := syaml.Marshal(src.Helm.ValuesObject)
+		if err != nil {
+			return nil, err
+		}
+		inline = b
+	}
+	if len(strings.TrimSpace(string(inline))) > 0 {
+		var err error
+		if merged, err = yamllib.MergeYAML(merged, inline); err != nil {
+			return nil, fmt.Errorf("application %q: inline values: %w", app.Name, err)
+		}
+	}
+	return values.NewValuesFromYAML(merged)
+}
+
+// readValueFile reads a value file of a source.
+func (o Options) readValueFile(repo fs.FS, app Application, src Source, file string) ([]byte, error) {
+	if strings.Contains(file, "://") {
+		return nil, fmt.Errorf("%s: %w: remote value files cannot be read offline", file, fs.ErrNotExist)
+	}
+
+	fsys, name := o.repository(src.RepoURL, repo), path.Join(src.Path, file)
+	if strings.HasPrefix(file, "$") {
+		ref, rest, _ := strings.Cut(file[1:], "/")
+		refSrc, ok := findRef(app, ref)
+		if !ok {
+			return nil, fmt.Errorf("%s: no source with ref %q", file, ref)
+		}
+		fsys, name = o.repository(refSrc.RepoURL, repo), path.Clean(rest)
+	} else if src.Chart != "" {
+		return nil, fmt.Errorf("%s: %w: files of chart %q cannot be read offline", file, fs.ErrNotExist, src.Chart)
+	}
+
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("%s: outside of the repository", file)
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// findRef returns the source of app with the given ref.
+func findRef(app Application, ref string) (Source, bool) {
+	for _, s := range app.Sources {
+		if s.Ref == ref {
+			return s, true
+		}
+	}
+	return Source{}, false
+}
@@ -0,0 +1,111 @@
+package values
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tempPattern is the pattern of the temporary files atomic writes use.
+const tempPattern = ".values-*.tmp"
+
+// writeFileAtomic writes data to a temp file in the same directory and renames
+// it in place, syncing the file and then the directory, so that the file holds
+// either the previous content or the new one even after a crash.
+//
+// Existing files keep their permissions and, where the process is allowed to
+// set it, their owner; perm is only used for new files. Symbolic links are
+// resolved, so that the file they point to is written instead of replacing
+// the link.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	existing, err := os.Stat(path)
+	if err == nil {
+		perm = existing.Mode().Perm()
+	} else {
+		existing = nil
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, tempPattern)
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(name)
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if existing != nil {
+		copyOwner(tmp, existing)
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(name, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// staleTempAge is how long a temporary file must have gone unmodified before
+// it is taken for one left by an interrupted write. Younger files may belong
+// to writes in progress in other processes.
+const staleTempAge = 10 * time.Minute
+
+// removeTempFiles removes the temporary files left in dir, but not in its
+// subdirectories, by atomic writes interrupted by a crash. Missing directories
+// have nothing to remove.
+func removeTempFiles(ops FileSystem, dir string) error {
+	dir = filepath.Clean(dir)
+	return ops.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		path = filepath.Clean(path)
+		switch {
+		case err != nil && path == dir && errors.Is(err, fs.ErrNotExist):
+			return fs.SkipAll
+		case err != nil:
+			return err
+		case d.IsDir() && path != dir:
+			return fs.SkipDir
+		case d.IsDir():
+			return nil
+		}
+		return removeTempFile(ops, path, d)
+	})
+}
+
+// removeTempFile removes the file at path if it is a temporary file of an
+// atomic write that has not been modified for staleTempAge.
+func removeTempFile(ops FileSystem, path string, d fs.DirEntry) error {
+	if ok, _ := filepath.Match(tempPattern, d.Name()); !ok {
+		return nil
+	}
+	fi, err := d.Info()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if time.Since(fi.ModTime()) < staleTempAge {
+		return nil
+	}
+	if err := ops.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
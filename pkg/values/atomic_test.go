@@ -0,0 +1,164 @@
+package values
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomicKeepsMode(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions work differently on Windows")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "values.yaml")
+	mustWriteFile(t, path, []byte("a: 1\n"))
+	require.NoError(t, os.Chmod(path, 0o600))
+
+	require.NoError(t, writeFileAtomic(path, []byte("a: 2\n"), 0o644))
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	assert.Equal(t, "a: 2\n", string(mustReadFile(t, path)))
+
+	// New files get the permissions given
+	created := filepath.Join(dir, "new.yaml")
+	require.NoError(t, writeFileAtomic(created, []byte("a: 1\n"), 0o640))
+	fi, err = os.Stat(created)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.yaml")
+	link := filepath.Join(dir, "values.yaml")
+	mustWriteFile(t, target, []byte("a: 1\n"))
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symbolic links not supported: %v", err)
+	}
+
+	require.NoError(t, writeFileAtomic(link, []byte("a: 2\n"), 0o644))
+	fi, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.NotZero(t, fi.Mode()&os.ModeSymlink, "the link was replaced")
+	assert.Equal(t, "a: 2\n", string(mustReadFile(t, target)))
+}
+
+func TestExtractCommonRemovesTempFiles(t *testing.T) {
+	t.Parallel()
+
+	root, dirs := setupTempDirs(t, "a", "b", "a/sub")
+	paths := setupValuesFiles(t, dirs[:2], [][]byte{[]byte("x: 1\na: 1\n"), []byte("x: 1\nb: 1\n")})
+	stale := time.Now().Add(-2 * staleTempAge)
+	orphan := filepath.Join(dirs[0], ".values-123456.tmp")
+	mustWriteFile(t, orphan, []byte("x: 1\n"))
+	require.NoError(t, os.Chtimes(orphan, stale, stale))
+	inFlight := filepath.Join(dirs[0], ".values-654321.tmp")
+	mustWriteFile(t, inFlight, []byte("x: 1\n"))
+	other := filepath.Join(dirs[0], "values-123456.tmp")
+	mustWriteFile(t, other, []byte("x: 1\n"))
+	notWritten := filepath.Join(dirs[2], ".values-123456.tmp")
+	mustWriteFile(t, notWritten, []byte("x: 1\n"))
+	require.NoError(t, os.Chtimes(notWritten, stale, stale))
+
+	_, err := ExtractCommon(paths[0], paths[1])
+	require.NoError(t, err)
+	assertFileDoesNotExist(t, orphan)
+	assertTestFileExists(t, inFlight)
+	assertTestFileExists(t, other)
+	assertTestFileExists(t, notWritten)
+
+	// Recover removes them in the whole tree
+	mustWriteFile(t, orphan, []byte("x: 1\n"))
+	require.NoError(t, os.Chtimes(orphan, stale, stale))
+	recovered, err := Recover(root)
+	require.NoError(t, err)
+	assert.False(t, recovered)
+	assertFileDoesNotExist(t, orphan)
+	assertFileDoesNotExist(t, notWritten)
+	assertTestFileExists(t, inFlight)
+}
+
+func TestConcurrentOperationsKeepTempFiles(t *testing.T) {
+	t.Parallel()
+
+	// The operation on root writes root, root/b and root/c, while the one on
+	// root/a writes root/a and the directories below it
+	root, dirs := setupTempDirs(t, "a/x", "a/y", "b", "c")
+	for i := 0; i < 20; i++ {
+		paths := setupValuesFiles(t, dirs, [][]byte{
+			[]byte("x: 1\na: 1\n"), []byte("x: 1\na: 2\n"),
+			[]byte("y: 1\nb: 1\n"), []byte("y: 1\nb: 2\n"),
+		})
+		for _, p := range []string{filepath.Join(root, "values.yaml"), filepath.Join(root, "a", "values.yaml")} {
+			require.NoError(t, os.RemoveAll(p))
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, errs[0] = ExtractCommon(paths[0], paths[1])
+		}()
+		go func() {
+			defer wg.Done()
+			_, errs[1] = ExtractCommon(paths[2], paths[3])
+		}()
+		wg.Wait()
+		require.NoError(t, errs[0])
+		require.NoError(t, errs[1])
+		assertYAMLEqual(t, []byte("x: 1"), mustReadFile(t, filepath.Join(root, "a", "values.yaml")))
+		assertYAMLEqual(t, []byte("y: 1"), mustReadFile(t, filepath.Join(root, "values.yaml")))
+	}
+}
+
+func TestPreserveFileFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantRem string
+	}{
+		{"crlf", "x: 1\r\na: 1\r\n", "a: 1\r\n"},
+		{"bom", "\xEF\xBB\xBFx: 1\na: 1\n", "\xEF\xBB\xBFa: 1\n"},
+		{"no final newline", "x: 1\na: 1", "a: 1"},
+		{"all", "\xEF\xBB\xBFx: 1\r\na: 1", "\xEF\xBB\xBFa: 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, dirs := setupTempDirs(t, "a", "b")
+			paths := setupValuesFiles(t, dirs, [][]byte{[]byte(tt.input), []byte("x: 1\nb: 1\n")})
+
+			commonPath, err := ExtractCommon(paths[0], paths[1], WithPreserveFileFormat(true))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRem, string(mustReadFile(t, paths[0])))
+			assert.Equal(t, "b: 1\n", string(mustReadFile(t, paths[1])))
+			assert.Equal(t, "x: 1\n", string(mustReadFile(t, commonPath)))
+		})
+	}
+}
+
+func TestFileFormat(t *testing.T) {
+	t.Parallel()
+
+	f := detectFileFormat([]byte("a: 1\r\nb: 2\r\n"))
+	assert.Equal(t, fileFormat{known: true, crlf: true, finalNewline: true}, f)
+	assert.Equal(t, "c: 3\r\nd: 4\r\n", string(f.apply([]byte("c: 3\nd: 4"))))
+
+	f = detectFileFormat(nil)
+	assert.Equal(t, "c: 3\n", string(f.apply([]byte("c: 3\n"))))
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package values
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// copyOwner gives f the owner and group of the file described by fi, when the
+// process is allowed to.
+func copyOwner(f *os.File, fi fs.FileInfo) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err := f.Chown(int(st.Uid), int(st.Gid)); err != nil {
+			// Not the owner: keep at least the group, if a member of it
+			_ = f.Chown(-1, int(st.Gid))
+		}
+	}
+}
+
+// syncDir flushes the entries of the directory at dir to disk, so that the
+// files renamed in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
+	syaml "sigs.k8s.io/yaml"
+)
+
+// WithChartScopes makes the extraction aware of the Helm charts the values
+// files are for. The chart of a values file is found in the nearest Chart.yaml
+// in its directory or above it, or, for common files, in the Chart.yaml files
+// below their directory.
+//
+// Every top-level key is then scoped to a chart: the key of a dependency (by
+// name or alias) to that subchart, "global" (and the "global" of a subchart,
+// which the global values override) to the chart together with all its
+// dependencies, and any other key to the chart itself. Keys are only hoisted
+// when they are scoped to the same chart, with the same version, in every file.
+func WithChartScopes(enabled bool) Option {
+	return func(o *Options) { o.ChartScopes = enabled }
+}
+
+// chart is the identity of a chart and of its dependencies.
+type chart struct {
+	// id is the name and version of the chart.
+	id string
+	// deps are the ids of the dependencies, by the key of their values.
+	deps map[string]string
+}
+
+// chartFile holds the parts of Chart.yaml and requirements.yaml used.
+type chartFile struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Dependencies []struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Alias   string `json:"alias"`
+	} `json:"dependencies"`
+}
+
+// scope returns the scope of the values under a key path of one or two keys.
+func (c chart) scope(keys ...string) string {
+	if keys[0] == "global" || len(keys) > 1 && keys[1] == "global" {
+		ids := []string{c.id}
+		for _, id := range c.deps {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return "global:" + strings.Join(ids, ",")
+	}
+	if id, ok := c.deps[keys[0]]; ok {
+		return "chart:" + id
+	}
+	return "chart:" + c.id
+}
+
+// chartScoped returns the options extended to exclude the values whose scope
+// differs across the given values files, with yams their contents.
+func (o Options) chartScoped(paths []string, yams [][]byte) (Options, error) {
+	if !o.ChartScopes {
+		return o, nil
+	}
+	charts := make([][]chart, len(paths))
+	for i, p := range paths {
+		var err error
+		if charts[i], err = o.chartsOf(p); err != nil {
+			return o, err
+		}
+	}
+
+	// Top-level keys, and the keys holding a subchart-level global
+	keyPaths := make(map[string][]string)
+	for _, y := range yams {
+		for _, doc := range yamllib.SplitDocuments(y) {
+			var v map[string]any
+			if err := syaml.Unmarshal(doc, &v); err != nil {
+				return o, err
+			}
+			for k, vv := range v {
+				keyPaths[yamllib.LiteralPattern(k)] = []string{k}
+				if m, ok := vv.(map[string]any); ok && k != "global" {
+					if _, ok := m["global"]; ok {
+						keyPaths[yamllib.LiteralPattern(k, "global")] = []string{k, "global"}
+					}
+				}
+			}
+		}
+	}
+
+	patterns := make([]string, 0, len(keyPaths))
+	for p := range keyPaths {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	var excluded []string
+	for _, p := range patterns {
+		keys := keyPaths[p]
+		first := scopesOf(charts[0], keys)
+		same := !strings.Contains(first, "\n")
+		for _, cs := range charts[1:] {
+			same = same && scopesOf(cs, keys) == first
+		}
+		if !same {
+			excluded = append(excluded, p)
+		}
+	}
+	o.ExcludePaths = append(o.ExcludePaths[:len(o.ExcludePaths):len(o.ExcludePaths)], excluded...)
+	return o, nil
+}
+
+// scopesOf returns the distinct scopes of a key path in the given charts, one
+// per line.
+func scopesOf(charts []chart, keys []string) string {
+	seen := make(map[string]struct{})
+	var scopes []string
+	for _, c := range charts {
+		s := c.scope(keys...)
+		if _, dup := seen[s]; !dup {
+			seen[s] = struct{}{}
+			scopes = append(scopes, s)
+		}
+	}
+	sort.Strings(scopes)
+	return strings.Join(scopes, "\n")
+}
+
+// chartsOf returns the charts a values file is for: the chart in the nearest
+// Chart.yaml in its directory or above, or else the charts found below its
+// directory, as for common files.
+func (o Options) chartsOf(path string) ([]chart, error) {
+	owner := o.ownerDir(path)
+	for dir := owner; ; dir = filepath.Dir(dir) {
+		c, ok, err := o.loadChart(dir)
+		if err != nil || ok {
+			return []chart{c}, err
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	var charts []chart
+	err := o.fs.WalkDir(owner, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil || !d.IsDir() {
+			return walkErr
+		}
+		c, ok, err := o.loadChart(p)
+		if err != nil {
+			return err
+		}
+		if ok {
+			// Charts vendored below a chart are its dependencies
+			charts = append(charts, c)
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return charts, err
+}
+
+// loadChart reads the chart in dir, if there is one.
+func (o Options) loadChart(dir string) (chart, bool, error) {
+	b, err := o.fs.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return chart{}, false, nil
+	}
+	if err != nil {
+		return chart{}, false, err
+	}
+	var cf chartFile
+	if err := syaml.Unmarshal(b, &cf); err != nil {
+		return chart{}, false, fmt.Errorf("%s: %w", filepath.Join(dir, "Chart.yaml"), err)
+	}
+	if len(cf.Dependencies) == 0 {
+		// Charts with apiVersion v1 list their dependencies separately
+		if b, err := o.fs.ReadFile(filepath.Join(dir, "requirements.yaml")); err == nil {
+			if err := syaml.Unmarshal(b, &cf); err != nil {
+				return chart{}, false, fmt.Errorf("%s: %w", filepath.Join(dir, "requirements.yaml"), err)
+			}
+		}
+	}
+
+	c := chart{id: cf.Name + "@" + cf.Version, deps: make(map[string]string)}
+	for _, d := range cf.Dependencies {
+		key := d.Name
+		if d.Alias != "" {
+			key = d.Alias
+		}
+		c.deps[key] = d.Name + "@" + d.Version
+	}
+	return c, true, nil
+}
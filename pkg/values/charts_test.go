@@ -0,0 +1,92 @@
+package values
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChartScopes(t *testing.T) {
+	t.Parallel()
+
+	chart := func(version string) []byte {
+		return []byte(`apiVersion: v2
+name: umbrella
+version: ` + version + `
+dependencies:
+  - name: redis
+    version: 17.0.0
+    alias: cache
+  - name: cgw-flex-templates
+    version: 2.0.0
+`)
+	}
+	values := []byte(`replicas: 2
+global:
+  env: prod
+cache:
+  enabled: true
+cgw-flex-templates:
+  port: 8080
+  global:
+    env: prod
+`)
+	setup := func(t *testing.T) string {
+		root := t.TempDir()
+		for dir, version := range map[string]string{"a": "1.0.0", "b": "1.0.0", "c": "2.0.0"} {
+			mustWriteFile(t, filepath.Join(root, "envs", dir, "Chart.yaml"), chart(version))
+			mustWriteFile(t, filepath.Join(root, "envs", dir, "values.yaml"), values)
+		}
+		return root
+	}
+
+	t.Run("same chart", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		paths := []string{filepath.Join(root, "envs/a/values.yaml"), filepath.Join(root, "envs/b/values.yaml")}
+		commonPath, err := ExtractCommonN(paths, WithChartScopes(true))
+		require.NoError(t, err)
+		assertYAMLEqual(t, values, mustReadFile(t, commonPath))
+	})
+
+	t.Run("different chart versions", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		created, err := ExtractCommonRecursive(filepath.Join(root, "envs"), WithChartScopes(true))
+		require.NoError(t, err)
+		require.Equal(t, []string{filepath.Join(root, "envs", "values.yaml")}, created)
+
+		// Only the values of the subcharts with the same version are hoisted,
+		// without the subchart globals, as the globals of the umbrella charts,
+		// which override them, differ
+		assertYAMLEqual(t, []byte("cache:\n  enabled: true\ncgw-flex-templates:\n  port: 8080\n"), mustReadFile(t, created[0]))
+		for _, dir := range []string{"a", "b", "c"} {
+			assertYAMLEqual(t, []byte("replicas: 2\nglobal:\n  env: prod\ncgw-flex-templates:\n  global:\n    env: prod\n"),
+				mustReadFile(t, filepath.Join(root, "envs", dir, "values.yaml")))
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		created, err := ExtractCommonRecursive(filepath.Join(root, "envs"))
+		require.NoError(t, err)
+		assertYAMLEqual(t, values, mustReadFile(t, created[0]))
+	})
+
+	t.Run("common files get the scopes of the charts below", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		for _, dir := range []string{"x/a", "x/b"} {
+			mustWriteFile(t, filepath.Join(root, dir, "Chart.yaml"), chart("3.0.0"))
+			mustWriteFile(t, filepath.Join(root, dir, "values.yaml"), values)
+		}
+		created, err := ExtractCommonRecursive(root, WithChartScopes(true))
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(root, "envs", "values.yaml"), filepath.Join(root, "values.yaml"), filepath.Join(root, "x", "values.yaml")}, created)
+		assertYAMLEqual(t, []byte("cache:\n  enabled: true\ncgw-flex-templates:\n  port: 8080\n"), mustReadFile(t, filepath.Join(root, "values.yaml")))
+		assertYAMLEqual(t, []byte("replicas: 2\nglobal:\n  env: prod\ncgw-flex-templates:\n  global:\n    env: prod\n"), mustReadFile(t, filepath.Join(root, "x", "values.yaml")))
+	})
+}
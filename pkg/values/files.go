@@ -0,0 +1,263 @@
+package values
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WithValuesFile sets the name of the values files: a plain name such as
+// "values.yml", or a glob such as "values.y*ml" matched against the base name
+// of the files. Default "values.yaml".
+//
+// When the glob matches several files in the same directory, such as
+// "values-dev.yaml" and "values-prod.yaml" for "values-*.yaml", every name it
+// matches forms a tree of its own, as if given as a plain name: each
+// environment gets its own common files, named as its values files.
+func WithValuesFile(pattern string) Option {
+	return func(o *Options) { o.ValuesFile = pattern }
+}
+
+// WithCommonFile sets where the common values are written, relative to the
+// parent directory of the files they are extracted from, for example
+// "base.yaml" or "_common/values.yaml". Defaults to the values file name when
+// it is not a glob, and to "values.yaml" otherwise.
+func WithCommonFile(path string) Option {
+	return func(o *Options) { o.CommonFile = path }
+}
+
+// checkFiles validates the values file pattern and the common file path.
+func (o Options) checkFiles() error {
+	if o.ValuesFile == "" || strings.ContainsAny(o.ValuesFile, `/\`) {
+		return fmt.Errorf("invalid values file pattern %q: must be a file name", o.ValuesFile)
+	}
+	if _, err := filepath.Match(o.ValuesFile, ""); err != nil {
+		return fmt.Errorf("invalid values file pattern %q: %w", o.ValuesFile, err)
+	}
+	if !filepath.IsLocal(o.commonFile()) {
+		return fmt.Errorf("invalid common file %q: must be a relative path inside the parent directory", o.CommonFile)
+	}
+	return nil
+}
+
+// commonFile returns the path of the common file relative to its directory.
+func (o Options) commonFile() string {
+	switch {
+	case o.CommonFile != "":
+		return filepath.Clean(o.CommonFile)
+	case strings.ContainsAny(o.ValuesFile, `*?[`):
+		return "values.yaml"
+	default:
+		return o.ValuesFile
+	}
+}
+
+// commonPath returns the path of the common file of dir.
+func (o Options) commonPath(dir string) string {
+	return filepath.Join(dir, o.commonFile())
+}
+
+// ownerDir returns the directory a values file belongs to: the directory
+// holding it, or the directory it is the common file of.
+func (o Options) ownerDir(path string) string {
+	rel := string(filepath.Separator) + o.commonFile()
+	if strings.HasSuffix(path, rel) {
+		return strings.TrimSuffix(path, rel)
+	}
+	return filepath.Dir(path)
+}
+
+// isValuesFile reports whether path is a values file: a file matching the
+// values file name, or a common file.
+func (o Options) isValuesFile(path string) bool {
+	if ok, _ := filepath.Match(o.ValuesFile, filepath.Base(path)); ok {
+		return true
+	}
+	return path == o.commonPath(o.ownerDir(path))
+}
+
+// isCommonDir reports whether dir is the directory the common file of its
+// parent is written in, as "_common" for "_common/values.yaml".
+func (o Options) isCommonDir(dir string) bool {
+	first, _, nested := strings.Cut(filepath.ToSlash(o.commonFile()), "/")
+	return nested && filepath.Base(dir) == first
+}
+
+// valuesFileNames returns the names of the values files under root when a
+// directory holds several of them, so that every name forms a tree of its own
+// (see WithValuesFile), and nil when every directory holds a single one. A
+// common file cannot be shared by the trees of several names.
+func (o Options) valuesFileNames(root string) ([]string, error) {
+	byDir := make(map[string]string)
+	names := make(map[string]struct{})
+	split := false
+	if err := o.walkTree(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			if path != root && o.isCommonDir(path) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		dir := filepath.Dir(path)
+		if path == o.commonPath(dir) {
+			return nil
+		}
+		if ok, _ := filepath.Match(o.ValuesFile, d.Name()); !ok {
+			return nil
+		}
+		if prev, dup := byDir[dir]; dup {
+			if o.CommonFile != "" {
+				return fmt.Errorf("several values files in %s: %s and %s would share the common file %s", dir, prev, d.Name(), o.CommonFile)
+			}
+			split = true
+		}
+		byDir[dir] = d.Name()
+		names[d.Name()] = struct{}{}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if !split {
+		return nil, nil
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// withValuesFileName returns the options for the tree of the values files
+// named name, with common files of the same name.
+func (o Options) withValuesFileName(name string) Options {
+	o.ValuesFile = name
+	o.CommonFile = ""
+	return o
+}
+
+// valuesTree is a directory tree with the values file of every directory.
+type valuesTree struct {
+	// dirs are all the directories, in lexical order.
+	dirs []string
+	// children are the direct child directories of every directory.
+	children map[string][]string
+	// files is the values file of every directory holding one.
+	files map[string]string
+}
+
+// walkValuesTree walks the tree rooted at root looking for values files. A
+// directory holding a common file is represented by it; otherwise it can hold
+// a single file matching the values file name (see valuesFileNames). The
+// directories common files are written in are not part of the tree, and
+// neither are the ones left out by walkTree.
+func (o Options) walkValuesTree(root string) (*valuesTree, error) {
+	t := &valuesTree{children: make(map[string][]string), files: make(map[string]string)}
+	if err := o.walkTree(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !d.IsDir() {
+			dir := filepath.Dir(path)
+			if path == o.commonPath(dir) {
+				return nil
+			}
+			if ok, _ := filepath.Match(o.ValuesFile, d.Name()); !ok {
+				return nil
+			}
+			if prev, dup := t.files[dir]; dup {
+				return fmt.Errorf("several values files in %s: %s and %s (use a values file name matching one of them)", dir, filepath.Base(prev), d.Name())
+			}
+			t.files[dir] = path
+			return nil
+		}
+		if path != root && o.isCommonDir(path) {
+			return fs.SkipDir
+		}
+		t.dirs = append(t.dirs, path)
+		if path != root {
+			parent := filepath.Dir(path)
+			t.children[parent] = append(t.children[parent], path)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Strings(t.dirs)
+	for _, dir := range t.dirs {
+		if fi, err := o.fs.Stat(o.commonPath(dir)); err == nil && !fi.IsDir() {
+			t.files[dir] = o.commonPath(dir)
+		}
+	}
+	return t, nil
+}
+
+// walkTree walks the tree rooted at root as WalkDir does, leaving out what
+// the ignore files, WithMaxDepth and WithSkipChartDirs exclude, and following
+// symbolic links to directories with SymlinksFollow. Linked directories are
+// walked after the rest of the tree, and only when they were not walked yet.
+func (o Options) walkTree(root string, fn fs.WalkDirFunc) error {
+	w := o.newWalkFilter(root)
+	var walked []fs.FileInfo
+	var links []string
+	walk := func(start string) error {
+		return o.fs.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+			path = filepath.Clean(path)
+			if err != nil {
+				return fn(path, d, err)
+			}
+			skip, err := w.skip(path, d)
+			if err != nil {
+				return err
+			}
+			if skip {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 && o.Symlinks == SymlinksFollow {
+				if fi, err := o.fs.Stat(path); err == nil && fi.IsDir() {
+					links = append(links, path)
+					return nil
+				}
+			}
+			if d.IsDir() {
+				if fi, err := o.fs.Stat(path); err == nil {
+					walked = append(walked, fi)
+				}
+			}
+			return fn(path, d, nil)
+		})
+	}
+
+	if err := walk(root); err != nil {
+		return err
+	}
+	for len(links) > 0 {
+		link := links[0]
+		links = links[1:]
+		fi, err := o.fs.Stat(link)
+		if err != nil {
+			return err
+		}
+		seen := false
+		for _, w := range walked {
+			seen = seen || os.SameFile(fi, w)
+		}
+		if seen {
+			continue
+		}
+		// The trailing separator makes the walk start at the linked directory
+		if err := walk(link + string(filepath.Separator)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package values
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValuesFileNames(t *testing.T) {
+	t.Parallel()
+
+	files := map[string][]byte{
+		"envs/prod/a/values.yml": []byte("image: nginx\nreplicas: 2\nregion: eu\n"),
+		"envs/prod/b/values.yml": []byte("image: nginx\nreplicas: 3\nregion: eu\n"),
+		"envs/dev/values.yml":    []byte("image: nginx\nreplicas: 1\n"),
+	}
+	setup := func(t *testing.T) string {
+		root := t.TempDir()
+		for rel, b := range files {
+			mustWriteFile(t, filepath.Join(root, rel), b)
+		}
+		return root
+	}
+
+	t.Run("plain name", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		created, err := ExtractCommonRecursive(root, WithValuesFile("values.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(root, "envs", "prod", "values.yml"),
+			filepath.Join(root, "envs", "values.yml"),
+		}, created)
+		assertYAMLEqual(t, []byte("image: nginx"), mustReadFile(t, filepath.Join(root, "envs", "values.yml")))
+		assertYAMLEqual(t, []byte("region: eu"), mustReadFile(t, filepath.Join(root, "envs", "prod", "values.yml")))
+	})
+
+	t.Run("common file in a sibling directory", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		opts := []Option{WithValuesFile("values.y*ml"), WithCommonFile("_common/values.yaml")}
+		created, err := ExtractCommonRecursive(root, opts...)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(root, "envs", "_common", "values.yaml"),
+			filepath.Join(root, "envs", "prod", "_common", "values.yaml"),
+		}, created)
+		assertYAMLEqual(t, []byte("image: nginx"), mustReadFile(t, filepath.Join(root, "envs", "_common", "values.yaml")))
+		assertYAMLEqual(t, []byte("region: eu"), mustReadFile(t, filepath.Join(root, "envs", "prod", "_common", "values.yaml")))
+
+		written, err := InlineRecursive(root, opts...)
+		require.NoError(t, err)
+		assert.Len(t, written, len(files))
+		for rel, b := range files {
+			assertYAMLEqual(t, b, mustReadFile(t, filepath.Join(root, rel)))
+		}
+	})
+
+	t.Run("common file with another name", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		paths := []string{filepath.Join(root, "envs/prod/a/values.yml"), filepath.Join(root, "envs/prod/b/values.yml")}
+		commonPath, err := ExtractCommonN(paths, WithValuesFile("values.yml"), WithCommonFile("base.yaml"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(root, "envs", "prod", "base.yaml"), commonPath)
+		assertYAMLEqual(t, []byte("image: nginx\nregion: eu"), mustReadFile(t, commonPath))
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		_, err := ExtractCommonRecursive(root, WithCommonFile("../values.yaml"))
+		assert.ErrorContains(t, err, "invalid common file")
+		_, err = ExtractCommonRecursive(root, WithValuesFile("envs/values.yml"))
+		assert.ErrorContains(t, err, "invalid values file pattern")
+
+		mustWriteFile(t, filepath.Join(root, "envs/dev/values-extra.yml"), []byte("a: 1\n"))
+		_, err = ExtractCommonRecursive(root, WithValuesFile("values*.yml"), WithCommonFile("base.yaml"))
+		assert.ErrorContains(t, err, "several values files")
+	})
+
+	t.Run("a values file per environment", func(t *testing.T) {
+		t.Parallel()
+		root := t.TempDir()
+		for _, app := range []string{"a", "b"} {
+			mustWriteFile(t, filepath.Join(root, app, "values-dev.yaml"), []byte("image: nginx\nreplicas: 1\napp: "+app+"\n"))
+			mustWriteFile(t, filepath.Join(root, app, "values-prod.yaml"), []byte("image: nginx\nreplicas: 3\napp: "+app+"\n"))
+		}
+
+		// Every environment is a tree of its own
+		created, err := ExtractCommonRecursive(root, WithValuesFile("values-*.yaml"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(root, "values-dev.yaml"), filepath.Join(root, "values-prod.yaml")}, created)
+		assertYAMLEqual(t, []byte("image: nginx\nreplicas: 1"), mustReadFile(t, filepath.Join(root, "values-dev.yaml")))
+		assertYAMLEqual(t, []byte("image: nginx\nreplicas: 3"), mustReadFile(t, filepath.Join(root, "values-prod.yaml")))
+		assertYAMLEqual(t, []byte("app: a"), mustReadFile(t, filepath.Join(root, "a", "values-prod.yaml")))
+
+		written, err := InlineRecursive(root, WithValuesFile("values-*.yaml"))
+		require.NoError(t, err)
+		assert.Len(t, written, 4)
+		assertYAMLEqual(t, []byte("image: nginx\nreplicas: 3\napp: b"), mustReadFile(t, filepath.Join(root, "b", "values-prod.yaml")))
+		assertFileDoesNotExist(t, filepath.Join(root, "values-dev.yaml"))
+	})
+}
@@ -0,0 +1,179 @@
+package values
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileSystem is the writable filesystem the file-level functions read and
+// write values files in. Paths are OS paths, as given to the functions.
+//
+// The package provides OSFS, the default, MemFS, an in-memory filesystem,
+// OverlayFS, which writes the changes made to a read-only fs.FS in memory, and
+// BasePathFS, which confines another FileSystem to a directory. Pass them with
+// WithFileSystem. They are all fs.FS too, so they can also be given to
+// NewValuesFromFileInFS and NewValuesFromFS.
+type FileSystem interface {
+	// Stat returns the information of the file or directory at name.
+	Stat(name string) (fs.FileInfo, error)
+	// ReadFile returns the content of the file at name.
+	ReadFile(name string) ([]byte, error)
+	// WriteFileAtomic writes data to the file at path, creating its parent
+	// directories, so that readers either see the previous content or the new
+	// one.
+	WriteFileAtomic(path string, data []byte, perm fs.FileMode) error
+	// WalkDir walks the tree rooted at root as filepath.WalkDir does.
+	WalkDir(root string, fn fs.WalkDirFunc) error
+	// Remove removes the file at name.
+	Remove(name string) error
+}
+
+// OSFS is the FileSystem of the operating system.
+type OSFS struct{}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+func (OSFS) ReadFile(name string) ([]byte, error)  { return os.ReadFile(filepath.Clean(name)) }
+func (OSFS) WriteFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, perm)
+}
+func (OSFS) WalkDir(root string, fn fs.WalkDirFunc) error { return filepath.WalkDir(root, fn) }
+func (OSFS) Remove(name string) error                     { return os.Remove(name) }
+
+// Open implements fs.FS with the files of the working directory, as
+// os.DirFS(".") does.
+func (OSFS) Open(name string) (fs.File, error) { return os.DirFS(".").Open(name) }
+
+// BasePathFS is a FileSystem confined to a directory of another one, as with
+// chroot: every path is taken relative to the directory, and ".." never leads
+// out of it. Symbolic links are not resolved, so a link pointing outside of
+// the directory can still be followed by the underlying FileSystem.
+type BasePathFS struct {
+	base FileSystem
+	dir  string
+}
+
+// NewBasePathFS returns a FileSystem with the files of base under dir.
+func NewBasePathFS(base FileSystem, dir string) *BasePathFS {
+	return &BasePathFS{base: base, dir: filepath.Clean(dir)}
+}
+
+// real returns the path in the underlying FileSystem of name. A trailing
+// separator is kept, as it resolves a symbolic link to a directory.
+func (b *BasePathFS) real(name string) string {
+	real := filepath.Join(b.dir, filepath.Clean(string(filepath.Separator)+name))
+	if strings.HasSuffix(name, string(filepath.Separator)) && real != b.dir {
+		real += string(filepath.Separator)
+	}
+	return real
+}
+
+// pathError rewrites the paths of the errors of the underlying FileSystem, so
+// that they do not reveal the base directory.
+func (b *BasePathFS) pathError(err error, name string) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &fs.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+func (b *BasePathFS) Stat(name string) (fs.FileInfo, error) {
+	fi, err := b.base.Stat(b.real(name))
+	return fi, b.pathError(err, name)
+}
+
+func (b *BasePathFS) ReadFile(name string) ([]byte, error) {
+	data, err := b.base.ReadFile(b.real(name))
+	return data, b.pathError(err, name)
+}
+
+func (b *BasePathFS) WriteFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	return b.pathError(b.base.WriteFileAtomic(b.real(path), data, perm), path)
+}
+
+func (b *BasePathFS) Remove(name string) error {
+	return b.pathError(b.base.Remove(b.real(name)), name)
+}
+
+func (b *BasePathFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	realRoot := b.real(root)
+	return b.base.WalkDir(realRoot, func(path string, d fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(realRoot, path)
+		if relErr != nil {
+			return relErr
+		}
+		virtual := filepath.Join(root, rel)
+		return fn(virtual, d, b.pathError(err, virtual))
+	})
+}
+
+// Open implements fs.FS, with the files of the directory at its root.
+func (b *BasePathFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return openFile(b, filepath.FromSlash(name))
+}
+
+// openFile opens the file or directory at name in fsys, reading it whole.
+func openFile(fsys FileSystem, name string) (fs.File, error) {
+	fi, err := fsys.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		data, err := fsys.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return &memOpenFile{info: fi, r: bytes.NewReader(data)}, nil
+	}
+	dir := &memOpenDir{info: fi}
+	err = fsys.WalkDir(name, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filepath.Clean(path) == filepath.Clean(name) {
+			return nil
+		}
+		dir.entries = append(dir.entries, d)
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+// fsName returns the name in an fs.FS of an OS path: slash-separated, cleaned,
+// and relative, as absolute paths are taken from the root of the fs.FS.
+func fsName(name string) string {
+	name = strings.TrimLeft(filepath.ToSlash(filepath.Clean(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// walkFS walks an fs.FS with OS paths: the paths given to fn start with root,
+// in the form it was given.
+func walkFS(fsys fs.FS, root string, fn fs.WalkDirFunc) error {
+	fsRoot := fsName(root)
+	return fs.WalkDir(fsys, fsRoot, func(path string, d fs.DirEntry, err error) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(path, fsRoot), "/")
+		if fsRoot == "." && path != "." {
+			rel = path
+		}
+		return fn(filepath.Join(root, filepath.FromSlash(rel)), d, err)
+	})
+}
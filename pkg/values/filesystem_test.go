@@ -0,0 +1,193 @@
+package values
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFileSystem checks the basic operations of a FileSystem.
+func testFileSystem(t *testing.T, fsys FileSystem) {
+	t.Helper()
+
+	require.NoError(t, fsys.WriteFileAtomic(filepath.Join("apps", "a", "values.yaml"), []byte("a: 1\n"), 0o644))
+	require.NoError(t, fsys.WriteFileAtomic(filepath.Join("apps", "b", "values.yaml"), []byte("b: 1\n"), 0o644))
+
+	b, err := fsys.ReadFile(filepath.Join("apps", "a", "values.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "a: 1\n", string(b))
+	fi, err := fsys.Stat("apps")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	_, err = fsys.Stat(filepath.Join("apps", "c"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	var walked []string
+	require.NoError(t, fsys.WalkDir("apps", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	}))
+	assert.Equal(t, []string{
+		"apps",
+		filepath.Join("apps", "a"),
+		filepath.Join("apps", "a", "values.yaml"),
+		filepath.Join("apps", "b"),
+		filepath.Join("apps", "b", "values.yaml"),
+	}, walked)
+
+	require.NoError(t, fsys.Remove(filepath.Join("apps", "b", "values.yaml")))
+	_, err = fsys.ReadFile(filepath.Join("apps", "b", "values.yaml"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.ErrorIs(t, fsys.Remove(filepath.Join("apps", "b", "values.yaml")), fs.ErrNotExist)
+}
+
+func TestFileSystems(t *testing.T) {
+	t.Parallel()
+
+	t.Run("mem", func(t *testing.T) {
+		t.Parallel()
+		testFileSystem(t, NewMemFS())
+	})
+	t.Run("overlay", func(t *testing.T) {
+		t.Parallel()
+		testFileSystem(t, NewOverlayFS(fstest.MapFS{}))
+	})
+	t.Run("base path", func(t *testing.T) {
+		t.Parallel()
+		testFileSystem(t, NewBasePathFS(OSFS{}, t.TempDir()))
+	})
+	t.Run("base path in memory", func(t *testing.T) {
+		t.Parallel()
+		testFileSystem(t, NewBasePathFS(NewMemFS(), "/srv/repo"))
+	})
+}
+
+func TestMemFS(t *testing.T) {
+	t.Parallel()
+
+	mfs := NewMemFS()
+	writeMemFile(t, mfs, "/apps/a/values.yaml", []byte("a: 1\n"))
+	assert.Equal(t, "a: 1\n", string(readMemFile(t, mfs, "apps/a/values.yaml")))
+	entries, err := fs.ReadDir(mfs, "apps")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].IsDir())
+
+	assert.Error(t, mfs.WriteFileAtomic("apps/a/values.yaml/x", nil, 0o644))
+	assert.Error(t, mfs.WriteFileAtomic("apps/a", nil, 0o644))
+	assert.Error(t, mfs.Remove("apps/a"))
+	require.NoError(t, mfs.MkdirAll("empty/dir"))
+	require.NoError(t, mfs.Remove("empty/dir"))
+
+	v, err := NewValuesFromFileInFS(mfs, "apps/a/values.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, 1, int((*v)["a"].(float64)))
+}
+
+func TestBasePathFSIsFS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	mustWriteFile(t, filepath.Join(dir, "apps", "a", "values.yaml"), []byte("a: 1\n"))
+	mustWriteFile(t, filepath.Join(dir, "apps", "b", "values.yaml"), []byte("b: 1\n"))
+	bfs := NewBasePathFS(OSFS{}, dir)
+	var walked []string
+	require.NoError(t, fs.WalkDir(bfs, ".", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	}))
+	assert.Equal(t, []string{".", "apps", "apps/a", "apps/a/values.yaml", "apps/b", "apps/b/values.yaml"}, walked)
+	_, err := bfs.Open("../apps")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+
+	v, err := NewValuesFromFileInFS(bfs, "apps/a/values.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, Values{"a": float64(1)}, *v)
+	v, err = NewValuesFromFileInFS(bfs, "/apps/b/values.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, Values{"b": float64(1)}, *v)
+	_, err = NewValuesFromFileInFS(bfs, "apps/c/values.yaml")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// OSFS reads OS paths too
+	v, err = NewValuesFromFileInFS(OSFS{}, filepath.Join(dir, "apps", "a", "values.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, Values{"a": float64(1)}, *v)
+}
+
+func TestOverlayFS(t *testing.T) {
+	t.Parallel()
+
+	base := fstest.MapFS{
+		"apps/a/values.yaml": {Data: []byte("common: true\na: 1\n")},
+		"apps/b/values.yaml": {Data: []byte("common: true\nb: 1\n")},
+		"apps/README.md":     {Data: []byte("docs\n")},
+	}
+	overlay := NewOverlayFS(base)
+
+	commonPath, err := ExtractCommon("apps/a/values.yaml", "apps/b/values.yaml", WithFileSystem(overlay))
+	require.NoError(t, err)
+	assert.Equal(t, "apps/values.yaml", commonPath)
+	b, err := fs.ReadFile(overlay, "apps/values.yaml")
+	require.NoError(t, err)
+	assertYAMLEqual(t, []byte("common: true\n"), b)
+	b, err = fs.ReadFile(overlay, "apps/a/values.yaml")
+	require.NoError(t, err)
+	assertYAMLEqual(t, []byte("a: 1\n"), b)
+
+	// The base is never modified
+	assert.Equal(t, "common: true\na: 1\n", string(base["apps/a/values.yaml"].Data))
+	assert.NotContains(t, base, "apps/values.yaml")
+
+	// Removed files of the base are hidden, and can be written again
+	require.NoError(t, overlay.Remove("apps/README.md"))
+	_, err = overlay.Stat("apps/README.md")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	entries, err := fs.ReadDir(overlay, "apps")
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"a", "b", "values.yaml"}, names)
+	require.NoError(t, overlay.WriteFileAtomic("apps/README.md", []byte("new\n"), 0o644))
+	b, err = overlay.ReadFile("apps/README.md")
+	require.NoError(t, err)
+	assert.Equal(t, "new\n", string(b))
+	assert.Error(t, overlay.WriteFileAtomic("apps/README.md/x", nil, 0o644))
+}
+
+func TestBasePathFS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	bfs := NewBasePathFS(OSFS{}, root)
+
+	// Paths never lead out of the base directory
+	require.NoError(t, bfs.WriteFileAtomic("../../outside.yaml", []byte("a: 1\n"), 0o644))
+	assert.FileExists(t, filepath.Join(root, "outside.yaml"))
+	_, err := os.Stat(filepath.Join(dir, "outside.yaml"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// Errors do not reveal the base directory
+	_, err = bfs.ReadFile("/missing.yaml")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	assert.NotContains(t, err.Error(), root)
+
+	mustWriteFile(t, filepath.Join(root, "apps", "a", "values.yaml"), []byte("x: 1\na: 1\n"))
+	mustWriteFile(t, filepath.Join(root, "apps", "b", "values.yaml"), []byte("x: 1\nb: 1\n"))
+	created, err := ExtractCommonRecursive("/apps", WithFileSystem(bfs))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join("/apps", "values.yaml")}, created)
+	assertYAMLEqual(t, []byte("x: 1\n"), mustReadFile(t, filepath.Join(root, "apps", "values.yaml")))
+
+	v, err := NewValuesFromFile("/apps/values.yaml", WithFileSystem(bfs))
+	require.NoError(t, err)
+	assert.Equal(t, 1, int((*v)["x"].(float64)))
+}
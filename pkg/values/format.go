@@ -0,0 +1,57 @@
+package values
+
+import "bytes"
+
+// utf8BOM is the UTF-8 byte order mark.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// WithPreserveFileFormat makes the files rewritten keep the line endings (LF
+// or CRLF) they had, whether they ended with a newline, and their UTF-8 byte
+// order mark. New files are written with LF line endings, a trailing newline
+// and no byte order mark.
+func WithPreserveFileFormat(enabled bool) Option {
+	return func(o *Options) { o.PreserveFileFormat = enabled }
+}
+
+// fileFormat is the format of a text file.
+type fileFormat struct {
+	// known is false for files without content, whose format is unknown.
+	known        bool
+	bom          bool
+	crlf         bool
+	finalNewline bool
+}
+
+// detectFileFormat returns the format of the content of a file.
+func detectFileFormat(b []byte) fileFormat {
+	f := fileFormat{bom: bytes.HasPrefix(b, utf8BOM)}
+	b = bytes.TrimPrefix(b, utf8BOM)
+	if len(b) == 0 {
+		return f
+	}
+	f.known = true
+	if i := bytes.IndexByte(b, '\n'); i > 0 && b[i-1] == '\r' {
+		f.crlf = true
+	}
+	f.finalNewline = b[len(b)-1] == '\n'
+	return f
+}
+
+// apply returns b, written with LF line endings, in the format f.
+func (f fileFormat) apply(b []byte) []byte {
+	b = bytes.TrimPrefix(b, utf8BOM)
+	if f.known && len(b) > 0 {
+		if !f.finalNewline {
+			b = bytes.TrimRight(b, "\r\n")
+		} else if b[len(b)-1] != '\n' {
+			b = append(b[:len(b):len(b)], '\n')
+		}
+		if f.crlf {
+			b = bytes.ReplaceAll(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+		}
+	}
+	if f.bom {
+		b = append(append([]byte{}, utf8BOM...), b...)
+	}
+	return b
+}
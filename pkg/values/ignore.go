@@ -0,0 +1,234 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFileName is the name of the files listing, with the syntax of
+// .gitignore, the files and directories the recursive functions skip. They are
+// honored at any level of the tree, and their patterns are relative to the
+// directory holding them.
+const IgnoreFileName = ".valuesignore"
+
+// SymlinkPolicy is how the recursive functions treat symbolic links.
+type SymlinkPolicy int
+
+const (
+	// SymlinksKeep uses the links to files as the files they point to, and
+	// does not walk into the directories links point to.
+	SymlinksKeep SymlinkPolicy = iota
+	// SymlinksFollow also walks into the directories links point to, once
+	// per directory, skipping the links that lead back to a directory being
+	// walked.
+	SymlinksFollow
+	// SymlinksRefuse fails when a link is found.
+	SymlinksRefuse
+)
+
+// WithMaxDepth limits the directories the recursive functions walk to n levels
+// below root. Default 0, no limit.
+func WithMaxDepth(n int) Option {
+	return func(o *Options) { o.MaxDepth = n }
+}
+
+// WithSymlinks sets how the recursive functions treat symbolic links. Default
+// SymlinksKeep.
+func WithSymlinks(policy SymlinkPolicy) Option {
+	return func(o *Options) { o.Symlinks = policy }
+}
+
+// WithSkipChartDirs makes the recursive functions skip the directories holding
+// a Chart.yaml, below root, with everything under them, such as the templates
+// and the vendored charts of a Helm chart.
+func WithSkipChartDirs(skip bool) Option {
+	return func(o *Options) { o.SkipChartDirs = skip }
+}
+
+// ignoreFile holds the patterns of an ignore file.
+type ignoreFile struct {
+	patterns []ignorePattern
+}
+
+// ignorePattern is a pattern of an ignore file.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// parseIgnoreFile parses the content of an ignore file.
+func parseIgnoreFile(b []byte) (*ignoreFile, error) {
+	f := &ignoreFile{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line[:len(line)-2], " ") + `\ `
+		} else {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate, line = true, line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		// Patterns with a slash other than a trailing one are relative to
+		// the directory of the file; the others match at any level
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		expr, err := globToRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if !anchored {
+			expr = "(.*/)?" + expr
+		}
+		if p.re, err = regexp.Compile("^" + expr + "$"); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		f.patterns = append(f.patterns, p)
+	}
+	return f, nil
+}
+
+// globToRegexp translates a gitignore glob into a regular expression.
+func globToRegexp(glob string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case glob[i:] == "**" && i > 0 && glob[i-1] == '/':
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated character class in %q", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String(), nil
+}
+
+// match reports whether the file at rel, relative to the directory of the
+// ignore file, is ignored, and whether any pattern decided it.
+func (f *ignoreFile) match(rel string, isDir bool) (ignored, matched bool) {
+	for i := len(f.patterns) - 1; i >= 0; i-- {
+		p := f.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			return !p.negate, true
+		}
+	}
+	return false, false
+}
+
+// walkFilter decides which files and directories of a tree are walked,
+// following the ignore files, the maximum depth, the symbolic link policy and
+// the chart directories.
+type walkFilter struct {
+	o       Options
+	root    string
+	ignores map[string]*ignoreFile
+}
+
+func (o Options) newWalkFilter(root string) *walkFilter {
+	return &walkFilter{o: o, root: filepath.Clean(root), ignores: make(map[string]*ignoreFile)}
+}
+
+// skip reports whether the entry at path is left out of the walk.
+func (w *walkFilter) skip(path string, d fs.DirEntry) (bool, error) {
+	path = filepath.Clean(path)
+	if d.Type()&fs.ModeSymlink != 0 && w.o.Symlinks == SymlinksRefuse {
+		return false, fmt.Errorf("symbolic link found: %s", path)
+	}
+	if path == w.root {
+		return false, w.enter(path)
+	}
+
+	isDir := d.IsDir()
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if f, ok := w.ignores[dir]; ok {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return false, err
+			}
+			if ignored, matched := f.match(filepath.ToSlash(rel), isDir); matched {
+				if ignored {
+					return true, nil
+				}
+				break
+			}
+		}
+		if dir == w.root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	if !isDir {
+		return false, nil
+	}
+
+	if w.o.MaxDepth > 0 {
+		rel, err := filepath.Rel(w.root, path)
+		if err != nil {
+			return false, err
+		}
+		if strings.Count(filepath.ToSlash(rel), "/")+1 > w.o.MaxDepth {
+			return true, nil
+		}
+	}
+	if w.o.SkipChartDirs {
+		if fi, err := w.o.fs.Stat(filepath.Join(path, "Chart.yaml")); err == nil && !fi.IsDir() {
+			return true, nil
+		}
+	}
+	return false, w.enter(path)
+}
+
+// enter loads the ignore file of a directory about to be walked.
+func (w *walkFilter) enter(dir string) error {
+	b, err := w.o.fs.ReadFile(filepath.Join(dir, IgnoreFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f, err := parseIgnoreFile(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Join(dir, IgnoreFileName), err)
+	}
+	w.ignores[dir] = f
+	return nil
+}
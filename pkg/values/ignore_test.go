@@ -0,0 +1,153 @@
+package values
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreFilePatterns(t *testing.T) {
+	t.Parallel()
+
+	f, err := parseIgnoreFile([]byte(`# scratch folders
+scratch/
+*.tmp.yaml
+/top
+docs/**/values.yaml
+!keep.tmp.yaml
+\#literal
+env-[!p]*
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"scratch", true, true},
+		{"a/b/scratch", true, true},
+		{"scratch", false, false},
+		{"a/x.tmp.yaml", false, true},
+		{"a/keep.tmp.yaml", false, false},
+		{"top", true, true},
+		{"a/top", true, false},
+		{"docs/values.yaml", false, true},
+		{"docs/a/b/values.yaml", false, true},
+		{"a/docs/values.yaml", false, false},
+		{"#literal", false, true},
+		{"env-dev", true, true},
+		{"env-prod", true, false},
+		{"values.yaml", false, false},
+	}
+	for _, tt := range tests {
+		ignored, _ := f.match(tt.path, tt.isDir)
+		assert.Equal(t, tt.ignored, ignored, tt.path)
+	}
+
+	_, err = parseIgnoreFile([]byte("[abc\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestExtractCommonRecursiveIgnore(t *testing.T) {
+	t.Parallel()
+
+	root, dirs := setupTempDirs(t, "apps/a", "apps/b", "apps/scratch", "apps/b/old", ".git/x")
+	setupValuesFiles(t, dirs, [][]byte{
+		[]byte("x: 1\na: 1\n"),
+		[]byte("x: 1\nb: 1\n"),
+		[]byte("x: 1\ny: 1\n"),
+		[]byte("x: 1\ny: 1\n"),
+		[]byte("x: 1\ny: 1\n"),
+	})
+	mustWriteFile(t, filepath.Join(root, IgnoreFileName), []byte(".git/\n"))
+	mustWriteFile(t, filepath.Join(root, "apps", IgnoreFileName), []byte("scratch\n"))
+	mustWriteFile(t, filepath.Join(root, "apps", "b", IgnoreFileName), []byte("/old/\n"))
+
+	created, err := ExtractCommonRecursive(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "apps", "values.yaml")}, created)
+	assertYAMLEqual(t, []byte("a: 1\n"), mustReadFile(t, filepath.Join(root, "apps", "a", "values.yaml")))
+	for _, d := range dirs[2:] {
+		assert.Equal(t, "x: 1\ny: 1\n", string(mustReadFile(t, filepath.Join(d, "values.yaml"))))
+	}
+}
+
+func TestExtractCommonRecursiveWalkOptions(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T) string {
+		root, dirs := setupTempDirs(t, "a", "b", "c/deep", "c/deeper/x")
+		setupValuesFiles(t, dirs, [][]byte{
+			[]byte("x: 1\na: 1\n"),
+			[]byte("x: 1\nb: 1\n"),
+			[]byte("x: 1\nc: 1\n"),
+			[]byte("x: 1\nd: 1\n"),
+		})
+		mustWriteFile(t, filepath.Join(root, "b", "Chart.yaml"), []byte("name: b\nversion: 1.0.0\n"))
+		return root
+	}
+
+	t.Run("max depth", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		_, err := ExtractCommonRecursive(root, WithMaxDepth(1))
+		require.NoError(t, err)
+		assertYAMLEqual(t, []byte("x: 1\n"), mustReadFile(t, filepath.Join(root, "values.yaml")))
+		assert.Equal(t, "x: 1\nc: 1\n", string(mustReadFile(t, filepath.Join(root, "c", "deep", "values.yaml"))))
+	})
+
+	t.Run("chart directories", func(t *testing.T) {
+		t.Parallel()
+		root := setup(t)
+		_, err := ExtractCommonRecursive(root, WithSkipChartDirs(true))
+		require.NoError(t, err)
+		assert.Equal(t, "x: 1\nb: 1\n", string(mustReadFile(t, filepath.Join(root, "b", "values.yaml"))))
+		assertYAMLEqual(t, []byte("a: 1\n"), mustReadFile(t, filepath.Join(root, "a", "values.yaml")))
+	})
+}
+
+func TestExtractCommonRecursiveSymlinks(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T) (string, string) {
+		outside, dirs := setupTempDirs(t, "shared")
+		setupValuesFiles(t, dirs, [][]byte{[]byte("x: 1\ns: 1\n")})
+		root, dirs := setupTempDirs(t, "a", "b")
+		setupValuesFiles(t, dirs, [][]byte{[]byte("x: 1\na: 1\n"), []byte("x: 1\nb: 1\n")})
+		if err := os.Symlink(filepath.Join(outside, "shared"), filepath.Join(root, "shared")); err != nil {
+			t.Skipf("symbolic links not supported: %v", err)
+		}
+		// A link back to the root is never followed
+		require.NoError(t, os.Symlink(root, filepath.Join(root, "a", "loop")))
+		return root, filepath.Join(outside, "shared", "values.yaml")
+	}
+
+	t.Run("keep", func(t *testing.T) {
+		t.Parallel()
+		root, shared := setup(t)
+		_, err := ExtractCommonRecursive(root)
+		require.NoError(t, err)
+		assert.Equal(t, "x: 1\ns: 1\n", string(mustReadFile(t, shared)))
+		assertYAMLEqual(t, []byte("x: 1\n"), mustReadFile(t, filepath.Join(root, "values.yaml")))
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		t.Parallel()
+		root, shared := setup(t)
+		_, err := ExtractCommonRecursive(root, WithSymlinks(SymlinksFollow))
+		require.NoError(t, err)
+		assertYAMLEqual(t, []byte("s: 1\n"), mustReadFile(t, shared))
+		assertYAMLEqual(t, []byte("x: 1\n"), mustReadFile(t, filepath.Join(root, "values.yaml")))
+	})
+
+	t.Run("refuse", func(t *testing.T) {
+		t.Parallel()
+		root, _ := setup(t)
+		_, err := ExtractCommonRecursive(root, WithSymlinks(SymlinksRefuse))
+		assert.ErrorContains(t, err, "symbolic link found")
+	})
+}
@@ -0,0 +1,269 @@
+package values
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
+	syaml "sigs.k8s.io/yaml"
+)
+
+// WithOutputDir makes InlineRecursive write the inlined files into dir,
+// mirroring the layout of the tree, instead of rewriting the tree in place.
+func WithOutputDir(dir string) Option {
+	return func(o *Options) { o.OutputDir = dir }
+}
+
+// InlineRecursive is the inverse of ExtractCommonRecursive: it materializes the
+// effective values of every leaf values.yaml under root, that is, of every
+// values.yaml without another values.yaml below it. Values files are found as
+// in ExtractCommonRecursive, following WithValuesFile and WithCommonFile.
+//
+// For each leaf, the values.yaml files found from root down to the leaf are
+// merged in that order with Helm semantics (maps are merged, anything else is
+// replaced, and a null deletes the value). Nulls are kept in the result, as they
+// also remove the defaults of the chart. Multi-document files are merged
+// document by document, pairing them by position or by WithStreamMatchKey.
+//
+// By default the leaves are rewritten in place and the values.yaml files of
+// their ancestors are removed. With WithOutputDir the results are written into
+// a separate directory mirroring the tree, and root is left untouched.
+//
+// Returns the sorted list of files written.
+func InlineRecursive(root string, opts ...Option) ([]string, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	root = filepath.Clean(root)
+	if err := options.checkFiles(); err != nil {
+		return nil, err
+	}
+	options, commit, err := options.begin(root)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := options.fs.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("root is not a directory: %s", root)
+	}
+
+	leaves, parents, err := options.effectiveLeaves(root)
+	if err != nil {
+		return nil, err
+	}
+
+	written := make([]string, 0, len(leaves))
+	for _, leaf := range leaves {
+		target := leaf.path
+		if options.OutputDir != "" {
+			rel, err := filepath.Rel(root, target)
+			if err != nil {
+				return nil, err
+			}
+			target = filepath.Join(options.OutputDir, rel)
+		}
+		if err := options.fs.WriteFileAtomic(target, leaf.values, 0o644); err != nil {
+			return nil, err
+		}
+		written = append(written, target)
+	}
+
+	if options.OutputDir == "" {
+		for _, p := range parents {
+			if err := options.fs.Remove(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := commit(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(written)
+	return written, nil
+}
+
+// leafValues holds the effective values of a leaf values file.
+type leafValues struct {
+	path   string
+	values []byte
+}
+
+// effectiveLeaves returns the effective values of every leaf values file under
+// root, in lexical order of their directories, and the values files of their
+// ancestors. See InlineRecursive.
+func (o Options) effectiveLeaves(root string) ([]leafValues, []string, error) {
+	// Directories with several values files form a tree per file name
+	names, err := o.valuesFileNames(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if names != nil {
+		var leaves []leafValues
+		var parents []string
+		for _, name := range names {
+			l, p, err := o.withValuesFileName(name).effectiveLeaves(root)
+			if err != nil {
+				return nil, nil, err
+			}
+			leaves = append(leaves, l...)
+			parents = append(parents, p...)
+		}
+		sort.Slice(leaves, func(i, j int) bool { return leaves[i].path < leaves[j].path })
+		return leaves, parents, nil
+	}
+
+	// Discover the directories holding a values file
+	tree, err := o.walkValuesTree(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	var valueDirs []string
+	hasValues := make(map[string]bool)
+	for _, dir := range tree.dirs {
+		if _, ok := tree.files[dir]; ok {
+			hasValues[dir] = true
+			valueDirs = append(valueDirs, dir)
+		}
+	}
+
+	// Leaves are the directories without values.yaml files below them
+	isParent := make(map[string]bool)
+	for _, dir := range valueDirs {
+		for cur := dir; cur != root; {
+			cur = filepath.Dir(cur)
+			if hasValues[cur] {
+				isParent[cur] = true
+			}
+			if cur == filepath.Dir(cur) {
+				break
+			}
+		}
+	}
+
+	contents := make(map[string][]byte, len(valueDirs))
+	for _, dir := range valueDirs {
+		b, err := o.fs.ReadFile(tree.files[dir])
+		if err != nil {
+			return nil, nil, err
+		}
+		contents[dir] = b
+	}
+
+	var leaves []leafValues
+	var parents []string
+	for _, dir := range valueDirs {
+		if isParent[dir] {
+			parents = append(parents, tree.files[dir])
+			continue
+		}
+		chain := [][]byte{contents[dir]}
+		for cur := dir; cur != root && cur != filepath.Dir(cur); {
+			cur = filepath.Dir(cur)
+			if hasValues[cur] {
+				chain = append([][]byte{contents[cur]}, chain...)
+			}
+		}
+		merged, err := o.inlineChain(chain)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", tree.files[dir], err)
+		}
+		leaves = append(leaves, leafValues{path: tree.files[dir], values: merged})
+	}
+	return leaves, parents, nil
+}
+
+// inlineChain merges the files of a chain, ordered from the root to the leaf.
+// The result keeps the documents of the leaf, each one merged with the
+// documents paired with it in the ancestors.
+func (o Options) inlineChain(chain [][]byte) ([]byte, error) {
+	docs := make([][][]byte, len(chain))
+	for i, c := range chain {
+		docs[i] = yamllib.SplitDocuments(c)
+	}
+	leafDocs := docs[len(docs)-1]
+
+	out := make([][]byte, len(leafDocs))
+	for i, leafDoc := range leafDocs {
+		key, err := o.documentKey(leafDoc)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		merged := []byte("{}\n")
+		for _, ancestorDocs := range docs[:len(docs)-1] {
+			if merged, err = o.mergeDocument(merged, o.pairedDocument(ancestorDocs, i, key)); err != nil {
+				return nil, err
+			}
+		}
+		if out[i], err = o.mergeDocument(merged, leafDoc); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+	return yamllib.JoinDocuments(out), nil
+}
+
+// mergeDocument merges overlay over base, skipping empty overlays.
+func (o Options) mergeDocument(base, overlay []byte) ([]byte, error) {
+	var v any
+	if err := syaml.Unmarshal(overlay, &v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return base, nil
+	}
+	return yamllib.MergeYAML(base, overlay, o.yamlOptions()...)
+}
+
+// documentKey returns the value used to pair a document with the documents of
+// other files, or "" when documents are paired by position. The value is the
+// JSON encoding of the match key, so values of different types, such as 1 and
+// "1", do not pair.
+func (o Options) documentKey(doc []byte) (string, error) {
+	if o.StreamMatchKey == "" {
+		return "", nil
+	}
+	var v any
+	if err := syaml.Unmarshal(doc, &v); err != nil {
+		return "", err
+	}
+	for _, k := range strings.Split(o.StreamMatchKey, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("no value at %q", o.StreamMatchKey)
+		}
+		if v, ok = m[k]; !ok {
+			return "", fmt.Errorf("no value at %q", o.StreamMatchKey)
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("value at %q: %w", o.StreamMatchKey, err)
+	}
+	return string(b), nil
+}
+
+// pairedDocument returns the document of docs paired with the leaf document at
+// index i, or nil when there is none.
+func (o Options) pairedDocument(docs [][]byte, i int, key string) []byte {
+	if o.StreamMatchKey == "" {
+		if i < len(docs) {
+			return docs[i]
+		}
+		return nil
+	}
+	for _, doc := range docs {
+		if k, err := o.documentKey(doc); err == nil && k == key {
+			return doc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package values
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInlineRecursive(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("image:\n  repository: nginx\n  tag: \"1.2\"\nreplicas: 3\nregion: eu-1\n"),
+		[]byte("image:\n  repository: nginx\n  tag: \"1.3\"\nreplicas: 3\nregion: eu-2\n"),
+		[]byte("image:\n  repository: nginx\n  tag: \"1.2\"\nreplicas: 5\n"),
+	}
+	dirs := []string{"envs/prod/a", "envs/prod/b", "envs/dev"}
+
+	t.Run("in place undoes an extraction", func(t *testing.T) {
+		t.Parallel()
+		root, fullDirs := setupTempDirs(t, dirs...)
+		paths := setupValuesFiles(t, fullDirs, inputs)
+
+		created, err := ExtractCommonRecursive(root)
+		require.NoError(t, err)
+		require.NotEmpty(t, created)
+
+		written, err := InlineRecursive(root)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, paths, written)
+		for i, p := range paths {
+			assertYAMLEqual(t, inputs[i], mustReadFile(t, p))
+		}
+		for _, c := range created {
+			_, err := os.Stat(c)
+			assert.True(t, os.IsNotExist(err), "parent %s should be removed", c)
+		}
+	})
+
+	t.Run("into an output directory", func(t *testing.T) {
+		t.Parallel()
+		root, fullDirs := setupTempDirs(t, dirs...)
+		paths := setupValuesFiles(t, fullDirs, inputs)
+		_, err := ExtractCommonRecursive(root)
+		require.NoError(t, err)
+
+		out := t.TempDir()
+		written, err := InlineRecursive(root, WithOutputDir(out))
+		require.NoError(t, err)
+		require.Len(t, written, len(paths))
+		for i, d := range dirs {
+			assertYAMLEqual(t, inputs[i], mustReadFile(t, filepath.Join(out, d, "values.yaml")))
+		}
+		// The tree is left untouched.
+		_, err = os.Stat(filepath.Join(root, "envs", "values.yaml"))
+		assert.NoError(t, err)
+	})
+
+	t.Run("nulls delete inherited values", func(t *testing.T) {
+		t.Parallel()
+		root, fullDirs := setupTempDirs(t, "a")
+		setupValuesFiles(t, []string{root, fullDirs[0]}, [][]byte{
+			[]byte("image:\n  tag: v1\n  pullPolicy: Always\nreplicas: 2\n"),
+			[]byte("image:\n  pullPolicy: null\nreplicas: 3\n"),
+		})
+		_, err := InlineRecursive(root)
+		require.NoError(t, err)
+		assertYAMLEqual(t, []byte("image:\n  tag: v1\n  pullPolicy: null\nreplicas: 3\n"), mustReadFile(t, filepath.Join(fullDirs[0], "values.yaml")))
+	})
+
+	t.Run("streams paired by key", func(t *testing.T) {
+		t.Parallel()
+		root, fullDirs := setupTempDirs(t, "a")
+		setupValuesFiles(t, []string{root, fullDirs[0]}, [][]byte{
+			[]byte("name: web\nport: 80\n---\nname: api\nreplicas: 2\n"),
+			[]byte("name: api\nreplicas: 3\n---\nname: web\n"),
+		})
+		_, err := InlineRecursive(root, WithStreamMatchKey("name"))
+		require.NoError(t, err)
+		equal, err := yamllib.EqualYAMLStreams([]byte("name: api\nreplicas: 3\n---\nname: web\nport: 80\n"), mustReadFile(t, filepath.Join(fullDirs[0], "values.yaml")))
+		require.NoError(t, err)
+		assert.True(t, equal)
+	})
+
+	t.Run("keys of different types do not pair", func(t *testing.T) {
+		t.Parallel()
+		root, fullDirs := setupTempDirs(t, "a")
+		setupValuesFiles(t, []string{root, fullDirs[0]}, [][]byte{
+			[]byte("id: 1\nport: 80\n---\nid: \"2\"\nport: 81\n"),
+			[]byte("id: \"1\"\nreplicas: 3\n---\nid: \"2\"\n"),
+		})
+		_, err := InlineRecursive(root, WithStreamMatchKey("id"))
+		require.NoError(t, err)
+		equal, err := yamllib.EqualYAMLStreams([]byte("id: \"1\"\nreplicas: 3\n---\nid: \"2\"\nport: 81\n"), mustReadFile(t, filepath.Join(fullDirs[0], "values.yaml")))
+		require.NoError(t, err)
+		assert.True(t, equal)
+	})
+}
@@ -0,0 +1,328 @@
+package values
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
+	syaml "sigs.k8s.io/yaml"
+)
+
+// LockFileName is the conventional name of a lock file, kept at the root of
+// the tree it describes.
+const LockFileName = "values.lock"
+
+// lockVersion is the version of the lock file format.
+const lockVersion = 1
+
+// Lock records the effective values of every leaf of a tree, as
+// InlineRecursive materializes them, so that a refactoring of the tree can be
+// proven not to change them. See NewLock and Verify.
+type Lock struct {
+	Version int          `json:"version"`
+	Leaves  []LockedLeaf `json:"leaves"`
+}
+
+// LockedLeaf is the effective values of a leaf values file.
+type LockedLeaf struct {
+	// Path is the path of the file, relative to the root of the tree and
+	// with forward slashes.
+	Path string `json:"path"`
+	// Hash is the SHA-256 of the canonical form of the values, with keys
+	// sorted and without comments, as "sha256:<hex>".
+	Hash string `json:"hash"`
+	// Values is the canonical form of the values, only recorded with
+	// WithLockValues.
+	Values string `json:"values,omitempty"`
+}
+
+// WithLockValues makes NewLock record the effective values of every leaf
+// along with their hash, so that Verify can tell which values changed.
+func WithLockValues(enabled bool) Option {
+	return func(o *Options) { o.LockValues = enabled }
+}
+
+// NewLock computes the lock of the tree rooted at root. Values files are
+// found as in InlineRecursive, following WithValuesFile and WithCommonFile.
+func NewLock(root string, opts ...Option) (*Lock, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	root = filepath.Clean(root)
+	leaves, err := options.canonicalLeaves(root)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{Version: lockVersion, Leaves: make([]LockedLeaf, 0, len(leaves))}
+	for _, l := range leaves {
+		locked := LockedLeaf{Path: l.path, Hash: canonicalHash(l.values)}
+		if options.LockValues {
+			locked.Values = string(l.values)
+		}
+		lock.Leaves = append(lock.Leaves, locked)
+	}
+	return lock, nil
+}
+
+// ParseLock parses a lock file.
+func ParseLock(b []byte) (*Lock, error) {
+	var lock Lock
+	if err := syaml.UnmarshalStrict(b, &lock); err != nil {
+		return nil, err
+	}
+	if lock.Version != lockVersion {
+		return nil, fmt.Errorf("unsupported lock file version %d", lock.Version)
+	}
+	return &lock, nil
+}
+
+// Marshal returns the lock file, as YAML.
+func (l *Lock) Marshal() ([]byte, error) {
+	return syaml.Marshal(l)
+}
+
+// DiffKind is the kind of difference Verify finds.
+type DiffKind string
+
+const (
+	// DiffAdded is a leaf, or a value, that is not in the lock.
+	DiffAdded DiffKind = "added"
+	// DiffRemoved is a leaf, or a value, of the lock that no longer exists.
+	DiffRemoved DiffKind = "removed"
+	// DiffChanged is a leaf, or a value, that is different from the lock.
+	DiffChanged DiffKind = "changed"
+)
+
+// LeafDiff is a leaf whose effective values differ from the lock.
+type LeafDiff struct {
+	// Path is the path of the leaf, as in the lock.
+	Path string   `json:"path"`
+	Kind DiffKind `json:"kind"`
+	// Values are the values that differ, sorted by document and path. They
+	// are only known when the lock records the values of the leaf.
+	Values []ValueDiff `json:"values,omitempty"`
+}
+
+// ValueDiff is a value that differs from the lock.
+type ValueDiff struct {
+	// Document is the index of the document for multi-document files.
+	Document int `json:"document,omitempty"`
+	// Path is the dotted key path of the value, for example "image.tag".
+	// Lists are compared as a whole.
+	Path string   `json:"path"`
+	Kind DiffKind `json:"kind"`
+	// Before is the value in the lock, nil when added.
+	Before any `json:"before,omitempty"`
+	// After is the current value, nil when removed.
+	After any `json:"after,omitempty"`
+}
+
+// String returns the difference as a line per value, prefixed by the leaf.
+func (d LeafDiff) String() string {
+	if len(d.Values) == 0 {
+		return fmt.Sprintf("%s: %s", d.Path, d.Kind)
+	}
+	var sb strings.Builder
+	for i, v := range d.Values {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		path := v.Path
+		if v.Document > 0 {
+			path = fmt.Sprintf("[%d].%s", v.Document, v.Path)
+		}
+		fmt.Fprintf(&sb, "%s: %s %s", d.Path, path, v.Kind)
+		switch v.Kind {
+		case DiffAdded:
+			fmt.Fprintf(&sb, ": %s", renderValue(v.After))
+		case DiffRemoved:
+			fmt.Fprintf(&sb, ": %s", renderValue(v.Before))
+		default:
+			fmt.Fprintf(&sb, ": %s -> %s", renderValue(v.Before), renderValue(v.After))
+		}
+	}
+	return sb.String()
+}
+
+// Verify recomputes the effective values of the leaves of the tree rooted at
+// root and returns the leaves that differ from lock, sorted by path. It
+// returns no differences when the tree is equivalent to the one locked, even
+// if its values were moved between files, as ExtractCommonRecursive and
+// Rebalance do.
+func Verify(root string, lock *Lock, opts ...Option) ([]LeafDiff, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	root = filepath.Clean(root)
+	leaves, err := options.canonicalLeaves(root)
+	if err != nil {
+		return nil, err
+	}
+
+	locked := make(map[string]LockedLeaf, len(lock.Leaves))
+	for _, l := range lock.Leaves {
+		locked[l.Path] = l
+	}
+	var diffs []LeafDiff
+	for _, l := range leaves {
+		prev, ok := locked[l.path]
+		delete(locked, l.path)
+		switch {
+		case !ok:
+			diffs = append(diffs, LeafDiff{Path: l.path, Kind: DiffAdded})
+		case prev.Hash != canonicalHash(l.values):
+			d := LeafDiff{Path: l.path, Kind: DiffChanged}
+			if prev.Values != "" {
+				if d.Values, err = diffValues([]byte(prev.Values), l.values); err != nil {
+					return nil, fmt.Errorf("%s: %w", l.path, err)
+				}
+			}
+			diffs = append(diffs, d)
+		}
+	}
+	for path := range locked {
+		diffs = append(diffs, LeafDiff{Path: path, Kind: DiffRemoved})
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs, nil
+}
+
+// canonicalLeaves returns the effective values of the leaves under root in
+// canonical form, with their paths relative to root.
+func (o Options) canonicalLeaves(root string) ([]leafValues, error) {
+	if err := o.checkFiles(); err != nil {
+		return nil, err
+	}
+	leaves, _, err := o.effectiveLeaves(root)
+	if err != nil {
+		return nil, err
+	}
+	for i, l := range leaves {
+		rel, err := filepath.Rel(root, l.path)
+		if err != nil {
+			return nil, err
+		}
+		canonical, err := canonicalValues(l.values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.path, err)
+		}
+		leaves[i] = leafValues{path: filepath.ToSlash(rel), values: canonical}
+	}
+	return leaves, nil
+}
+
+// canonicalValues returns the values of a stream with the keys sorted and
+// without comments or formatting.
+func canonicalValues(b []byte) ([]byte, error) {
+	docs := yamllib.SplitDocuments(b)
+	for i, doc := range docs {
+		var v any
+		if err := syaml.Unmarshal(doc, &v); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if v == nil {
+			v = map[string]any{}
+		}
+		out, err := syaml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = out
+	}
+	return yamllib.JoinDocuments(docs), nil
+}
+
+// canonicalHash returns the hash of values in canonical form.
+func canonicalHash(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// diffValues returns the values that differ between two canonical streams.
+func diffValues(before, after []byte) ([]ValueDiff, error) {
+	beforeDocs, afterDocs := yamllib.SplitDocuments(before), yamllib.SplitDocuments(after)
+	var diffs []ValueDiff
+	for i := 0; i < len(beforeDocs) || i < len(afterDocs); i++ {
+		b, a := make(map[string]any), make(map[string]any)
+		if i < len(beforeDocs) {
+			if err := flattenDocument(beforeDocs[i], b); err != nil {
+				return nil, err
+			}
+		}
+		if i < len(afterDocs) {
+			if err := flattenDocument(afterDocs[i], a); err != nil {
+				return nil, err
+			}
+		}
+		var paths []string
+		for p := range b {
+			paths = append(paths, p)
+		}
+		for p := range a {
+			if _, ok := b[p]; !ok {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			bv, inBefore := b[p]
+			av, inAfter := a[p]
+			switch {
+			case !inBefore:
+				diffs = append(diffs, ValueDiff{Document: i, Path: p, Kind: DiffAdded, After: av})
+			case !inAfter:
+				diffs = append(diffs, ValueDiff{Document: i, Path: p, Kind: DiffRemoved, Before: bv})
+			case renderValue(bv) != renderValue(av):
+				diffs = append(diffs, ValueDiff{Document: i, Path: p, Kind: DiffChanged, Before: bv, After: av})
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// flattenDocument stores in out the leaves of a document by dotted key path.
+func flattenDocument(doc []byte, out map[string]any) error {
+	var v any
+	if err := syaml.Unmarshal(doc, &v); err != nil {
+		return err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		if v != nil {
+			out[""] = v
+		}
+		return nil
+	}
+	flattenLeaves(m, "", out)
+	return nil
+}
+
+// flattenLeaves stores in out the leaves of m under their dotted key paths,
+// escaped as key path patterns. Empty maps are leaves.
+func flattenLeaves(m map[string]any, prefix string, out map[string]any) {
+	for k, v := range m {
+		path := prefix + yamllib.LiteralPattern(k)
+		if sub, ok := v.(map[string]any); ok && len(sub) > 0 {
+			flattenLeaves(sub, path+".", out)
+			continue
+		}
+		out[path] = v
+	}
+}
+
+// renderValue renders a value in a single line.
+func renderValue(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
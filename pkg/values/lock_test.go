@@ -0,0 +1,89 @@
+package values
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockVerifyRefactoring(t *testing.T) {
+	t.Parallel()
+
+	root, _ := setupEnvTree(t)
+	lock, err := NewLock(root)
+	require.NoError(t, err)
+	require.Len(t, lock.Leaves, 8)
+	assert.Equal(t, "apps/api/dev/values.yaml", lock.Leaves[0].Path)
+	assert.Empty(t, lock.Leaves[0].Values)
+
+	// The lock survives a round trip through its file
+	b, err := lock.Marshal()
+	require.NoError(t, err)
+	parsed, err := ParseLock(b)
+	require.NoError(t, err)
+	assert.Equal(t, lock, parsed)
+
+	// Extracting the common values is behavior-neutral
+	_, err = ExtractCommonRecursive(root)
+	require.NoError(t, err)
+	diffs, err := Verify(root, parsed)
+	require.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	// Changing a shared value changes every leaf below it
+	mustWriteFile(t, filepath.Join(root, "apps", "values.yaml"), []byte("team: platform\n"))
+	diffs, err = Verify(root, parsed)
+	require.NoError(t, err)
+	require.Len(t, diffs, 8)
+	assert.Equal(t, LeafDiff{Path: "apps/api/dev/values.yaml", Kind: DiffChanged}, diffs[0])
+}
+
+func TestLockVerifyValues(t *testing.T) {
+	t.Parallel()
+
+	root, dirs := setupTempDirs(t, "a", "b")
+	mustWriteFile(t, filepath.Join(root, "values.yaml"), []byte("image:\n  repository: nginx\n  tag: \"1.0\"\nports: [80]\n"))
+	setupValuesFiles(t, dirs, [][]byte{
+		[]byte("# overrides\nimage:\n  tag: \"1.1\"\n"),
+		[]byte("replicas: 2\n"),
+	})
+	lock, err := NewLock(root, WithLockValues(true))
+	require.NoError(t, err)
+	require.Len(t, lock.Leaves, 2)
+	assert.Equal(t, "image:\n  repository: nginx\n  tag: \"1.1\"\nports:\n- 80\n", lock.Leaves[0].Values)
+
+	// Comments, formatting and placement do not matter
+	mustWriteFile(t, filepath.Join(root, "a", "values.yaml"), []byte("image: {tag: \"1.1\"}\n"))
+	diffs, err := Verify(root, lock)
+	require.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	mustWriteFile(t, filepath.Join(root, "values.yaml"), []byte("image:\n  repository: nginx\n  tag: \"1.0\"\nports: [80, 443]\n"))
+	mustWriteFile(t, filepath.Join(root, "b", "values.yaml"), []byte("debug: true\n"))
+	mustMkdirAll(t, filepath.Join(root, "c"))
+	mustWriteFile(t, filepath.Join(root, "c", "values.yaml"), []byte("{}\n"))
+	diffs, err = Verify(root, lock)
+	require.NoError(t, err)
+	require.Len(t, diffs, 3)
+
+	assert.Equal(t, "a/values.yaml", diffs[0].Path)
+	assert.Equal(t, []ValueDiff{
+		{Path: "ports", Kind: DiffChanged, Before: []any{float64(80)}, After: []any{float64(80), float64(443)}},
+	}, diffs[0].Values)
+	assert.Equal(t, "b/values.yaml", diffs[1].Path)
+	assert.Equal(t, "b/values.yaml: debug added: true\n"+
+		"b/values.yaml: ports changed: [80] -> [80,443]\n"+
+		"b/values.yaml: replicas removed: 2", diffs[1].String())
+	assert.Equal(t, LeafDiff{Path: "c/values.yaml", Kind: DiffAdded}, diffs[2])
+}
+
+func TestParseLockInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseLock([]byte("version: 2\nleaves: []\n"))
+	assert.ErrorContains(t, err, "unsupported lock file version 2")
+	_, err = ParseLock([]byte("version: 1\nleafs: []\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,250 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Path is a path to a value: a string with the syntax of Lookup, or a
+// ValuesPath.
+type Path interface {
+	~string | ValuesPath
+}
+
+// lookupConfig is a configuration for the Lookup() and LookupOr() functions.
+type lookupConfig struct {
+	strict bool
+}
+
+// LookupOption is an option for Lookup() and LookupOr().
+type LookupOption func(*lookupConfig)
+
+// WithStrictTypes refuses the conversions that change the type of a value, such
+// as the string "3" to the int 3 or the number 3 to the string "3", and the
+// lossy ones, such as the number 3.7 to the int 3.
+func WithStrictTypes(c *lookupConfig) {
+	c.strict = true
+}
+
+// Lookup returns the value at the given path converted to T, which can be a
+// string, a bool, any integer or floating point type, time.Duration (from
+// strings such as "1m30s"), []string, map[string]string, Values, or any other
+// type the value already has. Without WithStrictTypes, numbers and booleans
+// are converted to strings and back, and fractions of numbers are truncated
+// when converted to integers. Numbers that do not fit in T are always refused.
+// Conversion errors wrap ErrInvalidType and name the full path of the value.
+// Examples:
+// - Lookup[int](v, "replicaCount")
+// - Lookup[time.Duration](v, "probes.timeout", WithStrictTypes)
+// - Lookup[map[string]string](v, "podAnnotations")
+func Lookup[T any, P Path](v Values, path P, opts ...LookupOption) (T, error) {
+	var zero T
+	p, err := toValuesPath(path)
+	if err != nil {
+		return zero, err
+	}
+	value, err := v.LookupPath(p)
+	if err != nil {
+		return zero, err
+	}
+	return convertTo[T](value, p, newLookupConfig(opts...))
+}
+
+// LookupOr is the same as Lookup() but returns def when there is no value, or
+// a null value, at the path. Malformed paths and conversion errors are still
+// returned, with def.
+func LookupOr[T any, P Path](v Values, path P, def T, opts ...LookupOption) (T, error) {
+	p, err := toValuesPath(path)
+	if err != nil {
+		return def, err
+	}
+	value, err := v.LookupPath(p)
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrIndexOutOfBounds) || (err == nil && value == nil) {
+		return def, nil
+	}
+	if err != nil {
+		return def, err
+	}
+	out, err := convertTo[T](value, p, newLookupConfig(opts...))
+	if err != nil {
+		return def, err
+	}
+	return out, nil
+}
+
+func newLookupConfig(opts ...LookupOption) *lookupConfig {
+	c := &lookupConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// toValuesPath parses the paths given as strings.
+func toValuesPath[P Path](path P) (ValuesPath, error) {
+	if p, ok := any(path).(ValuesPath); ok {
+		return p, nil
+	}
+	return ParsePath(reflect.ValueOf(path).String())
+}
+
+// durationType is the type of time.Duration, which is an integer type
+// converted from strings.
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// convertTo converts the value at path p to T.
+func convertTo[T any](value any, p ValuesPath, c *lookupConfig) (T, error) {
+	var out T
+	if v, ok := value.(T); ok {
+		return v, nil
+	}
+	if err := c.convert(value, reflect.ValueOf(&out).Elem(), p); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+// convert stores the value at path p in out, converting it to the type of out.
+func (c *lookupConfig) convert(value any, out reflect.Value, p ValuesPath) error {
+	value = scalarValue(value)
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: cannot convert %T to %s at %s%s", ErrInvalidType, value, out.Type(), p, reason)
+	}
+	if value != nil && reflect.TypeOf(value).AssignableTo(out.Type()) {
+		out.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	switch t := out.Type(); {
+	case t == durationType:
+		s, ok := value.(string)
+		if !ok {
+			return invalid("")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return invalid(": " + err.Error())
+		}
+		out.SetInt(int64(d))
+		return nil
+	case t == reflect.TypeOf(Values{}):
+		m, ok := asMap(value)
+		if !ok {
+			return invalid("")
+		}
+		out.Set(reflect.ValueOf(Values(m)))
+		return nil
+	case t.Kind() == reflect.Slice:
+		l, ok := asList(value)
+		if !ok {
+			return invalid("")
+		}
+		s := reflect.MakeSlice(t, len(l), len(l))
+		for i, e := range l {
+			if err := c.convert(e, s.Index(i), p.Index(i)); err != nil {
+				return err
+			}
+		}
+		out.Set(s)
+		return nil
+	case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+		m, ok := asMap(value)
+		if !ok {
+			return invalid("")
+		}
+		res := reflect.MakeMapWithSize(t, len(m))
+		for _, k := range sortedKeys(m) {
+			e := reflect.New(t.Elem()).Elem()
+			if err := c.convert(m[k], e, p.Key(k)); err != nil {
+				return err
+			}
+			res.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), e)
+		}
+		out.Set(res)
+		return nil
+	}
+
+	switch out.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok && !c.strict {
+			if v, err := toString(value); err == nil {
+				s, ok = v, true
+			}
+		}
+		if !ok {
+			return invalid("")
+		}
+		out.SetString(s)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if s, isString := value.(string); isString && !c.strict {
+			parsed, err := strconv.ParseBool(s)
+			b, ok = parsed, err == nil
+		}
+		if !ok {
+			return invalid("")
+		}
+		out.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f, ok := c.toNumber(value)
+		if !ok {
+			return invalid("")
+		}
+		if !f.IsInt() && c.strict {
+			return invalid(": it has a fraction")
+		}
+		i, _ := f.Int(nil)
+		switch {
+		case out.CanInt() && i.IsInt64() && !out.OverflowInt(i.Int64()):
+			out.SetInt(i.Int64())
+		case out.CanUint() && i.IsUint64() && !out.OverflowUint(i.Uint64()):
+			out.SetUint(i.Uint64())
+		default:
+			return invalid(": out of range")
+		}
+	case reflect.Float32, reflect.Float64:
+		f, ok := c.toNumber(value)
+		if !ok {
+			return invalid("")
+		}
+		f64, _ := f.Float64()
+		if out.OverflowFloat(f64) {
+			return invalid(": out of range")
+		}
+		out.SetFloat(f64)
+	default:
+		return invalid("")
+	}
+	return nil
+}
+
+// toNumber returns the exact value of a number, or of a string holding one
+// when not strict.
+func (c *lookupConfig) toNumber(value any) (*big.Float, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Float).SetInt64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Float).SetUint64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		if math.IsNaN(rv.Float()) || math.IsInf(rv.Float(), 0) {
+			return nil, false
+		}
+		return new(big.Float).SetFloat64(rv.Float()), true
+	case reflect.String:
+		if c.strict {
+			return nil, false
+		}
+		f, _, err := big.ParseFloat(rv.String(), 10, 128, big.ToNearestEven)
+		return f, err == nil && !f.IsInf()
+	}
+	return nil, false
+}
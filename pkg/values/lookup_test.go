@@ -0,0 +1,149 @@
+package values
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLookupValues returns the Values the typed lookup tests read.
+func newLookupValues(t *testing.T) Values {
+	t.Helper()
+	v, err := NewValuesFromYAML([]byte(`
+replicaCount: 3
+ratio: 3.7
+port: "8080"
+debug: true
+enabled: "false"
+timeout: 1m30s
+tag: "1.0"
+big: 300
+negative: -1
+unset: null
+args: ["--debug", "--verbose"]
+ports: [80, 443]
+podAnnotations:
+  prometheus.io/scrape: "true"
+  prometheus.io/port: 9090
+image:
+  repository: app
+`))
+	require.NoError(t, err)
+	return *v
+}
+
+func TestLookupGeneric(t *testing.T) {
+	t.Parallel()
+	v := newLookupValues(t)
+
+	n, err := Lookup[int](v, "replicaCount")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	i64, err := Lookup[int64](v, "port")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8080), i64)
+	u, err := Lookup[uint](v, "replicaCount", WithStrictTypes)
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), u)
+	f, err := Lookup[float64](v, "ratio", WithStrictTypes)
+	require.NoError(t, err)
+	assert.Equal(t, 3.7, f)
+	b, err := Lookup[bool](v, "debug", WithStrictTypes)
+	require.NoError(t, err)
+	assert.True(t, b)
+	b, err = Lookup[bool](v, "enabled")
+	require.NoError(t, err)
+	assert.False(t, b)
+	d, err := Lookup[time.Duration](v, "timeout", WithStrictTypes)
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+	s, err := Lookup[string](v, "replicaCount")
+	require.NoError(t, err)
+	assert.Equal(t, "3", s)
+	args, err := Lookup[[]string](v, "args", WithStrictTypes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--debug", "--verbose"}, args)
+	ports, err := Lookup[[]int](v, "ports")
+	require.NoError(t, err)
+	assert.Equal(t, []int{80, 443}, ports)
+	annotations, err := Lookup[map[string]string](v, "podAnnotations")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "9090"}, annotations)
+	image, err := Lookup[Values](v, "image")
+	require.NoError(t, err)
+	assert.Equal(t, Values{"repository": "app"}, image)
+	last, err := Lookup[string](v, NewPath("args").Index(-1))
+	require.NoError(t, err)
+	assert.Equal(t, "--verbose", last)
+	scrape, err := Lookup[bool](v, `podAnnotations.prometheus\.io/scrape`)
+	require.NoError(t, err)
+	assert.True(t, scrape)
+
+	// Without strict types, fractions are truncated
+	n, err = Lookup[int](v, "ratio")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	_, err = Lookup[int](v, "missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = Lookup[int](v, "args[")
+	assert.ErrorIs(t, err, ErrMalformedIndex)
+}
+
+func TestLookupGenericInvalid(t *testing.T) {
+	t.Parallel()
+	v := newLookupValues(t)
+
+	tests := []struct {
+		name   string
+		lookup func() error
+		want   string
+	}{
+		{"fraction", func() error { _, err := Lookup[int](v, "ratio", WithStrictTypes); return err }, "at ratio: it has a fraction"},
+		{"string to int", func() error { _, err := Lookup[int](v, "port", WithStrictTypes); return err }, "cannot convert string to int at port"},
+		{"number to string", func() error { _, err := Lookup[string](v, "replicaCount", WithStrictTypes); return err }, "cannot convert float64 to string at replicaCount"},
+		{"string to bool", func() error { _, err := Lookup[bool](v, "enabled", WithStrictTypes); return err }, "at enabled"},
+		{"not a number", func() error { _, err := Lookup[float64](v, "args[0]"); return err }, "cannot convert string to float64 at args[0]"},
+		{"overflow", func() error { _, err := Lookup[int8](v, "big"); return err }, "at big: out of range"},
+		{"negative unsigned", func() error { _, err := Lookup[uint](v, "negative"); return err }, "at negative: out of range"},
+		{"duration", func() error { _, err := Lookup[time.Duration](v, "replicaCount"); return err }, "at replicaCount"},
+		{"list element", func() error { _, err := Lookup[[]int](v, "args"); return err }, "at args[0]"},
+		{"map value", func() error {
+			_, err := Lookup[map[string]string](v, "podAnnotations", WithStrictTypes)
+			return err
+		}, `at podAnnotations.prometheus\.io/port`},
+		{"values", func() error { _, err := Lookup[Values](v, "args"); return err }, "at args"},
+		{"null", func() error { _, err := Lookup[int](v, "unset"); return err }, "cannot convert <nil> to int at unset"},
+	}
+	for _, tt := range tests {
+		err := tt.lookup()
+		assert.ErrorIs(t, err, ErrInvalidType, tt.name)
+		assert.ErrorContains(t, err, tt.want, tt.name)
+	}
+}
+
+func TestLookupOr(t *testing.T) {
+	t.Parallel()
+	v := newLookupValues(t)
+
+	n, err := LookupOr(v, "replicaCount", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = LookupOr(v, "autoscaling.minReplicas", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = LookupOr(v, "ports[5]", 8080)
+	require.NoError(t, err)
+	assert.Equal(t, 8080, n)
+	d, err := LookupOr(v, "unset", 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, d)
+
+	n, err = LookupOr(v, "ratio", 1, WithStrictTypes)
+	assert.ErrorIs(t, err, ErrInvalidType)
+	assert.Equal(t, 1, n)
+	_, err = LookupOr(v, "a..b", 1)
+	assert.ErrorIs(t, err, ErrMalformedPath)
+}
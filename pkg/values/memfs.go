@@ -0,0 +1,226 @@
+package values
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+// MemFS is an in-memory FileSystem, safe for concurrent use. Absolute and
+// relative paths are the same: "/a/b" and "a/b" are the same file. It is also
+// an fs.FS, so its files can be read with the functions of io/fs and with
+// NewValuesFromFileInFS.
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+	dirs  map[string]bool
+}
+
+// memFile is a file of a MemFS.
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memFile), dirs: map[string]bool{".": true}}
+}
+
+// Open implements fs.FS.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if f, ok := m.files[name]; ok {
+		return &memOpenFile{info: f.info(name), r: bytes.NewReader(f.data)}, nil
+	}
+	if m.dirs[name] {
+		return &memOpenDir{info: dirInfo(name), entries: m.readDir(name)}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	key := fsName(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if f, ok := m.files[key]; ok {
+		return f.info(key), nil
+	}
+	if m.dirs[key] {
+		return dirInfo(key), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	key := fsName(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if f, ok := m.files[key]; ok {
+		return bytes.Clone(f.data), nil
+	}
+	if m.dirs[key] {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) WriteFileAtomic(name string, data []byte, perm fs.FileMode) error {
+	key := fsName(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dirs[key] {
+		return &fs.PathError{Op: "write", Path: name, Err: errIsDir}
+	}
+	if err := m.mkdirAll(path.Dir(key)); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	m.files[key] = &memFile{data: bytes.Clone(data), mode: perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+// MkdirAll creates the directory at name and its parents, if missing.
+func (m *MemFS) MkdirAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.mkdirAll(fsName(name)); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// mkdirAll creates the directory at key and its parents.
+func (m *MemFS) mkdirAll(key string) error {
+	for dir := key; ; dir = path.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return errNotDir
+		}
+		if dir == "." {
+			break
+		}
+	}
+	for dir := key; !m.dirs[dir]; dir = path.Dir(dir) {
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	key := fsName(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[key]; ok {
+		delete(m.files, key)
+		return nil
+	}
+	if m.dirs[key] && key != "." {
+		if len(m.readDir(key)) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+		}
+		delete(m.dirs, key)
+		return nil
+	}
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return walkFS(m, root, fn)
+}
+
+// readDir returns the entries of the directory at key, sorted by name.
+func (m *MemFS) readDir(key string) []fs.DirEntry {
+	var entries []fs.DirEntry
+	for name, f := range m.files {
+		if path.Dir(name) == key {
+			entries = append(entries, f.info(name))
+		}
+	}
+	for name := range m.dirs {
+		if name != "." && path.Dir(name) == key {
+			entries = append(entries, dirInfo(name))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+func (f *memFile) info(name string) memInfo {
+	return memInfo{name: path.Base(name), size: int64(len(f.data)), mode: f.mode, modTime: f.modTime}
+}
+
+func dirInfo(name string) memInfo {
+	return memInfo{name: path.Base(name), mode: fs.ModeDir | 0o755}
+}
+
+// memInfo describes a file or directory of an in-memory filesystem, both as an
+// fs.FileInfo and an fs.DirEntry.
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string               { return i.name }
+func (i memInfo) Size() int64                { return i.size }
+func (i memInfo) Mode() fs.FileMode          { return i.mode }
+func (i memInfo) ModTime() time.Time         { return i.modTime }
+func (i memInfo) IsDir() bool                { return i.mode.IsDir() }
+func (i memInfo) Sys() any                   { return nil }
+func (i memInfo) Type() fs.FileMode          { return i.mode.Type() }
+func (i memInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// memOpenFile is an open file of an in-memory filesystem.
+type memOpenFile struct {
+	info fs.FileInfo
+	r    *bytes.Reader
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Read(b []byte) (int, error) { return f.r.Read(b) }
+func (f *memOpenFile) Close() error               { return nil }
+
+// memOpenDir is an open directory of an in-memory filesystem.
+type memOpenDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memOpenDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memOpenDir) Close() error               { return nil }
+func (d *memOpenDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errIsDir}
+}
+
+func (d *memOpenDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,281 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+)
+
+// deleteConfig is a configuration for the Delete() function.
+type deleteConfig struct {
+	pruneEmpty bool
+}
+
+// DeleteOption is an option for Delete() and Move().
+type DeleteOption func(*deleteConfig)
+
+// WithPruneEmpty removes the maps and lists left empty by the deletion, up to
+// the top of the Values.
+func WithPruneEmpty(c *deleteConfig) {
+	c.pruneEmpty = true
+}
+
+// Delete removes the value at the given key path, with the syntax of Lookup.
+// Elements removed from lists shift the elements after them. It fails with
+// the errors of Lookup when there is no value at the path.
+// Examples:
+// - "foo.bar" removes the "bar" key from the "foo" map
+// - "foo[0]" removes the first element of the "foo" array
+// - "foo[-1]" removes the last element of the "foo" array
+func (v Values) Delete(key string, opts ...DeleteOption) error {
+	p, err := ParsePath(key)
+	if err != nil {
+		return err
+	}
+	return v.DeletePath(p, opts...)
+}
+
+// DeletePath is the same as Delete() but takes a parsed path.
+func (v Values) DeletePath(p ValuesPath, opts ...DeleteOption) error {
+	cfg := &deleteConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if len(p) == 0 {
+		return fmt.Errorf("%w: empty key", ErrInvalidIndexUsage)
+	}
+	if _, err := v.LookupPath(p); err != nil {
+		return err
+	}
+	v.removeAt(p)
+
+	if cfg.pruneEmpty {
+		for n := len(p) - 1; n > 0; n-- {
+			parent, err := v.LookupPath(p[:n])
+			if err != nil || !isEmptyContainer(parent) {
+				break
+			}
+			v.removeAt(p[:n])
+		}
+	}
+	return nil
+}
+
+// removeAt removes the value at a path known to exist.
+func (v Values) removeAt(p ValuesPath) {
+	parentPath, last := p[:len(p)-1], p[len(p)-1]
+	parent, _ := v.LookupPath(parentPath)
+	if m, ok := asMap(parent); ok && !last.IsIndex {
+		delete(m, last.Key)
+		return
+	}
+	l, _ := asList(parent)
+	index, _ := last.listIndex(len(l))
+	shrunk := make([]interface{}, 0, len(l)-1)
+	shrunk = append(append(shrunk, l[:index]...), l[index+1:]...)
+	// The parent of a list is never the top of the Values
+	_ = v.SetPath(parentPath, shrunk)
+}
+
+// isEmptyContainer reports whether the value is a map or a list without
+// elements.
+func isEmptyContainer(value any) bool {
+	if m, ok := asMap(value); ok {
+		return len(m) == 0
+	}
+	if l, ok := asList(value); ok {
+		return len(l) == 0
+	}
+	return false
+}
+
+// Insert inserts the value in the list at the given key path, with the syntax
+// of Lookup, which must end with an index. The value is placed at that index,
+// shifting the elements from it. Missing maps and lists on the way, including
+// list elements past the end of their list, are created as Set does.
+// Examples:
+// - "foo[0]" prepends the value to the "foo" array
+// - "foo[-1]" inserts the value before the last element of the "foo" array
+// - "foo[+]" appends the value to the "foo" array
+func (v Values) Insert(key string, value interface{}) error {
+	p, err := ParsePath(key)
+	if err != nil {
+		return err
+	}
+	return v.InsertPath(p, value)
+}
+
+// InsertPath is the same as Insert() but takes a parsed path.
+func (v Values) InsertPath(p ValuesPath, value interface{}) error {
+	if err := checkDestination(p); err != nil {
+		return err
+	}
+	parentPath, last := p[:len(p)-1], p[len(p)-1]
+	if !last.IsIndex {
+		return fmt.Errorf("%w: %s does not end with an index", ErrInvalidIndexUsage, p)
+	}
+
+	var l []interface{}
+	parent, err := v.LookupPath(parentPath)
+	switch {
+	case errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrIndexOutOfBounds):
+		// Created by SetPath, which still refuses negative indices out of bounds
+	case err != nil:
+		return err
+	case parent != nil:
+		var ok bool
+		if l, ok = asList(parent); !ok {
+			return fmt.Errorf("%w: cannot insert into %T at %s", ErrInvalidType, parent, parentPath)
+		}
+	}
+
+	index, _ := last.listIndex(len(l))
+	if index < 0 || index > len(l) {
+		return fmt.Errorf("%w: %s", ErrIndexOutOfBounds, p)
+	}
+	grown := make([]interface{}, 0, len(l)+1)
+	grown = append(append(append(grown, l[:index]...), value), l[index:]...)
+	return v.SetPath(parentPath, grown)
+}
+
+// Move moves the value at the from key path to the to key path, with the
+// syntax of Lookup, creating intermediate maps/slices as Set does. The
+// destination is resolved once the value is removed, so moving an element of
+// a list to a later index of the same list counts without it. A value cannot
+// be moved inside itself, and a move that fails leaves the values unchanged.
+// The options apply to the removal.
+func (v Values) Move(from, to string, opts ...DeleteOption) error {
+	fromPath, err := ParsePath(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := ParsePath(to)
+	if err != nil {
+		return err
+	}
+	return v.MovePath(fromPath, toPath, opts...)
+}
+
+// MovePath is the same as Move() but takes parsed paths.
+func (v Values) MovePath(from, to ValuesPath, opts ...DeleteOption) error {
+	value, err := v.LookupPath(from)
+	if err != nil {
+		return err
+	}
+	if err := checkDestination(to); err != nil {
+		return err
+	}
+	if v.hasPrefix(to, from) {
+		if len(to) == len(from) {
+			return nil
+		}
+		return fmt.Errorf("%w: cannot move %s inside itself", ErrInvalidIndexUsage, from)
+	}
+
+	// Work on a copy, so that a destination that cannot be set leaves the
+	// values unchanged
+	moved := normalizeValues(v)
+	if err := moved.DeletePath(from, opts...); err != nil {
+		return err
+	}
+	if err := moved.SetPath(to, value); err != nil {
+		return err
+	}
+	for k := range v {
+		delete(v, k)
+	}
+	for k, val := range moved {
+		v[k] = val
+	}
+	return nil
+}
+
+// Copy copies the value at the from key path to the to key path, with the
+// syntax of Lookup, creating intermediate maps/slices as Set does. The copy
+// is deep, so later changes to either value do not affect the other.
+func (v Values) Copy(from, to string) error {
+	fromPath, err := ParsePath(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := ParsePath(to)
+	if err != nil {
+		return err
+	}
+	return v.CopyPath(fromPath, toPath)
+}
+
+// CopyPath is the same as Copy() but takes parsed paths.
+func (v Values) CopyPath(from, to ValuesPath) error {
+	value, err := v.LookupPath(from)
+	if err != nil {
+		return err
+	}
+	if err := checkDestination(to); err != nil {
+		return err
+	}
+	return v.SetPath(to, normalizeValue(value))
+}
+
+// Extract returns a deep copy of the map at the given key path, with the syntax
+// of Lookup, as its own Values. It is the inverse of Rebase: for any Values v
+// and base b, v.Rebase(b).Extract(b) is equal to v. It fails with
+// ErrInvalidType when the value is not a map.
+func (v Values) Extract(key string) (*Values, error) {
+	p, err := ParsePath(key)
+	if err != nil {
+		return nil, err
+	}
+	return v.ExtractPath(p)
+}
+
+// ExtractPath is the same as Extract() but takes a parsed path.
+func (v Values) ExtractPath(p ValuesPath) (*Values, error) {
+	value, err := v.LookupPath(p)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := asMap(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: cannot extract %T at %s", ErrInvalidType, value, p)
+	}
+	extracted := normalizeValues(m)
+	return &extracted, nil
+}
+
+// checkDestination checks that a value can be set at the path.
+func checkDestination(p ValuesPath) error {
+	if len(p) == 0 {
+		return fmt.Errorf("%w: empty key", ErrInvalidIndexUsage)
+	}
+	if p[0].IsIndex {
+		return fmt.Errorf("%w: %s starts with an index", ErrInvalidIndexUsage, p)
+	}
+	return nil
+}
+
+// hasPrefix reports whether the path p starts with the path prefix, a path
+// with a value in v. The elements are compared by what they refer to in v, so
+// "[-1]" and "[0]" are the same element of a list of one element, and so are
+// "0" and "[0]".
+func (v Values) hasPrefix(p, prefix ValuesPath) bool {
+	if len(prefix) > len(p) {
+		return false
+	}
+	for i, e := range prefix {
+		parent, err := v.LookupPath(prefix[:i])
+		if err != nil {
+			return false
+		}
+		if l, ok := asList(parent); ok {
+			want, wok := e.listIndex(len(l))
+			got, gok := p[i].listIndex(len(l))
+			if !wok || !gok || want != got {
+				return false
+			}
+		} else if p[i].IsIndex || p[i].Key != e.Key {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package values
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMutateValues returns the Values the mutation tests start from.
+func newMutateValues(t *testing.T) *Values {
+	t.Helper()
+	v, err := NewValuesFromYAML([]byte(`
+image:
+  repository: app
+  tag: "1.0"
+podAnnotations:
+  prometheus.io/scrape: "true"
+containers:
+- name: app
+  args: ["--debug"]
+- name: proxy
+`))
+	require.NoError(t, err)
+	return v
+}
+
+func TestValues_Delete(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		key     string
+		opts    []DeleteOption
+		want    string
+		wantErr error
+	}{
+		{
+			name: "map key",
+			key:  "image.tag",
+			want: "image: {repository: app}\npodAnnotations: {prometheus.io/scrape: 'true'}\ncontainers: [{name: app, args: [--debug]}, {name: proxy}]\n",
+		},
+		{
+			name: "escaped key",
+			key:  `podAnnotations.prometheus\.io/scrape`,
+			want: "image: {repository: app, tag: '1.0'}\npodAnnotations: {}\ncontainers: [{name: app, args: [--debug]}, {name: proxy}]\n",
+		},
+		{
+			name: "escaped key pruned",
+			key:  `podAnnotations["prometheus.io/scrape"]`,
+			opts: []DeleteOption{WithPruneEmpty},
+			want: "image: {repository: app, tag: '1.0'}\ncontainers: [{name: app, args: [--debug]}, {name: proxy}]\n",
+		},
+		{
+			name: "list element",
+			key:  "containers[0]",
+			want: "image: {repository: app, tag: '1.0'}\npodAnnotations: {prometheus.io/scrape: 'true'}\ncontainers: [{name: proxy}]\n",
+		},
+		{
+			name: "pruned up several levels",
+			key:  "containers[0].args[0]",
+			opts: []DeleteOption{WithPruneEmpty},
+			want: "image: {repository: app, tag: '1.0'}\npodAnnotations: {prometheus.io/scrape: 'true'}\ncontainers: [{name: app}, {name: proxy}]\n",
+		},
+		{
+			name:    "missing key",
+			key:     "image.digest",
+			wantErr: ErrKeyNotFound,
+		},
+		{
+			name:    "index out of bounds",
+			key:     "containers[2]",
+			wantErr: ErrIndexOutOfBounds,
+		},
+		{
+			name:    "index into a map",
+			key:     "image[0]",
+			wantErr: ErrInvalidType,
+		},
+		{
+			name:    "empty key",
+			key:     "",
+			wantErr: ErrInvalidIndexUsage,
+		},
+		{
+			name:    "malformed key",
+			key:     "image[",
+			wantErr: ErrMalformedIndex,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			v := newMutateValues(t)
+			err := v.Delete(tt.key, tt.opts...)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assertYAMLEqual(t, []byte(tt.want), v.MustToYAML())
+		})
+	}
+
+	t.Run("prune everything", func(t *testing.T) {
+		t.Parallel()
+		v := Values{"a": Values{"b": []interface{}{Values{"c": 1}}}}
+		require.NoError(t, v.Delete("a.b[0].c", WithPruneEmpty))
+		assert.Equal(t, Values{}, v)
+	})
+}
+
+func TestValues_Move(t *testing.T) {
+	t.Parallel()
+
+	v := newMutateValues(t)
+	require.NoError(t, v.Move("image.tag", "app.image.tag"))
+	require.NoError(t, v.Move("image", "app.image.registry", WithPruneEmpty))
+	require.NoError(t, v.Move("containers[1]", "sidecars[0]"))
+	require.NoError(t, v.Move("containers[0].name", "containers[0].name"))
+	assertYAMLEqual(t, []byte(`
+app:
+  image: {tag: "1.0", registry: {repository: app}}
+podAnnotations: {prometheus.io/scrape: "true"}
+containers: [{name: app, args: [--debug]}]
+sidecars: [{name: proxy}]
+`), v.MustToYAML())
+
+	assert.ErrorIs(t, v.Move("app", "app.image.old"), ErrInvalidIndexUsage)
+	assert.ErrorIs(t, v.Move("missing", "other"), ErrKeyNotFound)
+	assert.ErrorIs(t, v.Move("app", ""), ErrInvalidIndexUsage)
+	assert.ErrorIs(t, v.Move("app", "[0]"), ErrInvalidIndexUsage)
+	_, err := v.Lookup("app.image.tag")
+	assert.NoError(t, err, "failed moves leave the values unchanged")
+
+	t.Run("destination that cannot be set", func(t *testing.T) {
+		t.Parallel()
+		v := Values{"a": 1, "b": Values{"c": []interface{}{1}}}
+		assert.ErrorIs(t, v.Move("a", "d[-1]"), ErrIndexOutOfBounds)
+		assert.ErrorIs(t, v.Move("b.c[0]", "b.c[-2]"), ErrIndexOutOfBounds)
+		assert.ErrorIs(t, v.Move("b.c", "b.c.d"), ErrInvalidIndexUsage)
+		assert.Equal(t, Values{"a": 1, "b": Values{"c": []interface{}{1}}}, v)
+	})
+
+	t.Run("same element written differently", func(t *testing.T) {
+		t.Parallel()
+		v := Values{"a": []interface{}{Values{"b": 1}}}
+		require.NoError(t, v.Move("a[0]", "a[-1]"))
+		require.NoError(t, v.Move("a[0]", "a.0"))
+		assert.Equal(t, Values{"a": []interface{}{Values{"b": 1}}}, v)
+		assert.ErrorIs(t, v.Move("a[0]", "a[-1].b.c"), ErrInvalidIndexUsage)
+		assert.ErrorIs(t, v.Move("a.0", "a[0].c"), ErrInvalidIndexUsage)
+	})
+}
+
+func TestValues_Copy(t *testing.T) {
+	t.Parallel()
+
+	v := newMutateValues(t)
+	require.NoError(t, v.Copy("containers[0]", "initContainers[0]"))
+	require.NoError(t, v.Set("initContainers[0].args[0]", "--init"))
+	require.NoError(t, v.Set("initContainers[0].name", "init"))
+
+	args, err := v.Lookup("containers[0].args[0]")
+	require.NoError(t, err)
+	assert.Equal(t, "--debug", args, "the copy is deep")
+	name, err := v.LookupString("initContainers[0].name")
+	require.NoError(t, err)
+	assert.Equal(t, "init", name)
+
+	require.NoError(t, v.Copy("image.tag", `podAnnotations.app\.version`))
+	tag, err := v.LookupString(`podAnnotations["app.version"]`)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", tag)
+
+	assert.ErrorIs(t, v.Copy("image.digest", "other"), ErrKeyNotFound)
+}
+
+func TestValues_Extract(t *testing.T) {
+	t.Parallel()
+
+	v := newMutateValues(t)
+	image, err := v.Extract("image")
+	require.NoError(t, err)
+	assert.Equal(t, Values{"repository": "app", "tag": "1.0"}, *image)
+	(*image)["tag"] = "2.0"
+	tag, err := v.LookupString("image.tag")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", tag, "the extracted values are a copy")
+
+	// Extract is the inverse of Rebase
+	for _, base := range []string{"new", "new.base", `a\.b.c`} {
+		extracted, err := v.Rebase(base).Extract(base)
+		require.NoError(t, err, base)
+		assertYAMLEqual(t, v.MustToYAML(), extracted.MustToYAML())
+	}
+
+	_, err = v.Extract("image.tag")
+	assert.ErrorIs(t, err, ErrInvalidType)
+	_, err = v.Extract("containers")
+	assert.ErrorIs(t, err, ErrInvalidType)
+	_, err = v.Extract("missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestValues_ListIndices(t *testing.T) {
+	t.Parallel()
+
+	v, err := NewValuesFromYAML([]byte("auth:\n  allowedClientIDs: [a, b, c]\n"))
+	require.NoError(t, err)
+	ids := func() []interface{} {
+		got, err := v.Lookup("auth.allowedClientIDs")
+		require.NoError(t, err)
+		return got.([]interface{})
+	}
+
+	last, err := v.LookupString("auth.allowedClientIDs[-1]")
+	require.NoError(t, err)
+	assert.Equal(t, "c", last)
+	_, err = v.Lookup("auth.allowedClientIDs[-4]")
+	assert.ErrorIs(t, err, ErrIndexOutOfBounds)
+	_, err = v.Lookup("auth.allowedClientIDs[+]")
+	assert.ErrorIs(t, err, ErrIndexOutOfBounds)
+
+	require.NoError(t, v.Set("auth.allowedClientIDs[-1]", "C"))
+	require.NoError(t, v.Set("auth.allowedClientIDs[+]", "d"))
+	assert.Equal(t, []interface{}{"a", "b", "C", "d"}, ids())
+	assert.ErrorIs(t, v.Set("auth.allowedClientIDs[-5]", "x"), ErrIndexOutOfBounds)
+
+	require.NoError(t, v.Insert("auth.allowedClientIDs[0]", "first"))
+	require.NoError(t, v.Insert("auth.allowedClientIDs[-1]", "beforeLast"))
+	require.NoError(t, v.Insert("auth.allowedClientIDs[+]", "last"))
+	assert.Equal(t, []interface{}{"first", "a", "b", "C", "beforeLast", "d", "last"}, ids())
+	assert.ErrorIs(t, v.Insert("auth.allowedClientIDs[8]", "x"), ErrIndexOutOfBounds)
+	assert.ErrorIs(t, v.Insert("auth.allowedClientIDs", "x"), ErrInvalidIndexUsage)
+	assert.ErrorIs(t, v.Insert("auth[0]", "x"), ErrInvalidType)
+
+	require.NoError(t, v.Delete("auth.allowedClientIDs[-1]"))
+	require.NoError(t, v.Delete("auth.allowedClientIDs[0]"))
+	assert.Equal(t, []interface{}{"a", "b", "C", "beforeLast", "d"}, ids())
+
+	// Lists are created as needed
+	require.NoError(t, v.Set("auth.scopes[+]", "read"))
+	require.NoError(t, v.Insert("auth.audiences[+]", "api"))
+	require.NoError(t, v.Set("auth.roles[+].name", "admin"))
+	require.NoError(t, v.Insert("auth.roles[2].tags[+]", "new"))
+	require.NoError(t, v.Insert("auth.roles[+].tags[0]", "last"))
+	assert.ErrorIs(t, v.Insert("auth.roles[-5].tags[0]", "x"), ErrIndexOutOfBounds)
+	assertYAMLEqual(t, []byte(`
+auth:
+  allowedClientIDs: [a, b, C, beforeLast, d]
+  scopes: [read]
+  audiences: [api]
+  roles: [{name: admin}, null, {tags: [new]}, {tags: [last]}]
+`), v.MustToYAML())
+}
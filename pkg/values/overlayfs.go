@@ -0,0 +1,157 @@
+package values
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+)
+
+// OverlayFS is a FileSystem on top of a read-only fs.FS, such as an embed.FS
+// or an os.DirFS, that keeps every change in memory with copy-on-write: the
+// files written hide the files of the base, and the files removed are hidden,
+// while the base is never modified. Paths are resolved as in MemFS. It is also
+// an fs.FS with the merged view.
+type OverlayFS struct {
+	base  fs.FS
+	upper *MemFS
+
+	mu      sync.RWMutex
+	removed map[string]bool
+}
+
+// NewOverlayFS returns an OverlayFS on top of base.
+func NewOverlayFS(base fs.FS) *OverlayFS {
+	return &OverlayFS{base: base, upper: NewMemFS(), removed: make(map[string]bool)}
+}
+
+// Open implements fs.FS.
+func (o *OverlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	upperInfo, upperErr := o.upper.Stat(name)
+	if upperErr == nil && !upperInfo.IsDir() {
+		return o.upper.Open(name)
+	}
+	if o.isRemoved(name) {
+		// A file of the base removed, maybe replaced by a directory
+		if upperErr == nil {
+			return o.upper.Open(name)
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	baseInfo, baseErr := fs.Stat(o.base, name)
+	if baseErr != nil && !errors.Is(baseErr, fs.ErrNotExist) {
+		return nil, baseErr
+	}
+	switch {
+	case baseErr == nil && !baseInfo.IsDir():
+		return o.base.Open(name)
+	case baseErr == nil:
+		return o.openDir(name, baseInfo)
+	case upperErr == nil:
+		return o.openDir(name, upperInfo)
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// openDir opens a directory with the entries of both layers.
+func (o *OverlayFS) openDir(name string, info fs.FileInfo) (fs.File, error) {
+	entries := make(map[string]fs.DirEntry)
+	if baseEntries, err := fs.ReadDir(o.base, name); err == nil {
+		for _, e := range baseEntries {
+			if !o.isRemoved(path.Join(name, e.Name())) {
+				entries[e.Name()] = e
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if upperEntries, err := fs.ReadDir(o.upper, name); err == nil {
+		for _, e := range upperEntries {
+			if prev, ok := entries[e.Name()]; !ok || !prev.IsDir() || !e.IsDir() {
+				entries[e.Name()] = e
+			}
+		}
+	}
+	dir := &memOpenDir{info: info, entries: make([]fs.DirEntry, 0, len(entries))}
+	for _, e := range entries {
+		dir.entries = append(dir.entries, e)
+	}
+	sort.Slice(dir.entries, func(i, j int) bool { return dir.entries[i].Name() < dir.entries[j].Name() })
+	return dir, nil
+}
+
+// isRemoved reports whether the file of the base at key was removed.
+func (o *OverlayFS) isRemoved(key string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.removed[key]
+}
+
+func (o *OverlayFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := o.Open(fsName(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func (o *OverlayFS) ReadFile(name string) ([]byte, error) {
+	f, err := o.Open(fsName(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (o *OverlayFS) WriteFileAtomic(name string, data []byte, perm fs.FileMode) error {
+	key := fsName(name)
+	for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+		if fi, err := o.Stat(dir); err == nil && !fi.IsDir() {
+			return &fs.PathError{Op: "write", Path: name, Err: errNotDir}
+		}
+	}
+	if fi, err := o.Stat(key); err == nil && fi.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: errIsDir}
+	}
+	if err := o.upper.WriteFileAtomic(key, data, perm); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.removed, key)
+	return nil
+}
+
+// Remove removes a file. Directories cannot be removed.
+func (o *OverlayFS) Remove(name string) error {
+	key := fsName(name)
+	fi, err := o.Stat(key)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if fi.IsDir() {
+		return &fs.PathError{Op: "remove", Path: name, Err: errIsDir}
+	}
+	if upperInfo, err := o.upper.Stat(key); err == nil && !upperInfo.IsDir() {
+		if err := o.upper.Remove(key); err != nil {
+			return err
+		}
+	}
+	if baseInfo, err := fs.Stat(o.base, key); err == nil && !baseInfo.IsDir() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.removed[key] = true
+	}
+	return nil
+}
+
+func (o *OverlayFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return walkFS(o, root, fn)
+}
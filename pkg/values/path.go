@@ -0,0 +1,337 @@
+package values
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ValuesPath is a parsed path to a value in the Values map: the keys of the
+// maps and the indices of the lists leading to it. An empty path is the whole
+// Values.
+//
+// Paths are parsed from, and formatted to, three syntaxes:
+//
+//   - dotted paths (ParsePath, String), as in "httpProxy.annotations.trans.id"
+//     or "containers[0].image", where a backslash escapes the next character
+//     and keys can be quoted in brackets, so the key "translation.adobe.io/ams-env"
+//     is `translation\.adobe\.io/ams-env` or `["translation.adobe.io/ams-env"]`
+//   - JSON Pointers (ParseJSONPointer, JSONPointer), as in "/containers/0/image"
+//   - the paths of Helm's --set flag (ParseHelmPath, HelmPath), as in
+//     `podAnnotations.prometheus\.io/scrape`
+type ValuesPath []PathElement
+
+// PathElement is a key or an index of a ValuesPath.
+type PathElement struct {
+	// Key is the key of a map. As in JSON Pointer, a key that is an array
+	// index, such as "0", also indexes a list when the value is a list, and
+	// the key "-" is the position after its last element.
+	Key string
+	// Index is the index of a list, when IsIndex is set. Negative indices
+	// count from the end of the list, so -1 is its last element.
+	Index   int
+	IsIndex bool
+	// Append is set, with IsIndex, for the position after the last element
+	// of a list, written "[+]", where Set and Insert append values.
+	Append bool
+}
+
+// KeyElement returns the PathElement of a key.
+func KeyElement(key string) PathElement {
+	return PathElement{Key: key}
+}
+
+// IndexElement returns the PathElement of an index.
+func IndexElement(index int) PathElement {
+	return PathElement{Index: index, IsIndex: true}
+}
+
+// AppendElement returns the PathElement of the position after the last
+// element of a list.
+func AppendElement() PathElement {
+	return PathElement{IsIndex: true, Append: true}
+}
+
+// NewPath returns the path of the given keys.
+func NewPath(keys ...string) ValuesPath {
+	p := make(ValuesPath, len(keys))
+	for i, k := range keys {
+		p[i] = KeyElement(k)
+	}
+	return p
+}
+
+// Key returns a new path with the key appended.
+func (p ValuesPath) Key(key string) ValuesPath {
+	return append(p[:len(p):len(p)], KeyElement(key))
+}
+
+// Index returns a new path with the index appended.
+func (p ValuesPath) Index(index int) ValuesPath {
+	return append(p[:len(p):len(p)], IndexElement(index))
+}
+
+// ParsePath parses a dotted path, where keys are separated by dots, indices
+// are written in brackets ("[0]", "[-1]" for the last element, or "[+]" for
+// the position after it), a backslash escapes the next character, and keys
+// can be quoted in brackets (`["a.b"]` or `['a.b']`), which is the only way to
+// write an empty key. The empty string is the empty path.
+func ParsePath(s string) (ValuesPath, error) {
+	var p ValuesPath
+	for i := 0; i < len(s); {
+		// The key of the segment, if any
+		var key strings.Builder
+		escaped := false
+		for ; i < len(s) && s[i] != '.' && s[i] != '['; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				escaped = true
+			} else if s[i] == ']' {
+				return nil, fmt.Errorf("%w: unexpected ] in %q", ErrMalformedIndex, s)
+			}
+			key.WriteByte(s[i])
+		}
+		if key.Len() > 0 || escaped {
+			p = append(p, KeyElement(key.String()))
+		} else if i >= len(s) || s[i] != '[' {
+			return nil, fmt.Errorf("%w: empty key in %q", ErrMalformedPath, s)
+		}
+
+		// The brackets following the key
+		for i < len(s) && s[i] == '[' {
+			end, e, err := parseBracketElement(s, i)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, e)
+			i = end + 1
+		}
+
+		if i < len(s) {
+			if s[i] != '.' {
+				return nil, fmt.Errorf("%w: unexpected %q after ] in %q", ErrMalformedIndex, s[i], s)
+			}
+			if i++; i >= len(s) {
+				return nil, fmt.Errorf("%w: trailing . in %q", ErrMalformedPath, s)
+			}
+		}
+	}
+	return p, nil
+}
+
+// MustParsePath is like ParsePath but panics if the path cannot be parsed.
+func MustParsePath(s string) ValuesPath {
+	p, err := ParsePath(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// parseBracketElement parses the index or the quoted key in the brackets
+// starting at s[start], returning the position of the closing bracket.
+func parseBracketElement(s string, start int) (int, PathElement, error) {
+	if start+1 < len(s) && (s[start+1] == '"' || s[start+1] == '\'') {
+		quote := s[start+1]
+		var key strings.Builder
+		for i := start + 2; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && i+1 < len(s):
+				i++
+				key.WriteByte(s[i])
+			case s[i] == quote:
+				if i+1 >= len(s) || s[i+1] != ']' {
+					return 0, PathElement{}, fmt.Errorf("%w: expected ] after the quoted key in %q", ErrMalformedIndex, s)
+				}
+				return i + 1, KeyElement(key.String()), nil
+			default:
+				key.WriteByte(s[i])
+			}
+		}
+		return 0, PathElement{}, fmt.Errorf("%w: unterminated quoted key in %q", ErrMalformedIndex, s)
+	}
+
+	end := strings.IndexByte(s[start:], ']')
+	if end < 0 {
+		return 0, PathElement{}, fmt.Errorf("%w: unterminated [ in %q", ErrMalformedIndex, s)
+	}
+	end += start
+	if s[start+1:end] == "+" {
+		return end, AppendElement(), nil
+	}
+	index, err := strconv.Atoi(s[start+1 : end])
+	if err != nil {
+		return 0, PathElement{}, fmt.Errorf("%w: invalid index [%s] in %q", ErrMalformedIndex, s[start+1:end], s)
+	}
+	return end, IndexElement(index), nil
+}
+
+// String returns the path in the dotted syntax of ParsePath.
+func (p ValuesPath) String() string {
+	var b strings.Builder
+	for i, e := range p {
+		switch {
+		case e.Append:
+			b.WriteString("[+]")
+		case e.IsIndex:
+			fmt.Fprintf(&b, "[%d]", e.Index)
+		case e.Key == "":
+			b.WriteString(`[""]`)
+		default:
+			if i > 0 {
+				b.WriteString(SplitToken)
+			}
+			writeEscaped(&b, e.Key, `\.[]`)
+		}
+	}
+	return b.String()
+}
+
+// ParseJSONPointer parses a JSON Pointer (RFC 6901), such as
+// "/containers/0/image". Every reference token is parsed as a key, and the
+// keys that are array indices, or "-", index lists when the values are lists.
+func ParseJSONPointer(s string) (ValuesPath, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("%w: JSON Pointer %q does not start with /", ErrMalformedPath, s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	p := make(ValuesPath, len(tokens))
+	for i, tok := range tokens {
+		for j := 0; j < len(tok); j++ {
+			if tok[j] == '~' && (j+1 >= len(tok) || (tok[j+1] != '0' && tok[j+1] != '1')) {
+				return nil, fmt.Errorf("%w: invalid escape in JSON Pointer %q", ErrMalformedPath, s)
+			}
+		}
+		p[i] = KeyElement(strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~"))
+	}
+	return p, nil
+}
+
+// JSONPointer returns the path as a JSON Pointer (RFC 6901), where the position
+// after the last element of a list is "-". JSON Pointers cannot express
+// negative indices.
+func (p ValuesPath) JSONPointer() (string, error) {
+	var b strings.Builder
+	for _, e := range p {
+		b.WriteByte('/')
+		switch {
+		case e.Append:
+			b.WriteByte('-')
+		case e.IsIndex && e.Index < 0:
+			return "", fmt.Errorf("%w: %s has a negative index", ErrInvalidIndexUsage, p)
+		case e.IsIndex:
+			b.WriteString(strconv.Itoa(e.Index))
+		default:
+			b.WriteString(strings.ReplaceAll(strings.ReplaceAll(e.Key, "~", "~0"), "/", "~1"))
+		}
+	}
+	return b.String(), nil
+}
+
+// ParseHelmPath parses a path in the syntax of the keys of Helm's --set flag,
+// such as `ingress.annotations.kubernetes\.io/ingress\.class` or
+// "servers[0].port", where a backslash escapes the next character.
+func ParseHelmPath(s string) (ValuesPath, error) {
+	var p ValuesPath
+	for i := 0; i < len(s); {
+		var key strings.Builder
+		for ; i < len(s) && s[i] != '.' && s[i] != '['; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			} else if s[i] == ',' || s[i] == '=' {
+				return nil, fmt.Errorf("%w: unexpected %q in %q", ErrMalformedPath, s[i], s)
+			}
+			key.WriteByte(s[i])
+		}
+		if key.Len() == 0 {
+			return nil, fmt.Errorf("%w: empty key in %q", ErrMalformedPath, s)
+		}
+		p = append(p, KeyElement(key.String()))
+
+		for i < len(s) && s[i] == '[' {
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated [ in %q", ErrMalformedIndex, s)
+			}
+			end += i
+			index, err := strconv.Atoi(s[i+1 : end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%w: invalid index [%s] in %q", ErrMalformedIndex, s[i+1:end], s)
+			}
+			p = append(p, IndexElement(index))
+			i = end + 1
+		}
+
+		if i < len(s) {
+			if s[i] != '.' {
+				return nil, fmt.Errorf("%w: unexpected %q after ] in %q", ErrMalformedIndex, s[i], s)
+			}
+			if i++; i >= len(s) {
+				return nil, fmt.Errorf("%w: trailing . in %q", ErrMalformedPath, s)
+			}
+		}
+	}
+	return p, nil
+}
+
+// HelmPath returns the path in the syntax of the keys of Helm's --set flag.
+// Helm cannot address empty keys, lists at the top of the path, negative
+// indices nor the position after the last element of a list.
+func (p ValuesPath) HelmPath() (string, error) {
+	var b strings.Builder
+	for i, e := range p {
+		switch {
+		case e.IsIndex && i == 0:
+			return "", fmt.Errorf("%w: %s starts with an index", ErrInvalidIndexUsage, p)
+		case e.Append || (e.IsIndex && e.Index < 0):
+			return "", fmt.Errorf("%w: %s has an index Helm does not support", ErrInvalidIndexUsage, p)
+		case e.IsIndex:
+			fmt.Fprintf(&b, "[%d]", e.Index)
+		case e.Key == "":
+			return "", fmt.Errorf("%w: %s has an empty key", ErrMalformedPath, p)
+		default:
+			if i > 0 {
+				b.WriteString(SplitToken)
+			}
+			writeEscaped(&b, e.Key, `\.[],=`)
+		}
+	}
+	return b.String(), nil
+}
+
+// writeEscaped writes s, escaping the special characters with a backslash.
+func writeEscaped(b *strings.Builder, s string, special string) {
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+}
+
+// listIndex returns the index a path element refers to in a list of n
+// elements, and whether it refers to one at all: its index, counted from the
+// end when negative, n when appending, or its key when it is an array index
+// or "-", as in JSON Pointer. The index can be out of the bounds of the list.
+func (e PathElement) listIndex(n int) (int, bool) {
+	switch {
+	case e.Append || (!e.IsIndex && e.Key == "-"):
+		return n, true
+	case e.IsIndex && e.Index < 0:
+		return n + e.Index, true
+	case e.IsIndex:
+		return e.Index, true
+	case e.Key == "" || (e.Key[0] == '0' && len(e.Key) > 1):
+		return 0, false
+	}
+	for _, c := range e.Key {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	i, err := strconv.Atoi(e.Key)
+	return i, err == nil
+}
@@ -0,0 +1,196 @@
+package values
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input   string
+		want    ValuesPath
+		wantErr error
+	}{
+		{"", nil, nil},
+		{"key", NewPath("key"), nil},
+		{"key[0]", NewPath("key").Index(0), nil},
+		{"key[10]", NewPath("key").Index(10), nil},
+		{"a.b[1][2].c", NewPath("a", "b").Index(1).Index(2).Key("c"), nil},
+		{`annotations.translation\.adobe\.io/ams-env`, NewPath("annotations", "translation.adobe.io/ams-env"), nil},
+		{`annotations["translation.adobe.io/ams-env"]`, NewPath("annotations", "translation.adobe.io/ams-env"), nil},
+		{`a['b]"c'].d`, NewPath("a", `b]"c`, "d"), nil},
+		{`a[""].b`, NewPath("a", "", "b"), nil},
+		{`a\[0\]`, NewPath("a[0]"), nil},
+		{`a\\.b`, NewPath(`a\`, "b"), nil},
+		{"key[0", nil, ErrMalformedIndex},
+		{"key0]", nil, ErrMalformedIndex},
+		{"key[abc]", nil, ErrMalformedIndex},
+		{"key[-1]", NewPath("key").Index(-1), nil},
+		{"key[+].a", append(NewPath("key"), AppendElement()).Key("a"), nil},
+		{"key[++]", nil, ErrMalformedIndex},
+		{"key[0]x", nil, ErrMalformedIndex},
+		{`a["b"x]`, nil, ErrMalformedIndex},
+		{`a["b`, nil, ErrMalformedIndex},
+		{"a..b", nil, ErrMalformedPath},
+		{".a", nil, ErrMalformedPath},
+		{"a.", nil, ErrMalformedPath},
+	}
+	for _, tt := range tests {
+		got, err := ParsePath(tt.input)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.input)
+			continue
+		}
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.want, got, tt.input)
+
+		// Formatting and parsing again gives the same path
+		again, err := ParsePath(got.String())
+		require.NoError(t, err, got.String())
+		assert.Equal(t, got, again, got.String())
+	}
+
+	assert.Equal(t, `annotations.translation\.adobe\.io/ams-env`, NewPath("annotations", "translation.adobe.io/ams-env").String())
+	assert.Equal(t, `a[""].b[0]`, NewPath("a", "", "b").Index(0).String())
+}
+
+func TestJSONPointer(t *testing.T) {
+	t.Parallel()
+
+	p, err := ParseJSONPointer("/annotations/translation.adobe.io~1ams-env/a~0b~01")
+	require.NoError(t, err)
+	assert.Equal(t, NewPath("annotations", "translation.adobe.io/ams-env", "a~b~1"), p)
+	s, err := p.JSONPointer()
+	require.NoError(t, err)
+	assert.Equal(t, "/annotations/translation.adobe.io~1ams-env/a~0b~01", s)
+
+	p, err = ParseJSONPointer("")
+	require.NoError(t, err)
+	assert.Empty(t, p)
+	p, err = ParseJSONPointer("/")
+	require.NoError(t, err)
+	assert.Equal(t, NewPath(""), p)
+	s, err = MustParsePath("containers[0].image").JSONPointer()
+	require.NoError(t, err)
+	assert.Equal(t, "/containers/0/image", s)
+	s, err = MustParsePath("containers[+]").JSONPointer()
+	require.NoError(t, err)
+	assert.Equal(t, "/containers/-", s)
+	_, err = MustParsePath("containers[-1]").JSONPointer()
+	assert.ErrorIs(t, err, ErrInvalidIndexUsage)
+
+	for _, s := range []string{"a/b", "/a~2", "/a~"} {
+		_, err := ParseJSONPointer(s)
+		assert.ErrorIs(t, err, ErrMalformedPath, s)
+	}
+
+	// Keys that are array indices index lists
+	v := Values{"containers": []interface{}{Values{"image": "app"}}, "ports": Values{"0": 80}}
+	p, err = ParseJSONPointer("/containers/0/image")
+	require.NoError(t, err)
+	got, err := v.LookupPath(p)
+	require.NoError(t, err)
+	assert.Equal(t, "app", got)
+	got, err = v.LookupPath(NewPath("ports", "0"))
+	require.NoError(t, err)
+	assert.Equal(t, 80, got)
+	_, err = v.LookupPath(NewPath("containers", "01"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, v.SetPath(p, "proxy"))
+	assert.Equal(t, "proxy", v["containers"].([]interface{})[0].(Values)["image"])
+}
+
+func TestHelmPath(t *testing.T) {
+	t.Parallel()
+
+	p, err := ParseHelmPath(`podAnnotations.prometheus\.io/scrape`)
+	require.NoError(t, err)
+	assert.Equal(t, NewPath("podAnnotations", "prometheus.io/scrape"), p)
+
+	p, err = ParseHelmPath(`servers[0].args[1].a\,b\=c`)
+	require.NoError(t, err)
+	assert.Equal(t, NewPath("servers").Index(0).Key("args").Index(1).Key("a,b=c"), p)
+	s, err := p.HelmPath()
+	require.NoError(t, err)
+	assert.Equal(t, `servers[0].args[1].a\,b\=c`, s)
+
+	for _, s := range []string{"a,b", "a=b", "a..b", "[0]", "a[x]", "a.", "a[-1]", "a[+]"} {
+		_, err := ParseHelmPath(s)
+		assert.Error(t, err, s)
+	}
+	_, err = NewPath("a", "").HelmPath()
+	assert.ErrorIs(t, err, ErrMalformedPath)
+	_, err = ValuesPath{IndexElement(0)}.HelmPath()
+	assert.ErrorIs(t, err, ErrInvalidIndexUsage)
+	_, err = MustParsePath("a[-1]").HelmPath()
+	assert.ErrorIs(t, err, ErrInvalidIndexUsage)
+	_, err = MustParsePath("a[+]").HelmPath()
+	assert.ErrorIs(t, err, ErrInvalidIndexUsage)
+}
+
+func TestValues_EscapedKeys(t *testing.T) {
+	t.Parallel()
+
+	v := Values{}
+	require.NoError(t, v.Set(`podAnnotations.translation\.adobe\.io/ams-env`, "prod"))
+	require.NoError(t, v.Set(`podAnnotations["a[0]"]`, "x"))
+	assert.Equal(t, Values{"podAnnotations": Values{"translation.adobe.io/ams-env": "prod", "a[0]": "x"}}, v)
+
+	got, err := v.LookupString(`podAnnotations["translation.adobe.io/ams-env"]`)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", got)
+	got, err = v.LookupString(`podAnnotations.a\[0\]`)
+	require.NoError(t, err)
+	assert.Equal(t, "x", got)
+
+	_, err = v.Lookup("podAnnotations.translation.adobe.io/ams-env")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// Every path found by a query can be used again
+	matches, err := v.Query("podAnnotations.*")
+	require.NoError(t, err)
+	for _, m := range matches {
+		got, err := v.Lookup(m.Path.String())
+		require.NoError(t, err)
+		assert.Equal(t, m.Value, got)
+	}
+
+	rebased := v.Rebase(`a\.b.c`)
+	assert.Equal(t, Values{"a.b": Values{"c": v}}, *rebased)
+}
+
+func TestValues_TypedPathLookups(t *testing.T) {
+	t.Parallel()
+
+	v := Values{"podAnnotations": Values{"prometheus.io/port": 9090, "prometheus.io/path": "/metrics"}}
+	annotations := NewPath("podAnnotations")
+
+	port, err := v.LookupIntPath(annotations.Key("prometheus.io/port"))
+	require.NoError(t, err)
+	assert.Equal(t, 9090, port)
+	path, err := v.LookupStringPath(annotations.Key("prometheus.io/path"))
+	require.NoError(t, err)
+	assert.Equal(t, "/metrics", path)
+	values, err := v.LookupValuesPath(annotations)
+	require.NoError(t, err)
+	assert.Len(t, values, 2)
+	_, err = v.LookupIntPath(annotations.Key("prometheus.io/path"))
+	assert.ErrorIs(t, err, ErrInvalidType)
+
+	candidates := []ValuesPath{annotations.Key("prometheus"), annotations.Key("prometheus.io/port")}
+	port, at, err := v.LookupFirstIntPath(candidates)
+	require.NoError(t, err)
+	assert.Equal(t, 9090, port)
+	assert.Equal(t, candidates[1], at)
+	str, at, err := v.LookupFirstStringPath([]ValuesPath{annotations.Key("prometheus.io/path")})
+	require.NoError(t, err)
+	assert.Equal(t, "/metrics", str)
+	assert.Equal(t, annotations.Key("prometheus.io/path"), at)
+	_, _, err = v.LookupFirstPath(candidates[:1])
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
@@ -0,0 +1,290 @@
+package values
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	syaml "sigs.k8s.io/yaml"
+)
+
+// ErrStalePlan is returned by Apply when a file changed since the plan was
+// computed.
+var ErrStalePlan = errors.New("plan is out of date")
+
+// ChangeKind is the kind of change a plan makes to a file.
+type ChangeKind string
+
+const (
+	// ChangeCreate creates a file that does not exist.
+	ChangeCreate ChangeKind = "create"
+	// ChangeRewrite replaces the content of an existing file.
+	ChangeRewrite ChangeKind = "rewrite"
+	// ChangeEmpty leaves an existing file without any values.
+	ChangeEmpty ChangeKind = "empty"
+	// ChangeRemove removes an existing file.
+	ChangeRemove ChangeKind = "remove"
+)
+
+// Plan is the list of changes a file-level function would make. Pass a Plan
+// with WithDryRun to compute it without touching any file, review it, and
+// perform it later with Apply.
+type Plan struct {
+	// Changes are the files to change, sorted by path. Files written with
+	// their current content are not listed.
+	Changes []FileChange `json:"changes"`
+
+	// mu guards Changes while a parallel extraction records them.
+	mu sync.Mutex
+}
+
+// FileChange describes the change of a single file.
+type FileChange struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	// Before is the current content, nil if the file does not exist.
+	Before []byte `json:"before,omitempty"`
+	// After is the new content, nil if the file is removed.
+	After []byte `json:"after,omitempty"`
+	// Diff is a unified diff from Before to After.
+	Diff string `json:"diff"`
+}
+
+// WithDryRun records in p the changes that would be made instead of writing
+// them. Reads done by the function see the changes recorded so far, so the
+// plan of a recursive extraction matches what running it would do. The same
+// plan can collect several runs.
+func WithDryRun(p *Plan) Option {
+	return func(o *Options) { o.plan = p }
+}
+
+// Empty reports whether the plan has no changes.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Diff returns the unified diffs of all the changes.
+func (p *Plan) Diff() string {
+	var b strings.Builder
+	for _, c := range p.Changes {
+		b.WriteString(c.Diff)
+	}
+	return b.String()
+}
+
+// Apply performs the changes of a plan as a single transaction, using the
+// filesystem set with WithFileSystem. Before writing anything, it checks that
+// every file still has the content it had when the plan was computed, and
+// returns ErrStalePlan otherwise.
+func Apply(plan *Plan, opts ...Option) error {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if plan.Empty() {
+		return nil
+	}
+
+	paths := make([]string, len(plan.Changes))
+	for i, c := range plan.Changes {
+		paths[i] = c.Path
+	}
+	scope := commonDir(paths)
+	if _, err := recoverJournal(options.fs, scope); err != nil {
+		return err
+	}
+	for _, c := range plan.Changes {
+		current, err := readIfExists(options.fs, c.Path)
+		if err != nil {
+			return err
+		}
+		if (current == nil) != (c.Before == nil) || !bytes.Equal(current, c.Before) {
+			return fmt.Errorf("%w: %s", ErrStalePlan, c.Path)
+		}
+	}
+	return commitPlan(options.fs, scope, plan, options.progress)
+}
+
+// record adds a change to the plan, merging it with a previous change of the
+// same file.
+func (p *Plan) record(base FileSystem, path string, after []byte, removed bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := sort.Search(len(p.Changes), func(i int) bool { return p.Changes[i].Path >= path })
+	exists := i < len(p.Changes) && p.Changes[i].Path == path
+	if !exists {
+		before, err := readIfExists(base, path)
+		if err != nil {
+			return err
+		}
+		p.Changes = append(p.Changes, FileChange{})
+		copy(p.Changes[i+1:], p.Changes[i:])
+		p.Changes[i] = FileChange{Path: path, Before: before}
+	}
+
+	c := &p.Changes[i]
+	switch {
+	case removed && c.Before == nil, !removed && c.Before != nil && bytes.Equal(c.Before, after):
+		// Back to the original state
+		p.Changes = append(p.Changes[:i], p.Changes[i+1:]...)
+		return nil
+	case removed:
+		c.Kind, c.After = ChangeRemove, nil
+	case c.Before == nil:
+		c.Kind, c.After = ChangeCreate, bytes.Clone(after)
+	case isEmptyDocument(after):
+		c.Kind, c.After = ChangeEmpty, bytes.Clone(after)
+	default:
+		c.Kind, c.After = ChangeRewrite, bytes.Clone(after)
+	}
+	c.Diff = unifiedDiff(path, c.Before, c.After)
+	return nil
+}
+
+// change returns the change recorded for path, if any.
+func (p *Plan) change(path string) (FileChange, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := sort.Search(len(p.Changes), func(i int) bool { return p.Changes[i].Path >= path })
+	if i < len(p.Changes) && p.Changes[i].Path == path {
+		return p.Changes[i], true
+	}
+	return FileChange{}, false
+}
+
+// planOps implements FileSystem on top of another one, recording the writes in
+// a plan instead of performing them.
+type planOps struct {
+	base FileSystem
+	plan *Plan
+	// format, when set, makes the files written keep the format of the
+	// files they replace. See WithPreserveFileFormat.
+	format bool
+}
+
+// dryRun returns the options with their filesystem operations wrapped in a
+// planOps when a plan is set.
+func (o Options) dryRun() Options {
+	if o.plan != nil {
+		if po, ok := o.fs.(planOps); !ok || po.plan != o.plan {
+			o.fs = planOps{base: o.fs, plan: o.plan, format: o.PreserveFileFormat}
+		}
+	}
+	return o
+}
+
+func (p planOps) Stat(name string) (fs.FileInfo, error) {
+	name = filepath.Clean(name)
+	if c, ok := p.plan.change(name); ok {
+		if c.Kind == ChangeRemove {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+		}
+		return plannedFileInfo{name: filepath.Base(name), size: int64(len(c.After))}, nil
+	}
+	return p.base.Stat(name)
+}
+
+func (p planOps) ReadFile(name string) ([]byte, error) {
+	name = filepath.Clean(name)
+	if c, ok := p.plan.change(name); ok {
+		if c.Kind == ChangeRemove {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return bytes.Clone(c.After), nil
+	}
+	return p.base.ReadFile(name)
+}
+
+func (p planOps) WriteFileAtomic(path string, data []byte, _ fs.FileMode) error {
+	path = filepath.Clean(path)
+	if p.format {
+		before, err := readIfExists(p, path)
+		if err != nil {
+			return err
+		}
+		data = detectFileFormat(before).apply(data)
+	}
+	return p.plan.record(p.base, path, data, false)
+}
+
+func (p planOps) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return p.base.WalkDir(root, fn)
+}
+
+func (p planOps) Remove(name string) error {
+	return p.plan.record(p.base, filepath.Clean(name), nil, true)
+}
+
+// plannedFileInfo describes a file that only exists in a plan.
+type plannedFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi plannedFileInfo) Name() string       { return fi.name }
+func (fi plannedFileInfo) Size() int64        { return fi.size }
+func (fi plannedFileInfo) Mode() fs.FileMode  { return 0o644 }
+func (fi plannedFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi plannedFileInfo) IsDir() bool        { return false }
+func (fi plannedFileInfo) Sys() any           { return nil }
+
+// readIfExists returns the content of path, or nil if it does not exist.
+func readIfExists(ops FileSystem, path string) ([]byte, error) {
+	if _, err := ops.Stat(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	b, err := ops.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
+
+// isEmptyDocument reports whether b holds no values.
+func isEmptyDocument(b []byte) bool {
+	var v any
+	if err := syaml.Unmarshal(b, &v); err != nil {
+		return false
+	}
+	return isEmpty(v)
+}
+
+// unifiedDiff returns a unified diff between two versions of a file.
+func unifiedDiff(path string, before, after []byte) string {
+	from, to := "a/"+filepath.ToSlash(path), "b/"+filepath.ToSlash(path)
+	if before == nil {
+		from = "/dev/null"
+	}
+	if after == nil {
+		to = "/dev/null"
+	}
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(before),
+		B:        splitLines(after),
+		FromFile: from,
+		ToFile:   to,
+		Context:  3,
+	})
+	return diff
+}
+
+// splitLines splits b into lines for difflib, with no lines for empty content.
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	return difflib.SplitLines(string(b))
+}
@@ -0,0 +1,101 @@
+package values
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunAndApply(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("image:\n  repository: nginx\n  tag: \"1.2\"\nreplicas: 3\n"),
+		[]byte("image:\n  repository: nginx\n  tag: \"1.3\"\nreplicas: 3\n"),
+		[]byte("image:\n  repository: nginx\n  tag: \"1.2\"\nreplicas: 5\n"),
+	}
+	dirs := []string{"envs/prod/a", "envs/prod/b", "envs/dev"}
+
+	root, fullDirs := setupTempDirs(t, dirs...)
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	var plan Plan
+	created, err := ExtractCommonRecursive(root, WithDryRun(&plan))
+	require.NoError(t, err)
+	require.NotEmpty(t, created)
+
+	// Nothing was written
+	for i, p := range paths {
+		assert.Equal(t, string(inputs[i]), string(mustReadFile(t, p)))
+	}
+	for _, c := range created {
+		_, err := os.Stat(c)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+	}
+
+	kinds := make(map[string]ChangeKind)
+	for _, c := range plan.Changes {
+		kinds[c.Path] = c.Kind
+		assert.NotEmpty(t, c.Diff)
+	}
+	assert.Equal(t, ChangeCreate, kinds[filepath.Join(root, "envs", "values.yaml")])
+	assert.Equal(t, ChangeRewrite, kinds[paths[0]])
+	assert.Contains(t, plan.Diff(), "--- /dev/null\n+++ b/"+filepath.ToSlash(filepath.Join(root, "envs", "values.yaml")))
+
+	// Running for real produces the planned content
+	other, otherDirs := setupTempDirs(t, dirs...)
+	otherPaths := setupValuesFiles(t, otherDirs, inputs)
+	_, err = ExtractCommonRecursive(other)
+	require.NoError(t, err)
+	for i, p := range paths {
+		for _, c := range plan.Changes {
+			if c.Path == p {
+				assert.Equal(t, string(mustReadFile(t, otherPaths[i])), string(c.After))
+			}
+		}
+	}
+
+	require.NoError(t, Apply(&plan))
+	for _, c := range plan.Changes {
+		assert.Equal(t, string(c.After), string(mustReadFile(t, c.Path)))
+	}
+
+	// Once applied, there is nothing left to do
+	var again Plan
+	_, err = ExtractCommonRecursive(root, WithDryRun(&again))
+	require.NoError(t, err)
+	assert.True(t, again.Empty(), again.Diff())
+
+	// A plan is not applied over files changed since it was computed
+	assert.ErrorIs(t, Apply(&plan), ErrStalePlan)
+}
+
+func TestDryRunEmptyAndRemove(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{[]byte("a: 1\nb: 2\n"), []byte("a: 1\nb: 2\n")}
+	root, fullDirs := setupTempDirs(t, "x", "y")
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	var plan Plan
+	_, err := ExtractCommonN(paths, WithDryRun(&plan))
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 3)
+	for _, c := range plan.Changes {
+		if c.Path != filepath.Join(root, "values.yaml") {
+			assert.Equal(t, ChangeEmpty, c.Kind)
+		}
+	}
+
+	// Inlining on top of the plan removes the parent it would have created
+	written, err := InlineRecursive(root, WithDryRun(&plan))
+	require.NoError(t, err)
+	assert.Len(t, written, 2)
+	assert.True(t, plan.Empty(), plan.Diff())
+	assert.False(t, strings.Contains(string(mustReadFile(t, paths[0])), "{}"))
+}
@@ -0,0 +1,129 @@
+package values
+
+import (
+	"context"
+	"sync"
+)
+
+// ProgressKind is the kind of a ProgressEvent.
+type ProgressKind int
+
+const (
+	// DirectoryStarted is sent when ExtractCommonRecursive starts looking for
+	// common values to extract into a directory.
+	DirectoryStarted ProgressKind = iota
+	// FileWritten is sent for every file written or removed when the changes
+	// of an operation are committed.
+	FileWritten
+	// PassCompleted is sent when ExtractCommonRecursive completes a pass over
+	// the tree.
+	PassCompleted
+)
+
+// String returns the name of the kind.
+func (k ProgressKind) String() string {
+	switch k {
+	case DirectoryStarted:
+		return "directory-started"
+	case FileWritten:
+		return "file-written"
+	case PassCompleted:
+		return "pass-completed"
+	default:
+		return "unknown"
+	}
+}
+
+// ProgressEvent reports the progress of an operation. See WithProgress.
+type ProgressEvent struct {
+	Kind ProgressKind
+	// Path is the directory started or the file written. Empty for
+	// PassCompleted.
+	Path string
+	// Pass is the number of the pass, starting at 1. Zero for FileWritten, as
+	// files are only written once all the passes are done.
+	Pass int
+}
+
+// WithProgress calls fn with the progress of the operation. Calls are never
+// concurrent, even when the work is spread over several workers (see
+// WithConcurrency), but fn should return quickly as the workers wait for it.
+func WithProgress(fn func(ProgressEvent)) Option {
+	var mu sync.Mutex
+	return func(o *Options) {
+		if fn == nil {
+			o.progress = nil
+			return
+		}
+		o.progress = func(e ProgressEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(e)
+		}
+	}
+}
+
+// WithConcurrency sets how many directories ExtractCommonRecursive processes
+// at the same time. Values below 2 process them one at a time. Default 1.
+func WithConcurrency(n int) Option {
+	return func(o *Options) { o.Concurrency = n }
+}
+
+// notify sends a progress event, if anyone listens.
+func notify(progress func(ProgressEvent), e ProgressEvent) {
+	if progress != nil {
+		progress(e)
+	}
+}
+
+// forEach calls fn for every item, with up to n calls running at the same
+// time, and returns the first error. No more calls are started once a call
+// fails or ctx is done, and forEach waits for the running ones to return.
+func forEach(ctx context.Context, n int, items []string, fn func(string) error) error {
+	if n < 1 {
+		n = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	sem := make(chan struct{}, n)
+	for _, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			fail(err)
+		}
+		if failed() {
+			break
+		}
+		wg.Add(1)
+		go func(item string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(item); err != nil {
+				fail(err)
+			}
+		}(item)
+	}
+	wg.Wait()
+	return firstErr
+}
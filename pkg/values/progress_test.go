@@ -0,0 +1,128 @@
+package values
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupEnvTree creates a tree of environments for several apps, all sharing
+// some values, and returns its root and the values files.
+func setupEnvTree(t *testing.T) (string, []string) {
+	t.Helper()
+	var dirs []string
+	var contents [][]byte
+	for _, app := range []string{"api", "web", "worker", "cron"} {
+		for _, env := range []string{"dev", "prod"} {
+			dirs = append(dirs, filepath.Join("apps", app, env))
+			contents = append(contents, []byte("team: core\napp: "+app+"\nenv: "+env+"\n"))
+		}
+	}
+	root, fullDirs := setupTempDirs(t, dirs...)
+	return root, setupValuesFiles(t, fullDirs, contents)
+}
+
+// readTree returns the content of every file under root, by relative path.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	require.NoError(t, filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		require.NoError(t, err)
+		files[rel] = string(mustReadFile(t, p))
+		return nil
+	}))
+	return files
+}
+
+func TestExtractCommonRecursiveConcurrent(t *testing.T) {
+	t.Parallel()
+
+	seqRoot, _ := setupEnvTree(t)
+	seqCreated, err := ExtractCommonRecursive(seqRoot)
+	require.NoError(t, err)
+
+	root, _ := setupEnvTree(t)
+	created, err := ExtractCommonRecursiveContext(context.Background(), root, WithConcurrency(4))
+	require.NoError(t, err)
+
+	rel := func(root string, paths []string) []string {
+		out := make([]string, len(paths))
+		for i, p := range paths {
+			out[i], _ = filepath.Rel(root, p)
+		}
+		return out
+	}
+	assert.Equal(t, rel(seqRoot, seqCreated), rel(root, created))
+	assert.Equal(t, readTree(t, seqRoot), readTree(t, root))
+	assertYAMLEqual(t, []byte("team: core\n"), mustReadFile(t, filepath.Join(root, "apps", "values.yaml")))
+}
+
+func TestExtractCommonRecursiveCancel(t *testing.T) {
+	t.Parallel()
+
+	root, _ := setupEnvTree(t)
+	before := readTree(t, root)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := 0
+	progress := func(e ProgressEvent) {
+		if e.Kind == DirectoryStarted {
+			started++
+			if started == 3 {
+				cancel()
+			}
+		}
+	}
+	_, err := ExtractCommonRecursiveContext(ctx, root, WithConcurrency(2), WithProgress(progress))
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, before, readTree(t, root))
+
+	// A cancelled context stops the run before anything is done
+	_, err = ExtractCommonRecursiveContext(ctx, root)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, before, readTree(t, root))
+}
+
+func TestExtractCommonRecursiveProgress(t *testing.T) {
+	t.Parallel()
+
+	root, paths := setupEnvTree(t)
+	var events []ProgressEvent
+	created, err := ExtractCommonRecursiveContext(context.Background(), root,
+		WithConcurrency(3), WithProgress(func(e ProgressEvent) { events = append(events, e) }))
+	require.NoError(t, err)
+
+	started := make(map[string]bool)
+	written := make(map[string]bool)
+	var passes []int
+	for _, e := range events {
+		switch e.Kind {
+		case DirectoryStarted:
+			assert.Equal(t, len(passes)+1, e.Pass, "directory started after its pass completed")
+			started[e.Path] = true
+		case FileWritten:
+			written[e.Path] = true
+		case PassCompleted:
+			passes = append(passes, e.Pass)
+		}
+	}
+
+	assert.Equal(t, []int{1, 2}, passes)
+	for _, app := range []string{"api", "web", "worker", "cron"} {
+		assert.True(t, started[filepath.Join(root, "apps", app)], "%s not started", app)
+	}
+	assert.True(t, started[filepath.Join(root, "apps")])
+	for _, p := range append(paths, created...) {
+		assert.True(t, written[p], "%s not reported as written", p)
+	}
+	assert.Equal(t, FileWritten, events[len(events)-1].Kind)
+}
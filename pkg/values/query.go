@@ -0,0 +1,480 @@
+package values
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/inercia/go-values-yaml/pkg/yaml"
+)
+
+// Match is a value found by Query, with its concrete path.
+type Match struct {
+	// Path is the path of the value, with the keys and indices of the
+	// elements matched by the wildcards and filters, such as
+	// "spec.containers[1].image.tag".
+	Path ValuesPath
+	// Value is the value at Path.
+	Value any
+}
+
+// Query returns every value matching the query, with the values matched at a
+// level before the values below it and the keys of maps sorted. Queries are
+// paths (see Lookup) where:
+//
+//   - "*" matches every key of a map, as in "ingress.*.host"
+//   - "**" matches any number of keys and list elements, including none, as in
+//     "**.image.tag"
+//   - "[*]" matches every element of a list, as in "containers[*].image"
+//   - "[?<path> <op> <literal>]" matches the elements of a list where the path,
+//     relative to the element, has a value comparing to the literal with any
+//     of ==, !=, <, <=, > and >=, as in "ports[?name=='http'].containerPort".
+//     The element itself is "@", as in "args[?@=='--debug']", and
+//     "[?<path>]" matches the elements where the path exists and is neither
+//     null nor false. The path can hold indices, quoted keys and filters of
+//     its own, as in `[?labels["app.kubernetes.io/name"]=='app']`. Literals
+//     are quoted strings, numbers, true, false and null.
+//
+// Keys are escaped or quoted as in ParsePath, so both
+// `labels.app\.kubernetes\.io/name` and `labels["app.kubernetes.io/name"]`
+// match a key holding dots, and `\*` matches a key "*". A query without
+// matches returns an empty list.
+func (v Values) Query(query string) ([]Match, error) {
+	steps, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	var matches []Match
+	seen := make(map[string]bool)
+	evalQuery(steps, map[string]interface{}(v), nil, func(path ValuesPath, value any) {
+		// "**" can reach the same value in several ways
+		if key := path.String(); !seen[key] {
+			seen[key] = true
+			matches = append(matches, Match{Path: path, Value: value})
+		}
+	})
+	return matches, nil
+}
+
+// queryStepKind is the kind of a step of a query.
+type queryStepKind int
+
+const (
+	stepKey queryStepKind = iota
+	stepAnyKey
+	stepDescendants
+	stepIndex
+	stepAnyIndex
+	stepFilter
+)
+
+// queryStep is a step of a query, each one leading from a value to the values
+// it matches.
+type queryStep struct {
+	kind   queryStepKind
+	key    string
+	index  int
+	filter *queryFilter
+}
+
+// queryFilter is the predicate of a "[?...]" step.
+type queryFilter struct {
+	path    []queryStep
+	op      string
+	literal any
+}
+
+// queryOps are the comparison operators of filters, the longest first.
+var queryOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// parseQuery parses a query into its steps.
+func parseQuery(query string) ([]queryStep, error) {
+	if query == "" {
+		return nil, nil
+	}
+	var steps []queryStep
+	for i := 0; ; {
+		// The key of the segment
+		var key strings.Builder
+		escaped := false
+		for ; i < len(query) && query[i] != '.' && query[i] != '['; i++ {
+			if query[i] == '\\' && i+1 < len(query) {
+				i++
+				escaped = true
+			} else if query[i] == ']' {
+				return nil, fmt.Errorf("%w: unexpected ] in %q", ErrMalformedQuery, query)
+			}
+			key.WriteByte(query[i])
+		}
+		switch name := key.String(); {
+		case name == "*" && !escaped:
+			steps = append(steps, queryStep{kind: stepAnyKey})
+		case name == "**" && !escaped:
+			steps = append(steps, queryStep{kind: stepDescendants})
+		case name == "" && !escaped:
+			// Only the brackets of a segment can be left without a key
+			if i >= len(query) || query[i] != '[' {
+				return nil, fmt.Errorf("%w: empty key in %q", ErrMalformedQuery, query)
+			}
+		default:
+			steps = append(steps, queryStep{kind: stepKey, key: name})
+		}
+
+		// The brackets following the key
+		for i < len(query) && query[i] == '[' {
+			if i+1 < len(query) && (query[i+1] == '"' || query[i+1] == '\'') {
+				end, e, err := parseBracketElement(query, i)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrMalformedQuery, err)
+				}
+				steps = append(steps, queryStep{kind: stepKey, key: e.Key})
+				i = end + 1
+				continue
+			}
+			end := closingBracket(query, i)
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated [ in %q", ErrMalformedQuery, query)
+			}
+			step, err := parseBracket(query[i+1 : end])
+			if err != nil {
+				return nil, fmt.Errorf("%w in %q", err, query)
+			}
+			steps = append(steps, step)
+			i = end + 1
+		}
+
+		if i >= len(query) {
+			return steps, nil
+		}
+		if query[i] != '.' {
+			return nil, fmt.Errorf("%w: unexpected %q after ] in %q", ErrMalformedQuery, query[i], query)
+		}
+		i++
+		if i >= len(query) {
+			return nil, fmt.Errorf("%w: trailing . in %q", ErrMalformedQuery, query)
+		}
+	}
+}
+
+// closingBracket returns the position of the ] closing the [ at start, skipping
+// the quoted strings and the nested brackets of filters, or -1.
+func closingBracket(s string, start int) int {
+	var quote byte
+	depth := 0
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']' && depth > 0:
+			depth--
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseBracket parses the content of a "[...]" step.
+func parseBracket(s string) (queryStep, error) {
+	switch {
+	case s == "*":
+		return queryStep{kind: stepAnyIndex}, nil
+	case strings.HasPrefix(s, "?"):
+		f, err := parseFilter(strings.TrimSpace(s[1:]))
+		if err != nil {
+			return queryStep{}, err
+		}
+		return queryStep{kind: stepFilter, filter: f}, nil
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return queryStep{}, fmt.Errorf("%w: invalid index [%s]", ErrMalformedQuery, s)
+	}
+	return queryStep{kind: stepIndex, index: index}, nil
+}
+
+// parseFilter parses the expression of a "[?...]" step.
+func parseFilter(expr string) (*queryFilter, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("%w: empty filter", ErrMalformedQuery)
+	}
+	f := &queryFilter{}
+	left := expr
+	if pos, op := findOperator(expr); pos >= 0 {
+		left = strings.TrimSpace(expr[:pos])
+		f.op = op
+		literal, err := parseLiteral(strings.TrimSpace(expr[pos+len(op):]))
+		if err != nil {
+			return nil, err
+		}
+		f.literal = literal
+	}
+
+	switch {
+	case left == "@":
+		return f, nil
+	case strings.HasPrefix(left, "@."):
+		left = left[2:]
+	case left == "":
+		return nil, fmt.Errorf("%w: missing path in filter %q", ErrMalformedQuery, expr)
+	}
+	path, err := parseQuery(left)
+	if err != nil {
+		return nil, err
+	}
+	f.path = path
+	return f, nil
+}
+
+// findOperator returns the position and the comparison operator of a filter
+// expression, outside of quoted strings and brackets, or -1.
+func findOperator(expr string) (int, string) {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth > 0:
+		default:
+			for _, op := range queryOps {
+				if strings.HasPrefix(expr[i:], op) {
+					return i, op
+				}
+			}
+		}
+	}
+	return -1, ""
+}
+
+// parseLiteral parses the literal of a filter: a quoted string, a number,
+// true, false or null.
+func parseLiteral(s string) (any, error) {
+	switch s {
+	case "":
+		return nil, fmt.Errorf("%w: missing literal in filter", ErrMalformedQuery)
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if q := s[0]; q == '\'' || q == '"' {
+		if len(s) < 2 || s[len(s)-1] != q {
+			return nil, fmt.Errorf("%w: unterminated string %s", ErrMalformedQuery, s)
+		}
+		var b strings.Builder
+		for i := 1; i < len(s)-1; i++ {
+			if s[i] == '\\' && i+1 < len(s)-1 {
+				i++
+			} else if s[i] == q {
+				return nil, fmt.Errorf("%w: unexpected %c in string %s", ErrMalformedQuery, q, s)
+			}
+			b.WriteByte(s[i])
+		}
+		return b.String(), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid literal %s", ErrMalformedQuery, s)
+	}
+	return f, nil
+}
+
+// evalQuery calls fn with every value matching the steps from value, at path.
+func evalQuery(steps []queryStep, value any, path ValuesPath, fn func(ValuesPath, any)) {
+	if len(steps) == 0 {
+		fn(path, value)
+		return
+	}
+	step, rest := steps[0], steps[1:]
+	switch step.kind {
+	case stepKey:
+		if m, ok := asMap(value); ok {
+			if child, ok := m[step.key]; ok {
+				evalQuery(rest, child, path.Key(step.key), fn)
+			}
+		} else if l, ok := asList(value); ok {
+			if i, ok := KeyElement(step.key).listIndex(len(l)); ok && i < len(l) {
+				evalQuery(rest, l[i], path.Index(i), fn)
+			}
+		}
+	case stepAnyKey:
+		if m, ok := asMap(value); ok {
+			for _, k := range sortedKeys(m) {
+				evalQuery(rest, m[k], path.Key(k), fn)
+			}
+		}
+	case stepDescendants:
+		evalQuery(rest, value, path, fn)
+		if m, ok := asMap(value); ok {
+			for _, k := range sortedKeys(m) {
+				evalQuery(steps, m[k], path.Key(k), fn)
+			}
+		} else if l, ok := asList(value); ok {
+			for i, e := range l {
+				evalQuery(steps, e, path.Index(i), fn)
+			}
+		}
+	case stepIndex:
+		if l, ok := asList(value); ok {
+			if i, _ := IndexElement(step.index).listIndex(len(l)); i >= 0 && i < len(l) {
+				evalQuery(rest, l[i], path.Index(i), fn)
+			}
+		}
+	case stepAnyIndex, stepFilter:
+		if l, ok := asList(value); ok {
+			for i, e := range l {
+				if step.kind == stepAnyIndex || step.filter.match(e) {
+					evalQuery(rest, e, path.Index(i), fn)
+				}
+			}
+		}
+	}
+}
+
+// match reports whether the filter holds for an element of a list.
+func (f *queryFilter) match(elem any) bool {
+	matched := false
+	evalQuery(f.path, elem, nil, func(_ ValuesPath, value any) {
+		if matched {
+			return
+		}
+		if f.op == "" {
+			value = scalarValue(value)
+			matched = value != nil && value != false
+			return
+		}
+		matched = compareValues(value, f.op, f.literal)
+	})
+	return matched
+}
+
+// compareValues compares a value with the literal of a filter. Numbers are
+// compared as numbers and strings lexically; values of other types are only
+// equal or different.
+func compareValues(value any, op string, literal any) bool {
+	value = scalarValue(value)
+	if a, ok := toFloat(value); ok {
+		if b, ok := literal.(float64); ok {
+			switch op {
+			case "==":
+				return a == b
+			case "!=":
+				return a != b
+			case "<":
+				return a < b
+			case "<=":
+				return a <= b
+			case ">":
+				return a > b
+			case ">=":
+				return a >= b
+			}
+		}
+	}
+	if a, ok := value.(string); ok {
+		if b, ok := literal.(string); ok {
+			switch op {
+			case "<":
+				return a < b
+			case "<=":
+				return a <= b
+			case ">":
+				return a > b
+			case ">=":
+				return a >= b
+			}
+		}
+	}
+	switch op {
+	case "==":
+		return reflect.DeepEqual(value, literal)
+	case "!=":
+		return !reflect.DeepEqual(value, literal)
+	}
+	return false
+}
+
+// scalarValue returns the Go value of the scalars kept with fidelity.
+func scalarValue(v any) any {
+	if s, ok := v.(yaml.Scalar); ok {
+		return s.Interface()
+	}
+	return v
+}
+
+// toFloat converts the numeric types to float64.
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// asMap returns the maps held in Values.
+func asMap(v any) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case Values:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	}
+	return nil, false
+}
+
+// asList returns the elements of the lists held in Values, of any slice type.
+func asList(v any) ([]interface{}, bool) {
+	if l, ok := v.([]interface{}); ok {
+		return l, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	l := make([]interface{}, rv.Len())
+	for i := range l {
+		l[i] = rv.Index(i).Interface()
+	}
+	return l, true
+}
+
+// sortedKeys returns the keys of a map, sorted.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
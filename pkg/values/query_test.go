@@ -0,0 +1,143 @@
+package values
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValues_Query(t *testing.T) {
+	t.Parallel()
+
+	v, err := NewValuesFromYAML([]byte(`
+image:
+  repository: app
+  tag: "1.0"
+sidecar:
+  image:
+    tag: "2.0"
+containers:
+- name: app
+  image:
+    tag: "1.1"
+  ports:
+  - name: http
+    containerPort: 8080
+  - name: metrics
+    containerPort: 9090
+- name: proxy
+  ports:
+  - name: http
+    containerPort: 8081
+  enabled: false
+  labels:
+    app.kubernetes.io/name: proxy
+ingress:
+  public:
+    host: example.com
+  internal:
+    host: example.local
+labels:
+  app.kubernetes.io/name: app
+args: ["--debug", "--verbose"]
+`))
+	require.NoError(t, err)
+
+	match := func(path string, value any) Match {
+		return Match{Path: MustParsePath(path), Value: value}
+	}
+	tests := []struct {
+		query string
+		want  []Match
+	}{
+		{"image.tag", []Match{match("image.tag", "1.0")}},
+		{"ingress.*.host", []Match{
+			match("ingress.internal.host", "example.local"),
+			match("ingress.public.host", "example.com"),
+		}},
+		{"**.image.tag", []Match{
+			match("image.tag", "1.0"),
+			match("containers[0].image.tag", "1.1"),
+			match("sidecar.image.tag", "2.0"),
+		}},
+		{"**.tag", []Match{
+			match("containers[0].image.tag", "1.1"),
+			match("image.tag", "1.0"),
+			match("sidecar.image.tag", "2.0"),
+		}},
+		{"containers[*].name", []Match{
+			match("containers[0].name", "app"),
+			match("containers[1].name", "proxy"),
+		}},
+		{"containers[1].ports[0].containerPort", []Match{match("containers[1].ports[0].containerPort", float64(8081))}},
+		{"containers[*].ports[?name=='http'].containerPort", []Match{
+			match("containers[0].ports[0].containerPort", float64(8080)),
+			match("containers[1].ports[0].containerPort", float64(8081)),
+		}},
+		{`containers[?name == "proxy"].ports[*].name`, []Match{match("containers[1].ports[0].name", "http")}},
+		{"containers[*].ports[?containerPort >= 9000].name", []Match{match("containers[0].ports[1].name", "metrics")}},
+		{"containers[?image.tag != '1.0'].name", []Match{match("containers[0].name", "app")}},
+		{"containers[?image].name", []Match{match("containers[0].name", "app")}},
+		{"containers[?enabled == false].name", []Match{match("containers[1].name", "proxy")}},
+		{"containers[?enabled].name", nil},
+		{"containers[?ports[0].containerPort > 8080].name", []Match{match("containers[1].name", "proxy")}},
+		{"containers[?ports[?name=='metrics']].name", []Match{match("containers[0].name", "app")}},
+		{`containers[?labels["app.kubernetes.io/name"]=='proxy'].name`, []Match{match("containers[1].name", "proxy")}},
+		{`containers[?labels['app.kubernetes.io/name']=='a]b'].name`, nil},
+		{"args[?@=='--debug']", []Match{match("args[0]", "--debug")}},
+		{"**[?containerPort > 8080].name", []Match{
+			match("containers[0].ports[1].name", "metrics"),
+			match("containers[1].ports[0].name", "http"),
+		}},
+		{`labels.app\.kubernetes\.io/name`, []Match{match(`labels.app\.kubernetes\.io/name`, "app")}},
+		{`labels["app.kubernetes.io/name"]`, []Match{match(`labels.app\.kubernetes\.io/name`, "app")}},
+		{"containers.1.name", []Match{match("containers[1].name", "proxy")}},
+		{"labels.*", []Match{match(`labels.app\.kubernetes\.io/name`, "app")}},
+		{"missing.*", nil},
+		{"image[*]", nil},
+		{"args[5]", nil},
+		{"args[-1]", []Match{match("args[1]", "--verbose")}},
+		{"args[-3]", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			t.Parallel()
+			got, err := v.Query(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// The whole document
+	all, err := v.Query("**")
+	require.NoError(t, err)
+	assert.Equal(t, Match{Value: map[string]interface{}(*v)}, all[0])
+	assert.Len(t, all, 38)
+}
+
+func TestValues_QueryMalformed(t *testing.T) {
+	t.Parallel()
+
+	v := Values{"a": []interface{}{1}}
+	for _, q := range []string{
+		"a.",
+		"a..b",
+		".a",
+		"a[",
+		"a[x]",
+		"a[+]",
+		"a[0]b",
+		"a]",
+		"a[?]",
+		"a[?name==]",
+		"a[?name==http]",
+		"a[?name=='http]",
+		"a[?=='http']",
+		"a[?b[0]==1",
+		"a[?b[0==1]",
+	} {
+		_, err := v.Query(q)
+		assert.ErrorIs(t, err, ErrMalformedQuery, q)
+	}
+}
@@ -0,0 +1,85 @@
+package values
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
+)
+
+// Rebalance moves the values of a tree built by ExtractCommonRecursive to
+// where they belong after some of its files were edited. Values of a common
+// file that are no longer shared by all the files below it are pushed down,
+// and values that became shared are hoisted, so that the tree is again the one
+// ExtractCommonRecursive would build.
+//
+// The ideal placement is computed from the effective values of the leaves, as
+// InlineRecursive materializes them, extracted again with ExtractCommonRecursive
+// and the same options. Only the files whose values differ from the ideal ones
+// are then written: the others are left as they are, comments and formatting
+// included, and common files that are no longer needed are removed. The
+// effective values of the leaves never change.
+//
+// Returns the sorted list of files written or removed. A Report set with
+// WithReport gets the stats of these files, without entries.
+func Rebalance(root string, opts ...Option) ([]string, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	root = filepath.Clean(root)
+	if err := options.checkFiles(); err != nil {
+		return nil, err
+	}
+	options, commit, err := options.begin(root)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the ideal tree in a plan of its own, on top of the current files
+	ideal := &Plan{}
+	idealOpts := append(opts[:len(opts):len(opts)],
+		WithFileSystem(options.fs), WithDryRun(ideal), WithReport(nil), WithOutputDir(""))
+	if _, err := InlineRecursive(root, idealOpts...); err != nil {
+		return nil, err
+	}
+	if _, err := ExtractCommonRecursive(root, idealOpts...); err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, c := range ideal.Changes {
+		if c.Before != nil && c.After != nil {
+			same, err := sameValues(c.Before, c.After)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", c.Path, err)
+			}
+			if same {
+				continue
+			}
+		}
+		if err := applyChange(options.fs, c); err != nil {
+			return nil, err
+		}
+		if options.report != nil {
+			options.report.track(c.Path, c.Before, c.After)
+		}
+		changed = append(changed, c.Path)
+	}
+
+	if err := commit(); err != nil {
+		return nil, err
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// sameValues reports whether two versions of a values file hold the same
+// values.
+func sameValues(a, b []byte) (bool, error) {
+	if isEmptyDocument(a) && isEmptyDocument(b) {
+		return true, nil
+	}
+	return yamllib.EqualYAMLStreams(a, b)
+}
@@ -0,0 +1,99 @@
+package values
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// effectiveTree returns the effective values of the leaves under root.
+func effectiveTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	out := t.TempDir()
+	_, err := InlineRecursive(root, WithOutputDir(out))
+	require.NoError(t, err)
+	return readTree(t, out)
+}
+
+func TestRebalance(t *testing.T) {
+	t.Parallel()
+
+	root, _ := setupEnvTree(t)
+	_, err := ExtractCommonRecursive(root)
+	require.NoError(t, err)
+
+	// A normalized tree is left untouched
+	changed, err := Rebalance(root)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	// The team of a single environment changes: the value is no longer
+	// common, and must go down to the apps and their environments
+	apiProd := filepath.Join(root, "apps", "api", "prod", "values.yaml")
+	mustWriteFile(t, apiProd, []byte("env: prod\nteam: payments\n"))
+	webDev := filepath.Join(root, "apps", "web", "dev", "values.yaml")
+	webDevBefore := mustReadFile(t, webDev)
+	before := effectiveTree(t, root)
+
+	changed, err = Rebalance(root)
+	require.NoError(t, err)
+	assert.Equal(t, before, effectiveTree(t, root))
+	assert.Equal(t, []string{
+		filepath.Join(root, "apps", "api", "dev", "values.yaml"),
+		filepath.Join(root, "apps", "cron", "values.yaml"),
+		filepath.Join(root, "apps", "values.yaml"),
+		filepath.Join(root, "apps", "web", "values.yaml"),
+		filepath.Join(root, "apps", "worker", "values.yaml"),
+	}, changed)
+	assertFileDoesNotExist(t, filepath.Join(root, "apps", "values.yaml"))
+	assertYAMLEqual(t, []byte("app: web\nteam: core\n"), mustReadFile(t, filepath.Join(root, "apps", "web", "values.yaml")))
+	assertYAMLEqual(t, []byte("app: api\n"), mustReadFile(t, filepath.Join(root, "apps", "api", "values.yaml")))
+	assertYAMLEqual(t, []byte("env: dev\nteam: core\n"), mustReadFile(t, filepath.Join(root, "apps", "api", "dev", "values.yaml")))
+	assert.Equal(t, string(webDevBefore), string(mustReadFile(t, webDev)))
+
+	// Reverting the change promotes the value again
+	mustWriteFile(t, apiProd, []byte("env: prod\nteam: core\n"))
+	changed, err = Rebalance(root)
+	require.NoError(t, err)
+	assert.NotEmpty(t, changed)
+	assertYAMLEqual(t, []byte("team: core\n"), mustReadFile(t, filepath.Join(root, "apps", "values.yaml")))
+	assertYAMLEqual(t, []byte("app: api\n"), mustReadFile(t, filepath.Join(root, "apps", "api", "values.yaml")))
+	assertYAMLEqual(t, []byte("env: prod\n"), mustReadFile(t, apiProd))
+}
+
+func TestRebalanceKeepsUnchangedFiles(t *testing.T) {
+	t.Parallel()
+
+	root, dirs := setupTempDirs(t, "a", "b", "c")
+	paths := setupValuesFiles(t, dirs, [][]byte{
+		[]byte("# a overrides\nport: 1\n"),
+		[]byte("# b overrides\nport: 2\n"),
+		[]byte("port: 3\n"),
+	})
+	mustWriteFile(t, filepath.Join(root, "values.yaml"), []byte("# shared\nimage: nginx\n"))
+
+	// c now shares the port of a, but not of b
+	mustWriteFile(t, paths[2], []byte("port: 1\n"))
+	plan := &Plan{}
+	changed, err := Rebalance(root, WithDryRun(plan))
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.True(t, plan.Empty())
+
+	// A new value shared by all is hoisted, and only the files holding it change
+	for _, p := range paths {
+		mustWriteFile(t, p, append(mustReadFile(t, p), "replicas: 2\n"...))
+	}
+	plan = &Plan{}
+	changed, err = Rebalance(root, WithDryRun(plan))
+	require.NoError(t, err)
+	expected := append([]string{filepath.Join(root, "values.yaml")}, paths...)
+	sort.Strings(expected)
+	assert.Equal(t, expected, changed)
+	require.NoError(t, Apply(plan))
+	assertYAMLEqual(t, []byte("image: nginx\nreplicas: 2\n"), mustReadFile(t, filepath.Join(root, "values.yaml")))
+	assertYAMLEqual(t, []byte("port: 2\n"), mustReadFile(t, paths[1]))
+}
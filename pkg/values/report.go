@@ -0,0 +1,193 @@
+package values
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
+)
+
+// Report describes what an extraction moved where. Pass a Report to any of the
+// file-level functions with WithReport and it is filled as files are written;
+// a single Report can collect several runs.
+type Report struct {
+	// Entries lists every leaf hoisted into a common file, in the order the
+	// extraction steps happened.
+	Entries []ReportEntry `json:"entries"`
+	// Files lists every file written, with its size before the first and after
+	// the last write.
+	Files []FileStats `json:"files"`
+
+	// mu guards the report while a parallel extraction fills it.
+	mu sync.Mutex
+}
+
+// ReportEntry describes a leaf hoisted into a common file.
+type ReportEntry struct {
+	// Path is the dotted key path of the leaf, for example "image.tag", with
+	// dots in keys escaped, as in `podAnnotations.prometheus\.io/scrape`.
+	Path string `json:"path"`
+	// Document is the index of the document for multi-document files.
+	Document int `json:"document,omitempty"`
+	// Sources are the files that held the same value at Path.
+	Sources []string `json:"sources"`
+	// Destination is the common file the leaf now lives in.
+	Destination string `json:"destination"`
+	// RemovedFrom are the files the leaf was removed from. Files with a
+	// different value keep it as an override and are not listed.
+	RemovedFrom []string `json:"removedFrom"`
+}
+
+// FileStats holds the size of a file before and after an extraction. Files
+// created by the extraction start with zero bytes and lines.
+type FileStats struct {
+	Path        string `json:"path"`
+	BytesBefore int    `json:"bytesBefore"`
+	BytesAfter  int    `json:"bytesAfter"`
+	LinesBefore int    `json:"linesBefore"`
+	LinesAfter  int    `json:"linesAfter"`
+}
+
+// WithReport fills r with the leaves hoisted and the files written.
+func WithReport(r *Report) Option {
+	return func(o *Options) { o.report = r }
+}
+
+// BytesSaved returns the number of bytes saved across all the files written.
+func (r *Report) BytesSaved() int {
+	saved := 0
+	for _, f := range r.Files {
+		saved += f.BytesBefore - f.BytesAfter
+	}
+	return saved
+}
+
+// LinesSaved returns the number of lines saved across all the files written.
+func (r *Report) LinesSaved() int {
+	saved := 0
+	for _, f := range r.Files {
+		saved += f.LinesBefore - f.LinesAfter
+	}
+	return saved
+}
+
+// JSON returns the report as indented JSON, including the totals saved.
+func (r *Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(struct {
+		*Report
+		BytesSaved int `json:"bytesSaved"`
+		LinesSaved int `json:"linesSaved"`
+	}{r, r.BytesSaved(), r.LinesSaved()}, "", "  ")
+}
+
+// Markdown returns a summary of the report suitable for a pull request
+// description.
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	b.WriteString("## Values extraction\n\n")
+	if len(r.Entries) == 0 {
+		b.WriteString("No values were moved.\n")
+	} else {
+		b.WriteString("| Path | Moved to | Removed from |\n")
+		b.WriteString("|------|----------|--------------|\n")
+		for _, e := range r.Entries {
+			path := e.Path
+			if e.Document > 0 {
+				path = fmt.Sprintf("%s (document %d)", path, e.Document)
+			}
+			fmt.Fprintf(&b, "| `%s` | `%s` | %s |\n", path, e.Destination, markdownList(e.RemovedFrom))
+		}
+	}
+	if len(r.Files) > 0 {
+		fmt.Fprintf(&b, "\n%d files written, %d bytes and %d lines saved.\n", len(r.Files), r.BytesSaved(), r.LinesSaved())
+	}
+	return b.String()
+}
+
+func markdownList(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	quoted := make([]string, len(items))
+	for i, s := range items {
+		quoted[i] = "`" + s + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// record adds to the report the leaves moved from the inputs into commonPath
+// and the sizes of the files about to be written, parentY being the content of
+// commonPath once merged with what it already held. It must be called before
+// the files are written, so the current content of commonPath can be read.
+func (o Options) record(inputs []string, yams [][]byte, commonPath string, commonY, parentY []byte, remainders [][]byte) error {
+	if o.report == nil {
+		return nil
+	}
+	leaves, err := yamllib.HoistedLeaves(yams, commonY, remainders, o.yamlOptions()...)
+	if err != nil {
+		return err
+	}
+	var before []byte
+	if fi, err := o.fs.Stat(commonPath); err == nil && !fi.IsDir() {
+		if before, err = o.fs.ReadFile(commonPath); err != nil {
+			return err
+		}
+	}
+
+	o.report.mu.Lock()
+	defer o.report.mu.Unlock()
+	for _, l := range leaves {
+		o.report.Entries = append(o.report.Entries, ReportEntry{
+			Path:        l.Path,
+			Document:    l.Document,
+			Sources:     pick(inputs, l.Sources),
+			Destination: commonPath,
+			RemovedFrom: pick(inputs, l.RemovedFrom),
+		})
+	}
+	o.report.track(commonPath, before, parentY)
+	for i, p := range inputs {
+		o.report.track(p, yams[i], remainders[i])
+	}
+	return nil
+}
+
+// track updates the stats of the file at path, keeping the size it had before
+// it was first written.
+func (r *Report) track(path string, before, after []byte) {
+	for i := range r.Files {
+		if r.Files[i].Path == path {
+			r.Files[i].BytesAfter, r.Files[i].LinesAfter = len(after), countLines(after)
+			return
+		}
+	}
+	r.Files = append(r.Files, FileStats{
+		Path:        path,
+		BytesBefore: len(before),
+		BytesAfter:  len(after),
+		LinesBefore: countLines(before),
+		LinesAfter:  countLines(after),
+	})
+}
+
+// pick returns the elements of items at the given indices.
+func pick(items []string, indices []int) []string {
+	out := make([]string, 0, len(indices))
+	for _, i := range indices {
+		out = append(out, items[i])
+	}
+	return out
+}
+
+// countLines returns the number of lines in b, counting a last line without a
+// trailing newline.
+func countLines(b []byte) int {
+	n := bytes.Count(b, []byte("\n"))
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,251 @@
+package values
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// journalName is the name of the journal file written in the directory of an
+// operation while its changes are being committed.
+const journalName = ".values-journal.json"
+
+// ErrPendingJournal is returned by dry runs when an interrupted operation left
+// a journal behind, and by every operation when the journal is in a parent
+// directory and covers some of its files. Run Recover on the directory of the
+// journal, or there any operation without WithDryRun, first.
+var ErrPendingJournal = errors.New("an interrupted operation must be recovered first")
+
+// journal records the content files had before a transaction, so it can be
+// rolled back if it is interrupted.
+type journal struct {
+	Entries []journalEntry `json:"entries"`
+}
+
+type journalEntry struct {
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"`
+	Backup  []byte `json:"backup,omitempty"`
+}
+
+// begin starts the transaction of an operation on the files under scope. The
+// returned options stage every write in a plan, and commit performs them all
+// together. A journal left in scope by an interrupted operation is rolled back
+// first, while a journal in a parent directory covering files in scope is
+// refused with ErrPendingJournal, as rolling it back would touch files out of
+// scope.
+//
+// In dry runs, and in operations nested in another one, the writes go to the
+// plan already set and commit does nothing.
+func (o Options) begin(scope string) (Options, func() error, error) {
+	if dir, err := parentJournal(o.fs, scope); err != nil {
+		return o, nil, err
+	} else if dir != "" {
+		return o, nil, fmt.Errorf("%w: %s", ErrPendingJournal, filepath.Join(dir, journalName))
+	}
+	if o.plan != nil {
+		o = o.dryRun()
+		if pending, err := hasJournal(o.fs, scope); err != nil {
+			return o, nil, err
+		} else if pending {
+			return o, nil, fmt.Errorf("%w: %s", ErrPendingJournal, filepath.Join(scope, journalName))
+		}
+		return o, func() error { return nil }, nil
+	}
+
+	base := o.fs
+	if _, err := recoverJournal(base, scope); err != nil {
+		return o, nil, err
+	}
+	p := &Plan{}
+	o.plan = p
+	o = o.dryRun()
+	return o, func() error { return commitPlan(base, scope, p, o.progress) }, nil
+}
+
+// nested returns opts extended so that a nested operation writes to the same
+// plan as the current one.
+func (o Options) nested(opts []Option) []Option {
+	return append(opts[:len(opts):len(opts)], WithDryRun(o.plan))
+}
+
+// Recover rolls back the operation interrupted while committing its changes in
+// dir, if any, restoring every file it touched, and removes the temporary
+// files left under dir by interrupted writes, skipping what the recursive
+// functions skip. Temporary files modified in the last ten minutes may belong
+// to writes in progress and are kept. It reports whether there was something
+// to roll back. Operations recover their own directory automatically, and
+// clean up the directories they write, so Recover is only needed to do it
+// explicitly.
+func Recover(dir string, opts ...Option) (bool, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	recovered, err := recoverJournal(options.fs, dir)
+	if err != nil {
+		return false, err
+	}
+	err = options.walkTree(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return removeTempFile(options.fs, path, d)
+	})
+	return recovered, err
+}
+
+// commitPlan performs the changes of a plan as a transaction: the previous
+// content of every file is saved in a journal in scope, the changes are
+// performed, and the journal is removed. If a change fails, the files already
+// changed are restored. Every change performed is reported to progress. The
+// stale temporary files of interrupted writes are removed first from the
+// directories written.
+func commitPlan(ops FileSystem, scope string, p *Plan, progress func(ProgressEvent)) error {
+	if p.Empty() {
+		return nil
+	}
+	dirs := map[string]bool{scope: true}
+	for _, c := range p.Changes {
+		dirs[filepath.Dir(c.Path)] = true
+	}
+	for dir := range dirs {
+		if err := removeTempFiles(ops, dir); err != nil {
+			return err
+		}
+	}
+	j := journal{Entries: make([]journalEntry, len(p.Changes))}
+	for i, c := range p.Changes {
+		j.Entries[i] = journalEntry{Path: c.Path, Existed: c.Before != nil, Backup: c.Before}
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := ops.WriteFileAtomic(filepath.Join(scope, journalName), b, 0o600); err != nil {
+		return err
+	}
+
+	for i, c := range p.Changes {
+		if err := applyChange(ops, c); err != nil {
+			if rerr := rollback(ops, j.Entries[:i+1]); rerr != nil {
+				return fmt.Errorf("%w (rollback failed, run Recover on %s: %v)", err, scope, rerr)
+			}
+			if cerr := clearJournal(ops, scope); cerr != nil {
+				return fmt.Errorf("%w (%v)", err, cerr)
+			}
+			return err
+		}
+		notify(progress, ProgressEvent{Kind: FileWritten, Path: c.Path})
+	}
+	return clearJournal(ops, scope)
+}
+
+// applyChange performs a single change of a plan.
+func applyChange(ops FileSystem, c FileChange) error {
+	if c.Kind == ChangeRemove {
+		return ops.Remove(c.Path)
+	}
+	return ops.WriteFileAtomic(c.Path, c.After, 0o644)
+}
+
+// rollback restores the files of the journal entries, in reverse order.
+func rollback(ops FileSystem, entries []journalEntry) error {
+	var errs []error
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Existed {
+			errs = append(errs, ops.WriteFileAtomic(e.Path, e.Backup, 0o644))
+			continue
+		}
+		if _, err := ops.Stat(e.Path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		errs = append(errs, ops.Remove(e.Path))
+	}
+	return errors.Join(errs...)
+}
+
+// recoverJournal rolls back the transaction journaled in dir, if any.
+func recoverJournal(ops FileSystem, dir string) (bool, error) {
+	path := filepath.Join(dir, journalName)
+	b, err := readIfExists(ops, path)
+	if err != nil || len(b) == 0 {
+		return false, err
+	}
+	var j journal
+	if err := json.Unmarshal(b, &j); err != nil {
+		return false, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := rollback(ops, j.Entries); err != nil {
+		return false, err
+	}
+	return true, clearJournal(ops, dir)
+}
+
+// parentJournal returns the parent directory of scope holding the journal of
+// an interrupted operation that touched files in scope, if any. Journals of
+// operations on other parts of the tree are ignored, as they may be running.
+func parentJournal(ops FileSystem, scope string) (string, error) {
+	if filepath.Dir(scope) == scope {
+		return "", nil
+	}
+	for dir := filepath.Dir(scope); ; dir = filepath.Dir(dir) {
+		path := filepath.Join(dir, journalName)
+		b, err := readIfExists(ops, path)
+		if err != nil {
+			return "", err
+		}
+		if len(b) > 0 {
+			var j journal
+			if err := json.Unmarshal(b, &j); err != nil {
+				return "", fmt.Errorf("reading %s: %w", path, err)
+			}
+			for _, e := range j.Entries {
+				if isWithin(scope, e.Path) {
+					return dir, nil
+				}
+			}
+		}
+		if dir == filepath.Dir(dir) {
+			return "", nil
+		}
+	}
+}
+
+// hasJournal reports whether dir holds the journal of an interrupted operation.
+func hasJournal(ops FileSystem, dir string) (bool, error) {
+	b, err := readIfExists(ops, filepath.Join(dir, journalName))
+	return len(b) > 0, err
+}
+
+// clearJournal removes the journal in dir.
+func clearJournal(ops FileSystem, dir string) error {
+	return ops.Remove(filepath.Join(dir, journalName))
+}
+
+// commonDir returns the deepest directory containing all the paths.
+func commonDir(paths []string) string {
+	if len(paths) == 0 {
+		return "."
+	}
+	dir := filepath.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for !isWithin(dir, p) && dir != filepath.Dir(dir) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// isWithin reports whether path is inside dir.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
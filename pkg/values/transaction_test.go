@@ -0,0 +1,156 @@
+package values
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failingOps fails the writes for which fail returns true.
+type failingOps struct {
+	OSFS
+	fail func(path string) bool
+}
+
+func (f failingOps) WriteFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	if f.fail(path) {
+		return errInjected
+	}
+	return f.OSFS.WriteFileAtomic(path, data, perm)
+}
+
+// failAfter returns a fail function letting the first n writes through.
+func failAfter(n int) func(string) bool {
+	return func(string) bool {
+		n--
+		return n < 0
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{[]byte("a: 1\nb: 1\n"), []byte("a: 1\nb: 2\n"), []byte("a: 1\nb: 3\n")}
+	root, fullDirs := setupTempDirs(t, "x", "y", "z")
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	// The first write of z fails, after x, y and the common file were written
+	failed := false
+	fail := func(path string) bool {
+		if path == paths[2] && !failed {
+			failed = true
+			return true
+		}
+		return false
+	}
+	_, err := ExtractCommonN(paths, WithFileSystem(failingOps{fail: fail}))
+	require.ErrorIs(t, err, errInjected)
+
+	for i, p := range paths {
+		assert.Equal(t, string(inputs[i]), string(mustReadFile(t, p)))
+	}
+	for _, name := range []string{"values.yaml", journalName} {
+		_, err := os.Stat(filepath.Join(root, name))
+		assert.True(t, errors.Is(err, fs.ErrNotExist), "%s should not exist", name)
+	}
+}
+
+func TestTransactionRecovery(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{[]byte("a: 1\nb: 1\n"), []byte("a: 1\nb: 2\n"), []byte("a: 1\nb: 3\n")}
+	root, fullDirs := setupTempDirs(t, "x", "y", "z")
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	// The journal, the common file and x are written, then every write fails,
+	// including the rollback, as if the process was killed
+	_, err := ExtractCommonN(paths, WithFileSystem(failingOps{fail: failAfter(3)}))
+	require.ErrorIs(t, err, errInjected)
+	assert.Equal(t, "b: 1\n", string(mustReadFile(t, paths[0])))
+	_, err = os.Stat(filepath.Join(root, journalName))
+	require.NoError(t, err)
+
+	// dry runs refuse to plan on top of a half-applied operation
+	var plan Plan
+	_, err = ExtractCommonN(paths, WithDryRun(&plan))
+	assert.ErrorIs(t, err, ErrPendingJournal)
+
+	recovered, err := Recover(root)
+	require.NoError(t, err)
+	assert.True(t, recovered)
+	_, err = os.Stat(filepath.Join(root, "values.yaml"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	for i, p := range paths {
+		assert.Equal(t, string(inputs[i]), string(mustReadFile(t, p)))
+	}
+
+	recovered, err = Recover(root)
+	require.NoError(t, err)
+	assert.False(t, recovered)
+}
+
+func TestTransactionPendingInParent(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("a: 1\nb: 1\n"), []byte("a: 1\nb: 2\n"),
+		[]byte("c: 1\nd: 1\n"), []byte("c: 1\nd: 2\n"),
+		[]byte("e: 1\n"), []byte("e: 1\n"),
+	}
+	root, fullDirs := setupTempDirs(t, "x", "y", "x/p", "x/q", "z/p", "z/q")
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	// The operation on root is interrupted after writing the common file
+	_, err := ExtractCommon(paths[0], paths[1], WithFileSystem(failingOps{fail: failAfter(2)}))
+	require.ErrorIs(t, err, errInjected)
+	_, err = os.Stat(filepath.Join(root, journalName))
+	require.NoError(t, err)
+
+	// Operations on the files it touched refuse to run
+	_, err = ExtractCommon(paths[2], paths[3])
+	assert.ErrorIs(t, err, ErrPendingJournal)
+	assert.ErrorContains(t, err, filepath.Join(root, journalName))
+	var plan Plan
+	_, err = ExtractCommon(paths[2], paths[3], WithDryRun(&plan))
+	assert.ErrorIs(t, err, ErrPendingJournal)
+
+	// Operations on other parts of the tree are not affected
+	_, err = ExtractCommon(paths[4], paths[5])
+	require.NoError(t, err)
+
+	recovered, err := Recover(root)
+	require.NoError(t, err)
+	assert.True(t, recovered)
+	_, err = ExtractCommon(paths[2], paths[3])
+	require.NoError(t, err)
+	assertYAMLEqual(t, []byte("a: 1\nb: 1\nc: 1"), mustReadFile(t, paths[0]))
+}
+
+func TestTransactionRecoveredOnNextRun(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{[]byte("a: 1\nb: 1\n"), []byte("a: 1\nb: 2\n")}
+	root, fullDirs := setupTempDirs(t, "x", "y")
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	_, err := ExtractCommonRecursive(root, WithFileSystem(failingOps{fail: failAfter(3)}))
+	require.ErrorIs(t, err, errInjected)
+
+	created, err := ExtractCommonRecursive(root)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(root, "values.yaml")}, created)
+	common := mustReadFile(t, created[0])
+	assertYAMLEqual(t, []byte("a: 1"), common)
+	for i, p := range paths {
+		validateMergeProperty(t, inputs[i], common, mustReadFile(t, p))
+	}
+	_, err = os.Stat(filepath.Join(root, journalName))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
@@ -8,7 +8,6 @@ import (
 	"testing"
 
 	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
-	"github.com/psanford/memfs"
 	syaml "sigs.k8s.io/yaml"
 )
 
@@ -66,32 +65,16 @@ func validateMergeProperty(t *testing.T, original, common, updated []byte) {
 	assertYAMLEqual(t, original, reconstructed)
 }
 
-// Test utilities for memfs operations
+// Test utilities for MemFS operations
 
-// memfsOps implements fileOps on top of github.com/psanford/memfs for use in tests.
-type memfsOps struct{ fsys *memfs.FS }
-
-func (m memfsOps) Stat(name string) (fs.FileInfo, error)        { return fs.Stat(m.fsys, name) }
-func (m memfsOps) ReadFile(name string) ([]byte, error)         { return fs.ReadFile(m.fsys, name) }
-func (m memfsOps) WalkDir(root string, fn fs.WalkDirFunc) error { return fs.WalkDir(m.fsys, root, fn) }
-func (m memfsOps) WriteFileAtomic(path string, data []byte, perm fs.FileMode) error {
-	if err := m.fsys.MkdirAll(filepath.Dir(path), 0o750); err != nil {
-		return err
-	}
-	return m.fsys.WriteFile(path, data, perm)
-}
-
-func writeMemFile(t *testing.T, mfs *memfs.FS, path string, data []byte) {
+func writeMemFile(t *testing.T, mfs *MemFS, path string, data []byte) {
 	t.Helper()
-	if err := mfs.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		t.Fatalf("mkdir: %v", err)
-	}
-	if err := mfs.WriteFile(path, data, 0o600); err != nil {
+	if err := mfs.WriteFileAtomic(path, data, 0o600); err != nil {
 		t.Fatalf("write file: %v", err)
 	}
 }
 
-func readMemFile(t *testing.T, mfs *memfs.FS, path string) []byte {
+func readMemFile(t *testing.T, mfs *MemFS, path string) []byte {
 	t.Helper()
 	b, err := fs.ReadFile(mfs, path)
 	if err != nil {
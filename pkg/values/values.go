@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
+	"reflect"
 	"strconv"
 	"strings"
 
@@ -28,6 +28,12 @@ var (
 	ErrKeyNotFound       = errors.New("unable to find the key")
 	ErrIndexOutOfBounds  = errors.New("index out of bounds")
 	ErrInvalidType       = errors.New("invalid type conversion")
+	ErrMalformedQuery    = errors.New("malformed query")
+	ErrMalformedPath     = errors.New("malformed path")
+
+	// ErrMultipleDocuments is returned when a single Values is created from a
+	// YAML stream with several documents. Use NewValuesListFromYAML instead.
+	ErrMultipleDocuments = yaml.ErrMultipleDocuments
 )
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -53,6 +59,8 @@ func toInt(v interface{}) (int, error) {
 			return 0, fmt.Errorf("%w: %v", ErrInvalidType, err)
 		}
 		return i, nil
+	case yaml.Scalar:
+		return toInt(val.Interface())
 	default:
 		return 0, fmt.Errorf("%w: cannot convert %T to int", ErrInvalidType, v)
 	}
@@ -75,6 +83,8 @@ func toString(v interface{}) (string, error) {
 		return strconv.FormatFloat(val, 'f', -1, 64), nil
 	case bool:
 		return strconv.FormatBool(val), nil
+	case yaml.Scalar:
+		return val.Value, nil
 	default:
 		return "", fmt.Errorf("%w: cannot convert %T to string", ErrInvalidType, v)
 	}
@@ -98,11 +108,6 @@ type ValuesAnnotation struct {
 	Value string `json:"value,omitempty"`
 }
 
-// ValuesPath is a path to a value in the Values map.
-// It is a string of keys separated by the SplitToken.
-// For example, "httpProxy.annotations.trans.id" is a valid ValuesPath.
-type ValuesPath string
-
 ///////////////////////////////////////////////////////////////////////////////
 
 // Values is a map of values that can be used to render the CGW Helm chart.
@@ -115,7 +120,11 @@ func NewValues() *Values {
 }
 
 // NewValuesFromMap creates a new Values instance from a YAML document.
+// It returns ErrMultipleDocuments if b is a stream with several documents.
 func NewValuesFromYAML(b []byte) (*Values, error) {
+	if yaml.IsStream(b) {
+		return nil, ErrMultipleDocuments
+	}
 	v := Values{}
 	if err := syaml.Unmarshal(b, &v); err != nil {
 		return nil, err
@@ -123,6 +132,61 @@ func NewValuesFromYAML(b []byte) (*Values, error) {
 	return &v, nil
 }
 
+// NewValuesFromYAMLWithFidelity creates a new Values instance from a YAML
+// document keeping every scalar as a yaml.Scalar, with its original tag,
+// lexical form and quoting style. ToYAML and Merge keep those scalars
+// untouched, so big integers, `1.0`, `0755` or `on` are written back exactly as
+// they were read. Lookups return the yaml.Scalar values; LookupString returns
+// their lexical form and LookupInt their decoded value.
+func NewValuesFromYAMLWithFidelity(b []byte) (*Values, error) {
+	decoded, err := yaml.UnmarshalFidelity(b)
+	if err != nil {
+		return nil, err
+	}
+	if decoded == nil {
+		return &Values{}, nil
+	}
+	m, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: cannot convert %T to Values", ErrInvalidType, decoded)
+	}
+	v := Values(m)
+	return &v, nil
+}
+
+// NewValuesListFromYAML creates one Values instance per document in a YAML
+// stream ("---" separated). Empty documents produce empty Values, so the
+// result keeps the layout of the stream.
+func NewValuesListFromYAML(b []byte) ([]*Values, error) {
+	docs := yaml.SplitDocuments(b)
+	list := make([]*Values, len(docs))
+	for i, doc := range docs {
+		v, err := NewValuesFromYAML(doc)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+// ValuesListToYAML serializes a list of Values as a YAML stream, one document
+// per element.
+func ValuesListToYAML(list []*Values) ([]byte, error) {
+	docs := make([][]byte, len(list))
+	for i, v := range list {
+		if v == nil {
+			v = NewValues()
+		}
+		b, err := v.ToYAML()
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		docs[i] = b
+	}
+	return yaml.JoinDocuments(docs), nil
+}
+
 // NewValuesFromJSON creates a new Values instance from a JSON document.
 func NewValuesFromJSON(b []byte) (*Values, error) {
 	v := Values{}
@@ -132,8 +196,18 @@ func NewValuesFromJSON(b []byte) (*Values, error) {
 	return &v, nil
 }
 
-// NewValuesFromFileInFS creates a new Values instance from a file in a file system.
+// NewValuesFromFileInFS creates a new Values instance from a file in a file
+// system. When f is also a FileSystem, such as OSFS or BasePathFS, the file
+// is read with its ReadFile, so filename can be an OS path as with
+// WithFileSystem.
 func NewValuesFromFileInFS(f fs.FS, filename string) (*Values, error) {
+	if fsys, ok := f.(FileSystem); ok {
+		data, err := fsys.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		return NewValuesFromYAML(data)
+	}
 	file, err := f.Open(filename)
 	if err != nil {
 		return nil, err
@@ -148,9 +222,14 @@ func NewValuesFromFileInFS(f fs.FS, filename string) (*Values, error) {
 	return NewValuesFromYAML(data)
 }
 
-// NewValuesFromFile creates a new Values instance from a file.
-func NewValuesFromFile(filename string) (*Values, error) {
-	data, err := os.ReadFile(filename)
+// NewValuesFromFile creates a new Values instance from a file. The file is
+// read from the OS filesystem, or from the FileSystem set with WithFileSystem.
+func NewValuesFromFile(filename string, opts ...Option) (*Values, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	data, err := options.fs.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +266,14 @@ func (v Values) EqualYAML(other Values) bool {
 	return equal
 }
 
+// ToYAML returns the YAML representation of the Values. Values created with
+// NewValuesFromYAMLWithFidelity are rendered with their scalars exactly as they
+// were read.
 func (c Values) ToYAML() ([]byte, error) {
+	if hasFidelityScalars(c) {
+		return yaml.MarshalFidelity(map[string]interface{}(c))
+	}
+
 	asJSON, err := json.Marshal(c)
 	if err != nil {
 		return nil, err
@@ -201,6 +287,29 @@ func (c Values) ToYAML() ([]byte, error) {
 	return asYAML, nil
 }
 
+// hasFidelityScalars reports whether v holds any yaml.Scalar.
+func hasFidelityScalars(v interface{}) bool {
+	switch val := v.(type) {
+	case yaml.Scalar:
+		return true
+	case Values:
+		return hasFidelityScalars(map[string]interface{}(val))
+	case map[string]interface{}:
+		for _, vv := range val {
+			if hasFidelityScalars(vv) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, vv := range val {
+			if hasFidelityScalars(vv) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (c Values) MustToYAML() []byte {
 	asYAML, err := c.ToYAML()
 	if err != nil {
@@ -242,6 +351,8 @@ func (v *Values) DeepCopy() *Values {
 // Lookup returns the value associated with the given key.
 // Keys can be nested using the "." character.
 // Indexing is supported using the "[<index>]" syntax.
+// Keys holding dots or brackets are escaped with a backslash or quoted in
+// brackets, as in ParsePath, and ValuesPath.String formats paths for it.
 // Supported types are:
 // - string
 // - []string
@@ -252,94 +363,44 @@ func (v *Values) DeepCopy() *Values {
 // Examples:
 // - "foo.bar" returns the value associated with the "bar" key in the "foo" map.
 // - "foo[0].bar" returns the value associated with the "bar" key in the first element of the "foo" array.
+// - "foo[-1]" returns the last element of the "foo" array.
+// - `annotations.translation\.adobe\.io/ams-env` returns the value of the "translation.adobe.io/ams-env" key in the "annotations" map.
 func (v Values) Lookup(key string) (any, error) {
-	if key == "" {
-		return v, nil
-	}
-
-	// Split the key into components
-	parts := strings.Split(key, ".")
-	if len(parts) == 0 {
-		return nil, ErrKeyNotFound
-	}
-
-	// Parse the first component for array indexing
-	firstKey, index, err := parseIndex(parts[0])
+	p, err := ParsePath(key)
 	if err != nil {
 		return nil, err
 	}
-
-	// Get the value for the first key
-	value, exists := v[firstKey]
-	if !exists {
-		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, firstKey)
-	}
-
-	// If we have an index, handle array/slice access
-	if index >= 0 {
-		value, err = getIndexedValue(value, index)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// If this was the last component, return the value
-	if len(parts) == 1 {
-		return value, nil
-	}
-
-	// Otherwise, continue with nested lookup
-	restKey := strings.Join(parts[1:], ".")
-	return lookupNested(value, restKey)
+	return v.LookupPath(p)
 }
 
-// getIndexedValue retrieves a value from an array/slice at the given index
-func getIndexedValue(value interface{}, index int) (interface{}, error) {
-	switch v := value.(type) {
-	case []interface{}:
-		if index >= len(v) {
-			return nil, ErrIndexOutOfBounds
-		}
-		return v[index], nil
-	case []string:
-		if index >= len(v) {
-			return nil, ErrIndexOutOfBounds
-		}
-		return v[index], nil
-	case []int:
-		if index >= len(v) {
-			return nil, ErrIndexOutOfBounds
+// LookupPath is the same as Lookup() but takes a parsed path.
+func (v Values) LookupPath(p ValuesPath) (any, error) {
+	var value any = v
+	for i, e := range p {
+		if m, ok := asMap(value); ok && !e.IsIndex {
+			child, exists := m[e.Key]
+			if !exists {
+				return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, p[:i+1])
+			}
+			value = child
+			continue
 		}
-		return v[index], nil
-	case []Values:
-		if index >= len(v) {
-			return nil, ErrIndexOutOfBounds
-		}
-		return v[index], nil
-	default:
-		return nil, fmt.Errorf("%w: cannot index into %T", ErrInvalidType, value)
-	}
-}
 
-// lookupNested continues lookup in a nested structure
-func lookupNested(value interface{}, key string) (interface{}, error) {
-	switch v := value.(type) {
-	case Values:
-		return v.Lookup(key)
-	case map[string]interface{}:
-		return Values(v).Lookup(key)
-	case string, int, int32, int64, float32, float64, bool:
-		// Terminal values cannot have nested lookups
-		if key == "" {
-			return v, nil
-		}
-		return nil, fmt.Errorf("%w: cannot lookup %s in %T", ErrKeyNotFound, key, v)
-	default:
-		if key == "" {
-			return v, nil
+		l, isList := asList(value)
+		index, isIndex := e.listIndex(len(l))
+		switch {
+		case isList && isIndex:
+			if index < 0 || index >= len(l) {
+				return nil, fmt.Errorf("%w: %s", ErrIndexOutOfBounds, p[:i+1])
+			}
+			value = l[index]
+		case e.IsIndex:
+			return nil, fmt.Errorf("%w: cannot index into %T at %s", ErrInvalidType, value, p[:i])
+		default:
+			return nil, fmt.Errorf("%w: cannot lookup %s in %T", ErrKeyNotFound, p[:i+1], value)
 		}
-		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
 	}
+	return value, nil
 }
 
 // LookupFist is the same as Lookup() but tries several possible keys until one of them is found.
@@ -354,8 +415,28 @@ func (v Values) LookupFirst(keys []string) (any, string, error) {
 	return "", "", fmt.Errorf("%w: one of %+v", ErrKeyNotFound, keys)
 }
 
+// LookupFirstPath is the same as LookupFirst() but takes parsed paths.
+func (v Values) LookupFirstPath(paths []ValuesPath) (any, ValuesPath, error) {
+	for _, p := range paths {
+		val, err := v.LookupPath(p)
+		if err == nil {
+			return val, p, nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w: one of %+v", ErrKeyNotFound, paths)
+}
+
 func (v Values) LookupString(key string) (string, error) {
-	valAny, err := v.Lookup(key)
+	p, err := ParsePath(key)
+	if err != nil {
+		return "", err
+	}
+	return v.LookupStringPath(p)
+}
+
+// LookupStringPath is the same as LookupString() but takes a parsed path.
+func (v Values) LookupStringPath(p ValuesPath) (string, error) {
+	valAny, err := v.LookupPath(p)
 	if err != nil {
 		return "", err
 	}
@@ -363,7 +444,16 @@ func (v Values) LookupString(key string) (string, error) {
 }
 
 func (v Values) LookupValues(key string) (Values, error) {
-	valAny, err := v.Lookup(key)
+	p, err := ParsePath(key)
+	if err != nil {
+		return nil, err
+	}
+	return v.LookupValuesPath(p)
+}
+
+// LookupValuesPath is the same as LookupValues() but takes a parsed path.
+func (v Values) LookupValuesPath(p ValuesPath) (Values, error) {
+	valAny, err := v.LookupPath(p)
 	if err != nil {
 		return nil, err
 	}
@@ -386,8 +476,32 @@ func (v Values) LookupFirstString(keys []string) (string, string, error) {
 	return valStr, foundAt, nil
 }
 
+// LookupFirstStringPath is the same as LookupFirstString() but takes parsed
+// paths.
+func (v Values) LookupFirstStringPath(paths []ValuesPath) (string, ValuesPath, error) {
+	valAny, foundAt, err := v.LookupFirstPath(paths)
+	if err != nil {
+		return "", nil, err
+	}
+
+	valStr, err := toString(valAny)
+	if err != nil {
+		return "", nil, fmt.Errorf("key %s: %w", foundAt, err)
+	}
+	return valStr, foundAt, nil
+}
+
 func (v Values) LookupInt(key string) (int, error) {
-	valAny, err := v.Lookup(key)
+	p, err := ParsePath(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.LookupIntPath(p)
+}
+
+// LookupIntPath is the same as LookupInt() but takes a parsed path.
+func (v Values) LookupIntPath(p ValuesPath) (int, error) {
+	valAny, err := v.LookupPath(p)
 	if err != nil {
 		return 0, err
 	}
@@ -410,177 +524,121 @@ func (v Values) LookupFirstInt(keys []string) (int, string, error) {
 	return valInt, foundAt, nil
 }
 
+// LookupFirstIntPath is the same as LookupFirstInt() but takes parsed paths.
+func (v Values) LookupFirstIntPath(paths []ValuesPath) (int, ValuesPath, error) {
+	valAny, foundAt, err := v.LookupFirstPath(paths)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	valInt, err := toInt(valAny)
+	if err != nil {
+		return 0, nil, fmt.Errorf("key %s: %w", foundAt, err)
+	}
+	return valInt, foundAt, nil
+}
+
 // Set sets the value at the given key path.
 // Keys can be nested using the "." character.
 // Indexing is supported using the "[<index>]" syntax.
+// Keys are escaped or quoted as in Lookup.
 // The function will create intermediate maps/slices as needed.
 // Examples:
 // - "foo.bar" sets the value in the "bar" key in the "foo" map
 // - "foo[0].bar" sets the value in the "bar" key in the first element of the "foo" array
+// - "foo[-1]" sets the last element of the "foo" array
+// - "foo[+]" appends the value to the "foo" array
 func (v Values) Set(key string, value interface{}) error {
 	if key == "" {
 		return fmt.Errorf("%w: empty key", ErrInvalidIndexUsage)
 	}
-
-	parts := strings.Split(key, ".")
-	if len(parts) == 0 {
-		return nil
-	}
-
-	firstKey, index, err := parseIndex(parts[0])
+	p, err := ParsePath(key)
 	if err != nil {
 		return err
 	}
-
-	// If this is the final component, set the value directly
-	if len(parts) == 1 {
-		if index >= 0 {
-			return v.setArrayValue(firstKey, index, value)
-		}
-		v[firstKey] = value
-		return nil
-	}
-
-	// Handle intermediate nodes
-	restKey := strings.Join(parts[1:], ".")
-
-	if index >= 0 {
-		return v.setNestedArrayValue(firstKey, index, restKey, value)
-	}
-
-	return v.setNestedMapValue(firstKey, restKey, value)
+	return v.SetPath(p, value)
 }
 
-// setArrayValue sets a value in an array at the specified index
-func (v Values) setArrayValue(key string, index int, value interface{}) error {
-	existing := v[key]
-
-	// Convert existing value to []interface{} if needed
-	var arr []interface{}
-	switch e := existing.(type) {
-	case []interface{}:
-		arr = e
-	case nil:
-		arr = make([]interface{}, 0)
-	default:
-		// If the existing value is not an array, we need to replace it
-		arr = make([]interface{}, 0)
-	}
-
-	// Extend array if needed
-	if index >= len(arr) {
-		newArr := make([]interface{}, index+1)
-		copy(newArr, arr)
-		arr = newArr
+// SetPath is the same as Set() but takes a parsed path.
+func (v Values) SetPath(p ValuesPath, value interface{}) error {
+	if err := checkDestination(p); err != nil {
+		return err
 	}
-
-	arr[index] = value
-	v[key] = arr
-	return nil
+	_, err := setIn(v, p, 0, value)
+	return err
 }
 
-// setNestedArrayValue sets a nested value through an array index
-func (v Values) setNestedArrayValue(key string, index int, restKey string, value interface{}) error {
-	existing := v[key]
-
-	// Convert existing value to []interface{} if needed
-	var arr []interface{}
-	switch e := existing.(type) {
-	case []interface{}:
-		arr = e
-	case nil:
-		arr = make([]interface{}, index+1)
-	default:
-		arr = make([]interface{}, index+1)
-	}
-
-	// Extend array if needed
-	if index >= len(arr) {
-		newArr := make([]interface{}, index+1)
-		copy(newArr, arr)
-		arr = newArr
-	}
-
-	// Ensure we have a Values at this index
-	if arr[index] == nil {
-		arr[index] = make(Values)
+// setIn returns node with the value set at the path, updating the maps in
+// place, and creating or replacing the maps and lists on the way when they are
+// missing or of another type. Negative indices must be within the lists. The
+// node is the value at p[:at].
+func setIn(node any, p ValuesPath, at int, value any) (any, error) {
+	if at == len(p) {
+		return value, nil
 	}
+	e := p[at]
 
-	nested, err := toValues(arr[index])
-	if err != nil {
-		// Create new Values if conversion fails
-		nested = make(Values)
-		arr[index] = nested
+	l, isList := asList(node)
+	index, isIndex := e.listIndex(len(l))
+	if e.IsIndex || (isList && isIndex) {
+		if index < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrIndexOutOfBounds, p[:at+1])
+		}
+		if index >= len(l) {
+			extended := make([]interface{}, index+1)
+			copy(extended, l)
+			l = extended
+		}
+		child, err := setIn(l[index], p, at+1, value)
+		if err != nil {
+			return nil, err
+		}
+		l[index] = child
+		return l, nil
 	}
 
-	v[key] = arr
-	return nested.Set(restKey, value)
-}
-
-// setNestedMapValue sets a nested value in a map
-func (v Values) setNestedMapValue(key string, restKey string, value interface{}) error {
-	existing := v[key]
-
-	// Ensure we have a Values at this key
-	if existing == nil {
-		v[key] = make(Values)
-		existing = v[key]
+	m, ok := asMap(node)
+	if !ok {
+		created := make(Values)
+		m, node = created, created
 	}
-
-	nested, err := toValues(existing)
+	child, err := setIn(m[e.Key], p, at+1, value)
 	if err != nil {
-		// Create new Values if conversion fails
-		nested = make(Values)
-		v[key] = nested
+		return nil, err
 	}
-
-	return nested.Set(restKey, value)
+	m[e.Key] = child
+	return node, nil
 }
 
 // Rebase rebases the Values on top a given base.
-// The new base can be specified as a string of keys separated by the SplitToken.
+// The new base can be specified as a string of keys separated by the SplitToken,
+// escaped as in Lookup.
 // For example, if the Values is {"foo": {"bar": "baz"}} and
 // - the base is "new", then the result is { "new": {"foo": {"bar": "baz"}}}.
 // - the base is "new.base", then the result is {"new": { "base": {"foo": {"bar": "baz"}}}}.
+// - the base is "new[0]", then the result is {"new": [{"foo": {"bar": "baz"}}]},
+// as indices in the base create lists.
+//
+// Rebase cannot fail: bases that cannot be parsed, such as "new[0", or that
+// start with an index, are split at every SplitToken, taking every part as a
+// key as it is. Use ParsePath and RebasePath to get the parsing errors.
 func (v Values) Rebase(base string) *Values {
-	comps := strings.Split(base, SplitToken)
-	if len(comps) == 0 {
-		return &v
+	p, err := ParsePath(base)
+	if err != nil || len(p) == 0 || p[0].IsIndex {
+		p = NewPath(strings.Split(base, SplitToken)...)
 	}
-
-	this := comps[0]
-	rest := strings.Join(comps[1:], SplitToken)
-
-	if len(comps) == 1 {
-		return &Values{this: v}
-	}
-
-	return &Values{this: *v.Rebase(rest)}
+	return v.RebasePath(p)
 }
 
-func parseIndex(s string) (string, int, error) {
-	start := strings.Index(s, IndexOpenChar)
-	end := strings.Index(s, IndexCloseChar)
-
-	if start == -1 && end == -1 {
-		return s, -1, nil
-	}
-
-	if (start != -1 && end == -1) || (start == -1 && end != -1) {
-		return "", -1, ErrMalformedIndex
-	}
-
-	index, err := strconv.Atoi(s[start+1 : end])
-	if err != nil {
-		return "", -1, ErrMalformedIndex
-	}
-
-	// Reject negative indices
-	if index < 0 {
-		return "", -1, ErrMalformedIndex
+// RebasePath is the same as Rebase() but takes a parsed path, which must
+// start with a key.
+func (v Values) RebasePath(base ValuesPath) *Values {
+	if len(base) == 0 {
+		return &v
 	}
-
-	return s[:start], index, nil
+	rebased := Values{}
+	_ = rebased.SetPath(base, v)
+	return &rebased
 }
 
 ////////////////////////////////////////////////////////////////////////////
@@ -603,7 +661,7 @@ func newMergeConfig(opts ...MergeOption) *mergeConfig {
 }
 
 func (m mergeConfig) toMergoOptions() []func(*mergo.Config) {
-	opts := []func(*mergo.Config){}
+	opts := []func(*mergo.Config){mergo.WithTransformers(scalarTransformer{})}
 	if m.deepMergeSlice {
 		opts = append(opts, mergo.WithSliceDeepCopy)
 	}
@@ -613,6 +671,22 @@ func (m mergeConfig) toMergoOptions() []func(*mergo.Config) {
 	return opts
 }
 
+// scalarTransformer makes mergo handle yaml.Scalar values as opaque leaves, so
+// they are replaced as a whole instead of merged field by field.
+type scalarTransformer struct{}
+
+func (scalarTransformer) Transformer(t reflect.Type) func(dst, src reflect.Value) error {
+	if t != reflect.TypeOf(yaml.Scalar{}) {
+		return nil
+	}
+	return func(dst, src reflect.Value) error {
+		if dst.CanSet() && src.Type() == t {
+			dst.Set(src)
+		}
+		return nil
+	}
+}
+
 // +k8s:deepcopy-gen=false
 type MergeOption func(*mergeConfig)
 
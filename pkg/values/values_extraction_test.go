@@ -1,13 +1,13 @@
 package values
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"sort"
 	"testing"
 
 	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
-	"github.com/psanford/memfs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -529,8 +529,7 @@ func TestExtractCommonValidation(t *testing.T) {
 func TestExtractCommonWithMemFS(t *testing.T) {
 	t.Parallel()
 
-	mfs := memfs.New()
-	ops := memfsOps{fsys: mfs}
+	mfs := NewMemFS()
 
 	// Setup test structure
 	writeMemFile(t, mfs, "apps/svc-a/values.yaml", []byte(`global:
@@ -550,7 +549,7 @@ service:
 	commonPath, err := ExtractCommon(
 		"apps/svc-a/values.yaml",
 		"apps/svc-b/values.yaml",
-		WithFileOps(ops),
+		WithFileSystem(mfs),
 	)
 
 	require.NoError(t, err)
@@ -691,3 +690,303 @@ func TestExtractCommonPermissions(t *testing.T) {
 		assertYAMLEqual(t, []byte("shared: value\nunique2: val2"), mustReadFile(t, p2))
 	})
 }
+
+// TestExtractCommonPreserveComments tests that comments survive file-based extraction
+func TestExtractCommonPreserveComments(t *testing.T) {
+	t.Parallel()
+
+	y1 := []byte(`# cluster: eu-1
+global:
+  # do not change, see OPS-42
+  company: acme
+service:
+  port: 8080 # exposed via ingress
+`)
+	y2 := []byte(`global:
+  company: acme
+service:
+  port: 8081
+`)
+
+	_, dirs := setupTempDirs(t, "a/b/x", "a/b/y")
+	paths := setupValuesFiles(t, dirs, [][]byte{y1, y2})
+
+	commonPath, err := ExtractCommonN(paths, WithPreserveComments(true))
+	require.NoError(t, err)
+
+	common := mustReadFile(t, commonPath)
+	updated1 := mustReadFile(t, paths[0])
+	assert.Contains(t, string(common), "# do not change, see OPS-42")
+	assert.Contains(t, string(updated1), "# exposed via ingress")
+
+	validateMergeProperty(t, y1, common, updated1)
+	validateMergeProperty(t, y2, common, mustReadFile(t, paths[1]))
+}
+
+// TestExtractCommonMajorityDefaults tests hoisting the most common value with per-file overrides
+func TestExtractCommonMajorityDefaults(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("replicas: 3\nregion: eu-1"),
+		[]byte("replicas: 3\nregion: eu-2"),
+		[]byte("replicas: 3\nregion: us-1"),
+		[]byte("replicas: 5\nregion: us-2"),
+	}
+	dirs := []string{"clusters/a", "clusters/b", "clusters/c", "clusters/d"}
+	_, fullDirs := setupTempDirs(t, dirs...)
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	commonPath, err := ExtractCommonN(paths, WithMajorityDefaults(0.75))
+	require.NoError(t, err)
+
+	common := mustReadFile(t, commonPath)
+	assertYAMLEqual(t, []byte("replicas: 3"), common)
+	assertYAMLEqual(t, []byte("region: eu-1"), mustReadFile(t, paths[0]))
+	assertYAMLEqual(t, []byte("replicas: 5\nregion: us-2"), mustReadFile(t, paths[3]))
+	for i, p := range paths {
+		validateMergeProperty(t, inputs[i], common, mustReadFile(t, p))
+	}
+}
+
+// TestExtractCommonNullTombstones tests hoisting keys missing from some siblings
+func TestExtractCommonNullTombstones(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("monitoring:\n  enabled: true\nregion: eu-1"),
+		[]byte("monitoring:\n  enabled: true\nregion: eu-2"),
+		[]byte("region: us-1"),
+	}
+	_, fullDirs := setupTempDirs(t, "clusters/a", "clusters/b", "clusters/c")
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	commonPath, err := ExtractCommonN(paths, WithNullTombstones(0.6))
+	require.NoError(t, err)
+
+	common := mustReadFile(t, commonPath)
+	assertYAMLEqual(t, []byte("monitoring:\n  enabled: true"), common)
+	assertYAMLEqual(t, []byte("region: eu-1"), mustReadFile(t, paths[0]))
+	assertYAMLEqual(t, []byte("monitoring: null\nregion: us-1"), mustReadFile(t, paths[2]))
+
+	updated := make([][]byte, len(paths))
+	for i, p := range paths {
+		updated[i] = mustReadFile(t, p)
+	}
+	require.NoError(t, yamllib.VerifyMergeProperty(inputs, common, updated))
+}
+
+// TestExtractCommonStreams tests extraction on values files holding several documents
+func TestExtractCommonStreams(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("name: api\nreplicas: 2\nregion: eu\n---\nname: web\nport: 80\n"),
+		[]byte("name: web\nport: 80\n---\nname: api\nreplicas: 2\nregion: us\n"),
+	}
+	_, fullDirs := setupTempDirs(t, "envs/a", "envs/b")
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	commonPath, err := ExtractCommon(paths[0], paths[1], WithStreamMatchKey("name"))
+	require.NoError(t, err)
+
+	equal, err := yamllib.EqualYAMLStreams([]byte("name: api\nreplicas: 2\n---\nname: web\nport: 80\n"), mustReadFile(t, commonPath))
+	require.NoError(t, err)
+	assert.True(t, equal)
+
+	equal, err = yamllib.EqualYAMLStreams([]byte("name: web\n---\nname: api\nregion: us\n"), mustReadFile(t, paths[1]))
+	require.NoError(t, err)
+	assert.True(t, equal)
+}
+
+func TestExtractCommonFidelity(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("replicas: 1.0\nport: \"8080\"\nid: 12345678901234567890\nenabled: on\n"),
+		[]byte("replicas: 1\nport: \"8080\"\nid: 12345678901234567890\nenabled: on\n"),
+	}
+	dirs := []string{"apps/a", "apps/b"}
+	_, fullDirs := setupTempDirs(t, dirs...)
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	commonPath, err := ExtractCommon(paths[0], paths[1], WithFidelity(true))
+	require.NoError(t, err)
+
+	assert.Equal(t, "enabled: on\nid: 12345678901234567890\nport: \"8080\"\n", string(mustReadFile(t, commonPath)))
+	assert.Equal(t, "replicas: 1.0\n", string(mustReadFile(t, paths[0])))
+	assert.Equal(t, "replicas: 1\n", string(mustReadFile(t, paths[1])))
+}
+
+func TestExtractCommonRecursiveReport(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("image:\n  repository: nginx\n  tag: \"1.2\"\nreplicas: 3\n"),
+		[]byte("image:\n  repository: nginx\n  tag: \"1.3\"\nreplicas: 3\n"),
+	}
+	dirs := []string{"apps/a", "apps/b"}
+	root, fullDirs := setupTempDirs(t, dirs...)
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	var report Report
+	created, err := ExtractCommonRecursive(root, WithReport(&report))
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+
+	commonPath := created[0]
+	assert.Equal(t, []ReportEntry{
+		{Path: "image.repository", Sources: paths, Destination: commonPath, RemovedFrom: paths},
+		{Path: "replicas", Sources: paths, Destination: commonPath, RemovedFrom: paths},
+	}, report.Entries)
+
+	var before, after int
+	for i, p := range paths {
+		before += len(inputs[i])
+		after += len(mustReadFile(t, p))
+	}
+	after += len(mustReadFile(t, commonPath))
+	assert.Len(t, report.Files, 3)
+	assert.Equal(t, before-after, report.BytesSaved())
+	assert.Equal(t, 1, report.LinesSaved())
+
+	js, err := report.JSON()
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(js, &decoded))
+	assert.Equal(t, float64(report.BytesSaved()), decoded["bytesSaved"])
+	assert.Len(t, decoded["entries"], 2)
+
+	md := report.Markdown()
+	assert.Contains(t, md, "| `image.repository` | `"+commonPath+"` |")
+	assert.Contains(t, md, "3 files written")
+}
+
+func TestExtractCommonRecursivePathRules(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("global:\n  clusterName: eu-1\n  domain: example.com\napi:\n  image:\n    tag: v1\n  replicas: 2"),
+		[]byte("global:\n  clusterName: eu-1\n  domain: example.com\napi:\n  image:\n    tag: v1\n  replicas: 2"),
+	}
+	dirs := []string{"clusters/a", "clusters/b"}
+	root, fullDirs := setupTempDirs(t, dirs...)
+	paths := setupValuesFiles(t, fullDirs, inputs)
+
+	created, err := ExtractCommonRecursive(root, WithExcludePaths("global.clusterName", "*.image.tag"))
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+
+	common := mustReadFile(t, created[0])
+	assertYAMLEqual(t, []byte("global:\n  domain: example.com\napi:\n  replicas: 2"), common)
+	for i, p := range paths {
+		rem := mustReadFile(t, p)
+		assertYAMLEqual(t, []byte("global:\n  clusterName: eu-1\napi:\n  image:\n    tag: v1"), rem)
+		validateMergeProperty(t, inputs[i], common, rem)
+	}
+
+	// Restricting to a subtree leaves the rest untouched.
+	_, fullDirs = setupTempDirs(t, dirs...)
+	paths = setupValuesFiles(t, fullDirs, inputs)
+	commonPath, err := ExtractCommonN(paths, WithOnlyPaths("global.**"))
+	require.NoError(t, err)
+	assertYAMLEqual(t, []byte("global:\n  clusterName: eu-1\n  domain: example.com"), mustReadFile(t, commonPath))
+}
+
+func TestExtractCommonIntoExistingParent(t *testing.T) {
+	t.Parallel()
+
+	parentY := []byte("# Region-wide defaults\nregion: eu\nreplicas: 1\nimage:\n  registry: docker.io\n")
+	inputs := [][]byte{
+		[]byte("replicas: 3\nimage:\n  registry: docker.io\n  tag: v1\nport: 80\n"),
+		[]byte("replicas: 3\nimage:\n  registry: docker.io\n  tag: v1\nport: 81\n"),
+	}
+	root, fullDirs := setupTempDirs(t, "a", "b")
+	paths := setupValuesFiles(t, fullDirs, inputs)
+	mustWriteFile(t, filepath.Join(root, "values.yaml"), parentY)
+
+	commonPath, err := ExtractCommonN(paths)
+	require.NoError(t, err)
+	common := mustReadFile(t, commonPath)
+	assertYAMLEqual(t, []byte("region: eu\nreplicas: 1\nimage:\n  registry: docker.io\n  tag: v1\n"), common)
+	assert.Contains(t, string(common), "# Region-wide defaults")
+
+	// replicas conflicts with the parent, so it stays in the children
+	assertYAMLEqual(t, []byte("replicas: 3\nport: 80\n"), mustReadFile(t, paths[0]))
+	for i, p := range paths {
+		effective, err := yamllib.MergeYAML(parentY, inputs[i])
+		require.NoError(t, err)
+		validateMergeProperty(t, effective, common, mustReadFile(t, p))
+	}
+
+	// Nothing left to hoist
+	_, err = ExtractCommonN(paths)
+	assert.ErrorIs(t, err, ErrNoCommon)
+}
+
+func TestExtractCommonIntoCommentOnlyParent(t *testing.T) {
+	t.Parallel()
+
+	for _, parentY := range []string{
+		"# Region-wide defaults, see the runbook\n",
+		"# Region-wide defaults, see the runbook\n---\n",
+		"",
+	} {
+		inputs := [][]byte{[]byte("image: nginx\nport: 80\n"), []byte("image: nginx\nport: 81\n")}
+		root, fullDirs := setupTempDirs(t, "a", "b")
+		paths := setupValuesFiles(t, fullDirs, inputs)
+		mustWriteFile(t, filepath.Join(root, "values.yaml"), []byte(parentY))
+
+		commonPath, err := ExtractCommonN(paths)
+		require.NoError(t, err, parentY)
+		common := mustReadFile(t, commonPath)
+		assertYAMLEqual(t, []byte("image: nginx\n"), common)
+		if parentY != "" {
+			assert.Contains(t, string(common), "# Region-wide defaults, see the runbook", parentY)
+		}
+	}
+}
+
+func TestExtractCommonRecursiveIntoExistingParent(t *testing.T) {
+	t.Parallel()
+
+	files := map[string][]byte{
+		"values.yaml":          []byte("env: prod\nlogLevel: info\n"),
+		"eu/values.yaml":       []byte("region: eu\n"),
+		"eu/api/values.yaml":   []byte("logLevel: debug\nimage: api\nteam: core\n"),
+		"eu/web/values.yaml":   []byte("logLevel: debug\nimage: web\nteam: core\n"),
+		"us/api/values.yaml":   []byte("logLevel: debug\nteam: core\n"),
+		"us/batch/values.yaml": []byte("logLevel: debug\nteam: core\n"),
+	}
+	root := t.TempDir()
+	for rel, b := range files {
+		mustWriteFile(t, filepath.Join(root, rel), b)
+	}
+	effective := func(t *testing.T, leaf string) []byte {
+		merged := []byte("{}")
+		for _, rel := range []string{"values.yaml", filepath.Join(filepath.Dir(filepath.Dir(leaf)), "values.yaml"), leaf} {
+			b, err := os.ReadFile(filepath.Join(root, rel))
+			if os.IsNotExist(err) {
+				continue
+			}
+			require.NoError(t, err)
+			merged, err = yamllib.MergeYAML(merged, b)
+			require.NoError(t, err)
+		}
+		return merged
+	}
+	leaves := []string{"eu/api/values.yaml", "eu/web/values.yaml", "us/api/values.yaml", "us/batch/values.yaml"}
+	before := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		before[i] = effective(t, leaf)
+	}
+
+	_, err := ExtractCommonRecursive(root)
+	require.NoError(t, err)
+
+	assertYAMLEqual(t, []byte("env: prod\nlogLevel: info\nteam: core\n"), mustReadFile(t, filepath.Join(root, "values.yaml")))
+	assertYAMLEqual(t, []byte("region: eu\nlogLevel: debug\n"), mustReadFile(t, filepath.Join(root, "eu/values.yaml")))
+	for i, leaf := range leaves {
+		assertYAMLEqual(t, before[i], effective(t, leaf))
+	}
+}
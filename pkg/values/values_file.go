@@ -1,12 +1,13 @@
 package values
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/fs"
-	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 
 	yamllib "github.com/inercia/go-values-yaml/pkg/yaml"
 	syaml "sigs.k8s.io/yaml"
@@ -23,26 +24,94 @@ type Options struct {
 	// values files should be extracted into the common file. Default true.
 	IncludeEqualListsInCommon bool
 
-	// fs provides filesystem operations; defaults to the OS filesystem.
-	fs fileOps
-}
+	// PreserveComments renders the common and remainder files from the original
+	// YAML nodes, keeping comments and key order. Default false.
+	PreserveComments bool
 
-// fileOps abstracts the minimal filesystem operations needed by this package.
-type fileOps interface {
-	Stat(name string) (fs.FileInfo, error)
-	ReadFile(name string) ([]byte, error)
-	WriteFileAtomic(path string, data []byte, perm fs.FileMode) error
-	WalkDir(root string, fn fs.WalkDirFunc) error
-}
+	// MajorityMinShare enables majority-value hoisting when greater than zero.
+	// See yaml.Options for details. Default 0.
+	MajorityMinShare float64
+
+	// TombstoneMinShare enables null-tombstone extraction when greater than zero.
+	// See yaml.Options for details. Default 0.
+	TombstoneMinShare float64
+
+	// StreamMatchKey is the dotted key path used to pair the documents of
+	// multi-document values files. Default "" (pair by position).
+	StreamMatchKey string
+
+	// OutputDir is the directory InlineRecursive writes to. Default "" (rewrite
+	// the tree in place).
+	OutputDir string
+
+	// ValuesFile is the name, or glob, of the values files. Default
+	// "values.yaml".
+	ValuesFile string
+
+	// CommonFile is the path, relative to the parent directory, the common
+	// values are written to. Default "" (the values file name, or "values.yaml"
+	// when it is a glob).
+	CommonFile string
+
+	// ChartScopes only hoists values scoped to the same Helm chart in every
+	// file. See WithChartScopes. Default false.
+	ChartScopes bool
+
+	// Fidelity keeps every scalar with its original tag, lexical form and
+	// quoting style, and only treats scalars written the same way as equal.
+	// See yaml.Options for details. Default false.
+	Fidelity bool
+
+	// CompareDecodedScalars makes the fidelity mode compare scalars by their
+	// decoded value. Default false.
+	CompareDecodedScalars bool
+
+	// ExcludePaths are key path patterns (such as "*.image.tag") for values
+	// that always stay in the original files. See yaml.WithExcludePaths.
+	ExcludePaths []string
+
+	// OnlyPaths are key path patterns (such as "global.**") restricting the
+	// values that can be extracted. See yaml.WithOnlyPaths.
+	OnlyPaths []string
 
-type osFileOps struct{}
+	// PreserveFileFormat makes the files rewritten keep their line endings,
+	// trailing newline and byte order mark. Default false.
+	PreserveFileFormat bool
 
-func (osFileOps) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
-func (osFileOps) ReadFile(name string) ([]byte, error)  { return os.ReadFile(filepath.Clean(name)) }
-func (osFileOps) WriteFileAtomic(path string, data []byte, perm fs.FileMode) error {
-	return writeFileAtomic(path, data, perm)
+	// MaxDepth limits the directories the recursive functions walk to that
+	// many levels below root. Default 0, no limit.
+	MaxDepth int
+
+	// Symlinks is how the recursive functions treat symbolic links. Default
+	// SymlinksKeep.
+	Symlinks SymlinkPolicy
+
+	// SkipChartDirs skips the directories holding a Chart.yaml below root.
+	// Default false.
+	SkipChartDirs bool
+
+	// LockValues makes NewLock record the effective values of the leaves
+	// along with their hash. Default false.
+	LockValues bool
+
+	// Concurrency is the number of directories ExtractCommonRecursive
+	// processes at the same time. Default 1.
+	Concurrency int
+
+	// fs provides filesystem operations; defaults to the OS filesystem. See
+	// WithFileSystem.
+	fs FileSystem
+
+	// report, when set, collects what every extraction moves. See WithReport.
+	report *Report
+
+	// plan, when set, records the changes instead of writing them. See
+	// WithDryRun.
+	plan *Plan
+
+	// progress, when set, receives the progress events. See WithProgress.
+	progress func(ProgressEvent)
 }
-func (osFileOps) WalkDir(root string, fn fs.WalkDirFunc) error { return filepath.WalkDir(root, fn) }
 
 // Option is a functional option for file-based extraction.
 type Option func(*Options)
@@ -52,13 +121,166 @@ func WithIncludeEqualListsInCommon(include bool) Option {
 	return func(o *Options) { o.IncludeEqualListsInCommon = include }
 }
 
-// WithFileOps allows injecting custom filesystem operations (e.g., memfs for tests).
-func WithFileOps(fops fileOps) Option {
-	return func(o *Options) { o.fs = fops }
+// WithPreserveComments keeps comments and key order in the rewritten files.
+func WithPreserveComments(preserve bool) Option {
+	return func(o *Options) { o.PreserveComments = preserve }
+}
+
+// WithMajorityDefaults hoists the value shared by at least minShare of the
+// files into the parent, keeping overrides only in the outliers.
+func WithMajorityDefaults(minShare float64) Option {
+	return func(o *Options) { o.MajorityMinShare = minShare }
+}
+
+// WithNullTombstones hoists keys present in at least minShare of the files and
+// writes `key: null` tombstones into the files that lacked them.
+func WithNullTombstones(minShare float64) Option {
+	return func(o *Options) { o.TombstoneMinShare = minShare }
+}
+
+// WithStreamMatchKey pairs the documents of multi-document values files by the
+// value found at the given dotted key path instead of by position.
+func WithStreamMatchKey(path string) Option {
+	return func(o *Options) { o.StreamMatchKey = path }
+}
+
+// WithFidelity keeps scalars exactly as written (`1.0`, `0755`, `on`, big
+// integers...) in the rewritten files.
+func WithFidelity(enabled bool) Option {
+	return func(o *Options) { o.Fidelity = enabled }
+}
+
+// WithCompareDecodedScalars treats scalars written differently but decoding to
+// the same value as equal in the fidelity mode.
+func WithCompareDecodedScalars(enabled bool) Option {
+	return func(o *Options) { o.CompareDecodedScalars = enabled }
+}
+
+// WithExcludePaths keeps the values matching any of the patterns in the
+// original files, even when every sibling shares them.
+func WithExcludePaths(patterns ...string) Option {
+	return func(o *Options) { o.ExcludePaths = append(o.ExcludePaths, patterns...) }
+}
+
+// WithOnlyPaths only extracts the values matching any of the patterns.
+func WithOnlyPaths(patterns ...string) Option {
+	return func(o *Options) { o.OnlyPaths = append(o.OnlyPaths, patterns...) }
+}
+
+// WithFileSystem makes the file-level functions read and write the files in
+// fsys instead of the OS filesystem. See FileSystem for the implementations
+// available.
+func WithFileSystem(fsys FileSystem) Option {
+	return func(o *Options) { o.fs = fsys }
+}
+
+// WithFileOps is the former name of WithFileSystem.
+//
+// Deprecated: use WithFileSystem.
+func WithFileOps(fops FileSystem) Option {
+	return WithFileSystem(fops)
 }
 
 func defaultOptions() Options {
-	return Options{IncludeEqualListsInCommon: true, fs: osFileOps{}}
+	return Options{IncludeEqualListsInCommon: true, ValuesFile: "values.yaml", fs: OSFS{}}
+}
+
+// yamlOptions returns the options forwarded to the YAML-level extractor.
+func (o Options) yamlOptions() []yamllib.Option {
+	return []yamllib.Option{
+		yamllib.WithIncludeEqualListsInCommon(o.IncludeEqualListsInCommon),
+		yamllib.WithMajorityDefaults(o.MajorityMinShare),
+		yamllib.WithNullTombstones(o.TombstoneMinShare),
+		yamllib.WithStreamMatchKey(o.StreamMatchKey),
+		yamllib.WithFidelity(o.Fidelity),
+		yamllib.WithCompareDecodedScalars(o.CompareDecodedScalars),
+		yamllib.WithExcludePaths(o.ExcludePaths...),
+		yamllib.WithOnlyPaths(o.OnlyPaths...),
+	}
+}
+
+// strict returns a copy of the options that only hoists values shared by every
+// document. It is used when the documents are nested in each other, where an
+// override kept in one file would shadow its descendants.
+func (o Options) strict() Options {
+	o.MajorityMinShare = 0
+	o.TombstoneMinShare = 0
+	return o
+}
+
+// extractCommonN runs the YAML-level extractor selected by the options. Files
+// holding several documents are handled as YAML streams.
+func (o Options) extractCommonN(yams [][]byte) ([]byte, [][]byte, error) {
+	stream := false
+	for _, y := range yams {
+		stream = stream || yamllib.IsStream(y)
+	}
+	switch {
+	case stream && o.PreserveComments:
+		return yamllib.ExtractCommonNodesStreams(yams, o.yamlOptions()...)
+	case stream:
+		return yamllib.ExtractCommonStreams(yams, o.yamlOptions()...)
+	case o.PreserveComments:
+		return yamllib.ExtractCommonNodesN(yams, o.yamlOptions()...)
+	default:
+		return yamllib.ExtractCommonN(yams, o.yamlOptions()...)
+	}
+}
+
+// intoExisting merges a common output into the values file already at
+// commonPath, so the values it gives to the files below it do not change. The
+// leaves of the common output conflicting with the existing content are
+// excluded and extract is run again, leaving them in the remainders. It returns
+// the content to write at commonPath, the common output actually hoisted and
+// the remainders, or ErrNoCommon if every leaf conflicts.
+func (o Options) intoExisting(commonPath string, commonY []byte, remainders [][]byte, extract func(Options) ([]byte, [][]byte, error)) ([]byte, []byte, [][]byte, error) {
+	existing, err := readIfExists(o.fs, commonPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if existing == nil {
+		return commonY, commonY, remainders, nil
+	}
+	for {
+		conflicts, err := yamllib.CommonConflicts(existing, commonY, o.yamlOptions()...)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", commonPath, err)
+		}
+		if len(conflicts) == 0 {
+			break
+		}
+		o.ExcludePaths = append(o.ExcludePaths[:len(o.ExcludePaths):len(o.ExcludePaths)], conflicts...)
+		if commonY, remainders, err = extract(o); err != nil {
+			return nil, nil, nil, err
+		}
+		if o.isEmptyCommon(commonY) {
+			return nil, nil, nil, ErrNoCommon
+		}
+	}
+	merged, err := yamllib.MergeInto(existing, commonY, o.yamlOptions()...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %w", commonPath, err)
+	}
+	return merged, commonY, remainders, nil
+}
+
+// isEmptyCommon reports whether a common output (possibly a stream) holds
+// nothing besides the keys used to pair documents.
+func (o Options) isEmptyCommon(b []byte) bool {
+	for _, doc := range yamllib.SplitDocuments(b) {
+		var v any
+		if err := syaml.Unmarshal(doc, &v); err != nil {
+			// On parse error treat as non-empty to avoid accidental no-ops
+			return false
+		}
+		if o.StreamMatchKey != "" {
+			v = withoutPath(v, strings.Split(o.StreamMatchKey, "."))
+		}
+		if !isEmpty(v) {
+			return false
+		}
+	}
+	return true
 }
 
 // ExtractCommon reads two values.yaml files and extracts their common structure into
@@ -66,19 +288,30 @@ func defaultOptions() Options {
 // rewritten to only contain their respective remainders (i.e., without the common part).
 //
 // Requirements and behavior:
-// - Both input paths must be named "values.yaml" and exist.
+// - Both input paths must be named "values.yaml" (see WithValuesFile) and exist.
 // - Both must be at the same depth and share the same parent directory (i.e., siblings).
-// - The common file is written at the shared parent directory as "values.yaml".
+// - The common file is written at the shared parent directory as "values.yaml" (see WithCommonFile).
 // - If no common structure exists, this function returns ErrNoCommon and leaves files unchanged.
 // - The merge property holds: merge(updated, common) reconstructs each original.
+//
+// If the parent values.yaml already exists, the common structure is merged into
+// it: its content and comments are kept, and the values conflicting with it stay
+// in the original files, so the effective values of both files do not change.
 func ExtractCommon(path1, path2 string, opts ...Option) (commonPath string, err error) {
 	options := defaultOptions()
 	for _, opt := range opts {
 		opt(&options)
 	}
+	if err := options.checkFiles(); err != nil {
+		return "", err
+	}
+	options, commit, err := options.begin(filepath.Dir(options.ownerDir(path1)))
+	if err != nil {
+		return "", err
+	}
 
-	if filepath.Base(path1) != "values.yaml" || filepath.Base(path2) != "values.yaml" {
-		return "", fmt.Errorf("both files must be named values.yaml: got %q and %q", filepath.Base(path1), filepath.Base(path2))
+	if !options.isValuesFile(path1) || !options.isValuesFile(path2) {
+		return "", fmt.Errorf("both files must be named %s: got %q and %q", options.ValuesFile, filepath.Base(path1), filepath.Base(path2))
 	}
 	if err := assertFileExists(options.fs, path1); err != nil {
 		return "", err
@@ -87,8 +320,8 @@ func ExtractCommon(path1, path2 string, opts ...Option) (commonPath string, err
 		return "", err
 	}
 
-	dir1 := filepath.Dir(path1)
-	dir2 := filepath.Dir(path2)
+	dir1 := options.ownerDir(path1)
+	dir2 := options.ownerDir(path2)
 	p1 := filepath.Dir(dir1)
 	p2 := filepath.Dir(dir2)
 	if p1 != p2 {
@@ -104,27 +337,51 @@ func ExtractCommon(path1, path2 string, opts ...Option) (commonPath string, err
 	if err != nil {
 		return "", err
 	}
+	if options, err = options.chartScoped([]string{path1, path2}, [][]byte{y1, y2}); err != nil {
+		return "", err
+	}
 
 	// Compute common and remainders using pkg/yaml
-	commonY, u1Y, u2Y, err := yamllib.ExtractCommon(y1, y2, yamllib.WithIncludeEqualListsInCommon(options.IncludeEqualListsInCommon))
+	extract := func(o Options) ([]byte, [][]byte, error) {
+		if yamllib.IsStream(y1) || yamllib.IsStream(y2) {
+			return o.extractCommonN([][]byte{y1, y2})
+		}
+		extractPair := yamllib.ExtractCommon
+		if o.PreserveComments {
+			extractPair = yamllib.ExtractCommonNodes
+		}
+		c, u1, u2, err := extractPair(y1, y2, o.yamlOptions()...)
+		return c, [][]byte{u1, u2}, err
+	}
+	commonY, remainders, err := extract(options)
 	if err != nil {
 		return "", err
 	}
 
 	// If common is empty ({}), do nothing
-	if isEmptyYAML(commonY) {
+	if options.isEmptyCommon(commonY) {
 		return "", ErrNoCommon
 	}
 
-	// Write common and updated files atomically
-	commonPath = filepath.Join(p1, "values.yaml")
-	if err := options.fs.WriteFileAtomic(commonPath, commonY, 0o644); err != nil {
+	// Merge with an existing parent file, then write common and updated files
+	commonPath = options.commonPath(p1)
+	parentY, commonY, remainders, err := options.intoExisting(commonPath, commonY, remainders, extract)
+	if err != nil {
 		return "", err
 	}
-	if err := options.fs.WriteFileAtomic(path1, u1Y, 0o644); err != nil {
+	if err := options.record([]string{path1, path2}, [][]byte{y1, y2}, commonPath, commonY, parentY, remainders); err != nil {
 		return "", err
 	}
-	if err := options.fs.WriteFileAtomic(path2, u2Y, 0o644); err != nil {
+	if err := options.fs.WriteFileAtomic(commonPath, parentY, 0o644); err != nil {
+		return "", err
+	}
+	if err := options.fs.WriteFileAtomic(path1, remainders[0], 0o644); err != nil {
+		return "", err
+	}
+	if err := options.fs.WriteFileAtomic(path2, remainders[1], 0o644); err != nil {
+		return "", err
+	}
+	if err := commit(); err != nil {
 		return "", err
 	}
 
@@ -133,7 +390,8 @@ func ExtractCommon(path1, path2 string, opts ...Option) (commonPath string, err
 
 // ExtractCommonN performs the same operation as ExtractCommon but for N sibling
 // values.yaml files. It writes the common structure to the shared parent directory
-// as values.yaml and updates each provided file with its remainder.
+// as values.yaml, merging it into the existing file if there is one, and updates
+// each provided file with its remainder.
 // Returns the path to the common file or ErrNoCommon if there is no common content.
 func ExtractCommonN(paths []string, opts ...Option) (commonPath string, err error) {
 	options := defaultOptions()
@@ -143,16 +401,23 @@ func ExtractCommonN(paths []string, opts ...Option) (commonPath string, err erro
 	if len(paths) < 2 {
 		return "", fmt.Errorf("need at least 2 files, got %d", len(paths))
 	}
+	if err := options.checkFiles(); err != nil {
+		return "", err
+	}
+	options, commit, err := options.begin(filepath.Dir(options.ownerDir(paths[0])))
+	if err != nil {
+		return "", err
+	}
 	// Validate names and gather parent
 	parents := make(map[string]struct{})
 	for _, p := range paths {
-		if filepath.Base(p) != "values.yaml" {
-			return "", fmt.Errorf("file must be named values.yaml: %s", p)
+		if !options.isValuesFile(p) {
+			return "", fmt.Errorf("file must be named %s: %s", options.ValuesFile, p)
 		}
 		if err := assertFileExists(options.fs, p); err != nil {
 			return "", err
 		}
-		parents[filepath.Dir(filepath.Dir(p))] = struct{}{}
+		parents[filepath.Dir(options.ownerDir(p))] = struct{}{}
 	}
 	if len(parents) != 1 {
 		return "", fmt.Errorf("all files must share the same parent directory one level up")
@@ -171,19 +436,30 @@ func ExtractCommonN(paths []string, opts ...Option) (commonPath string, err erro
 		}
 		yams[i] = b
 	}
+	if options, err = options.chartScoped(paths, yams); err != nil {
+		return "", err
+	}
 
 	// Compute common and remainders
-	commonY, remainders, err := yamllib.ExtractCommonN(yams, yamllib.WithIncludeEqualListsInCommon(options.IncludeEqualListsInCommon))
+	commonY, remainders, err := options.extractCommonN(yams)
 	if err != nil {
 		return "", err
 	}
-	if isEmptyYAML(commonY) {
+	if options.isEmptyCommon(commonY) {
 		return "", ErrNoCommon
 	}
 
-	// Write outputs
-	commonPath = filepath.Join(parent, "values.yaml")
-	if err := options.fs.WriteFileAtomic(commonPath, commonY, 0o644); err != nil {
+	// Merge with an existing parent file, then write outputs
+	commonPath = options.commonPath(parent)
+	extract := func(o Options) ([]byte, [][]byte, error) { return o.extractCommonN(yams) }
+	parentY, commonY, remainders, err := options.intoExisting(commonPath, commonY, remainders, extract)
+	if err != nil {
+		return "", err
+	}
+	if err := options.record(paths, yams, commonPath, commonY, parentY, remainders); err != nil {
+		return "", err
+	}
+	if err := options.fs.WriteFileAtomic(commonPath, parentY, 0o644); err != nil {
 		return "", err
 	}
 	for i, p := range paths {
@@ -191,6 +467,9 @@ func ExtractCommonN(paths []string, opts ...Option) (commonPath string, err erro
 			return "", err
 		}
 	}
+	if err := commit(); err != nil {
+		return "", err
+	}
 	return commonPath, nil
 }
 
@@ -203,22 +482,51 @@ func ExtractCommonN(paths []string, opts ...Option) (commonPath string, err erro
 //   - For each parent directory, collect its direct child directories that currently
 //     contain a values.yaml (including ones created in prior passes).
 //   - If two or more child values.yaml files exist, run ExtractCommonN on them to
-//     produce/overwrite the parent values.yaml and update children with remainders.
+//     produce the parent values.yaml (or merge into it) and update children with
+//     remainders.
 //   - Newly created parent values.yaml files make that parent eligible in the next pass
 //     to be grouped with its own siblings at a higher level.
 //   - If a parent has fewer than two direct child values.yaml but has two or more
 //     descendant values.yaml anywhere in its subtree, compute the common across all
 //     those descendants and write it at the parent, subtracting it from all descendants.
+//     When those descendants are nested in each other, only values shared by all of
+//     them are hoisted (majority defaults and null tombstones are not applied).
 //
 // - Stops when a full pass creates no new parent values.yaml files.
 //
+// The values files looked for, and the common files written, follow
+// WithValuesFile and WithCommonFile, and directories holding a values file per
+// environment form a tree per environment. A directory holding a common file
+// is represented by it in the upper levels. The files and directories listed
+// in .valuesignore files are skipped, and so are the ones excluded with
+// WithMaxDepth, WithSymlinks and WithSkipChartDirs.
+//
 // Returns the sorted list of parent values.yaml paths that were created during the run.
+// Use WithReport to find out which values were moved where.
 func ExtractCommonRecursive(root string, opts ...Option) ([]string, error) {
+	return ExtractCommonRecursiveContext(context.Background(), root, opts...)
+}
+
+// ExtractCommonRecursiveContext is ExtractCommonRecursive with a context. The
+// directories of the same depth are independent of each other, and are
+// processed by up to WithConcurrency workers at the same time, while the
+// levels are still processed bottom-up. Use WithProgress to follow the run.
+//
+// As all the files are written together at the end, a cancellation before
+// then stops the run without touching any file, and returns the error of ctx.
+func ExtractCommonRecursiveContext(ctx context.Context, root string, opts ...Option) ([]string, error) {
 	// Build options with default FS
 	options := defaultOptions()
 	for _, opt := range opts {
 		opt(&options)
 	}
+	if err := options.checkFiles(); err != nil {
+		return nil, err
+	}
+	options, commit, err := options.begin(root)
+	if err != nil {
+		return nil, err
+	}
 
 	// Validate root
 	st, err := options.fs.Stat(root)
@@ -229,98 +537,118 @@ func ExtractCommonRecursive(root string, opts ...Option) ([]string, error) {
 		return nil, fmt.Errorf("root is not a directory: %s", root)
 	}
 
-	// Discover directories and parent->children relationships
-	dirs := make(map[string]struct{})
-	parentToChildren := make(map[string][]string)
-	if err := options.fs.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-		if !d.IsDir() {
-			return nil
+	// Directories with several values files, one per environment, are
+	// processed once per file name, all in the same plan
+	names, err := options.valuesFileNames(root)
+	if err != nil {
+		return nil, err
+	}
+	if names != nil {
+		var created []string
+		for _, name := range names {
+			nameOpts := append(opts[:len(opts):len(opts)], WithValuesFile(name), WithCommonFile(""))
+			paths, err := ExtractCommonRecursiveContext(ctx, root, options.nested(nameOpts)...)
+			if err != nil {
+				return nil, err
+			}
+			created = append(created, paths...)
 		}
-		dirs[path] = struct{}{}
-		if path != root {
-			parent := filepath.Dir(path)
-			parentToChildren[parent] = append(parentToChildren[parent], path)
+		if err := commit(); err != nil {
+			return nil, err
 		}
-		return nil
-	}); err != nil {
-		return nil, err
+		sort.Strings(created)
+		return created, nil
 	}
 
-	// Track which directories currently have a values.yaml file
-	hasValues := make(map[string]bool)
-	for dir := range dirs {
-		if fi, err := options.fs.Stat(filepath.Join(dir, "values.yaml")); err == nil && !fi.IsDir() {
-			hasValues[dir] = true
-		}
+	// Discover directories, parent->children relationships and the values file
+	// each directory currently has
+	tree, err := options.walkValuesTree(root)
+	if err != nil {
+		return nil, err
 	}
+	parentToChildren := tree.children
+	valuesFiles := tree.files
 
-	// Prepare parents ordered by depth (deepest first)
-	parents := make([]string, 0, len(parentToChildren))
+	// Group parents by depth (deepest first): the parents of a level have
+	// disjoint subtrees and can be processed concurrently
+	byDepth := make(map[int][]string)
 	for p := range parentToChildren {
-		parents = append(parents, p)
+		byDepth[pathDepth(p)] = append(byDepth[pathDepth(p)], p)
 	}
-	sort.Slice(parents, func(i, j int) bool {
-		return pathDepth(parents[i]) > pathDepth(parents[j])
-	})
+	depths := make([]int, 0, len(byDepth))
+	for d, level := range byDepth {
+		sort.Strings(level)
+		depths = append(depths, d)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(depths)))
 
-	// Iteratively extract upwards
+	// mu guards valuesFiles, createdSet and createdInPass
+	var mu sync.Mutex
 	createdSet := make(map[string]struct{})
-	for {
-		createdInPass := 0
-		for _, parent := range parents {
-			children := parentToChildren[parent]
-			if len(children) == 0 {
-				continue
-			}
-			paths := make([]string, 0, len(children))
-			for _, child := range children {
-				if hasValues[child] {
-					vp := filepath.Join(child, "values.yaml")
-					if fi, err := options.fs.Stat(vp); err == nil && !fi.IsDir() {
-						paths = append(paths, vp)
-					}
+	createdInPass := 0
+	markCreated := func(parent, commonPath string) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Mark parent as now having a values file (if not already)
+		if _, ok := valuesFiles[parent]; !ok {
+			createdInPass++
+		}
+		valuesFiles[parent] = commonPath
+		createdSet[commonPath] = struct{}{}
+	}
+	// existing returns the values files of dirs that exist
+	existing := func(dirs []string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		var paths []string
+		for _, dir := range dirs {
+			if vp, ok := valuesFiles[dir]; ok {
+				if fi, err := options.fs.Stat(vp); err == nil && !fi.IsDir() {
+					paths = append(paths, vp)
 				}
 			}
+		}
+		return paths
+	}
+
+	// Iteratively extract upwards
+	for pass := 1; ; pass++ {
+		createdInPass = 0
+		extractInto := func(parent string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			notify(options.progress, ProgressEvent{Kind: DirectoryStarted, Path: parent, Pass: pass})
+			children := parentToChildren[parent]
+			paths := existing(children)
 			if len(paths) >= 2 {
-				commonPath, err := ExtractCommonN(paths, opts...)
+				commonPath, err := ExtractCommonN(paths, options.nested(opts)...)
 				if err != nil {
 					if errors.Is(err, ErrNoCommon) {
-						continue
+						return nil
 					}
-					return nil, err
-				}
-				// Mark parent as now having a values file (if not already)
-				if !hasValues[parent] {
-					hasValues[parent] = true
-					createdInPass++
+					return err
 				}
-				createdSet[commonPath] = struct{}{}
-				continue
+				markCreated(parent, commonPath)
+				return nil
 			}
 
 			// Fallback: if fewer than two direct child values.yaml, check for two or more
 			// descendant values.yaml anywhere under this parent. If found, compute a common
 			// across all descendants and write it at the parent.
-			descendantValueFiles := make([]string, 0)
+			descendants := make([]string, 0)
 			stack := append([]string{}, children...)
 			for len(stack) > 0 {
 				cur := stack[len(stack)-1]
 				stack = stack[:len(stack)-1]
-				if hasValues[cur] {
-					vp := filepath.Join(cur, "values.yaml")
-					if fi, err := options.fs.Stat(vp); err == nil && !fi.IsDir() {
-						descendantValueFiles = append(descendantValueFiles, vp)
-					}
-				}
+				descendants = append(descendants, cur)
 				if kids, ok := parentToChildren[cur]; ok {
 					stack = append(stack, kids...)
 				}
 			}
+			descendantValueFiles := existing(descendants)
 			if len(descendantValueFiles) < 2 {
-				continue
+				return nil
 			}
 
 			// Read all descendant YAMLs
@@ -328,39 +656,67 @@ func ExtractCommonRecursive(root string, opts ...Option) ([]string, error) {
 			for i, p := range descendantValueFiles {
 				b, err := options.fs.ReadFile(p)
 				if err != nil {
-					return nil, err
+					return err
 				}
 				yams[i] = b
 			}
 
-			commonY, remainders, err := yamllib.ExtractCommonN(yams, yamllib.WithIncludeEqualListsInCommon(options.IncludeEqualListsInCommon))
+			extractOpts, err := options.chartScoped(descendantValueFiles, yams)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			if isEmptyYAML(commonY) {
-				continue
+			if options.hasNestedDirs(descendantValueFiles) {
+				extractOpts = extractOpts.strict()
+			}
+			commonY, remainders, err := extractOpts.extractCommonN(yams)
+			if err != nil {
+				return err
+			}
+			if options.isEmptyCommon(commonY) {
+				return nil
 			}
 
-			commonPath := filepath.Join(parent, "values.yaml")
-			if err := options.fs.WriteFileAtomic(commonPath, commonY, 0o644); err != nil {
-				return nil, err
+			commonPath := options.commonPath(parent)
+			extract := func(o Options) ([]byte, [][]byte, error) { return o.extractCommonN(yams) }
+			parentY, commonY, remainders, err := extractOpts.intoExisting(commonPath, commonY, remainders, extract)
+			if err != nil {
+				if errors.Is(err, ErrNoCommon) {
+					return nil
+				}
+				return err
+			}
+			if err := extractOpts.record(descendantValueFiles, yams, commonPath, commonY, parentY, remainders); err != nil {
+				return err
+			}
+			if err := options.fs.WriteFileAtomic(commonPath, parentY, 0o644); err != nil {
+				return err
 			}
 			for i, p := range descendantValueFiles {
 				if err := options.fs.WriteFileAtomic(p, remainders[i], 0o644); err != nil {
-					return nil, err
+					return err
 				}
 			}
-			if !hasValues[parent] {
-				hasValues[parent] = true
-				createdInPass++
+			markCreated(parent, commonPath)
+			return nil
+		}
+		for _, d := range depths {
+			if err := forEach(ctx, options.Concurrency, byDepth[d], extractInto); err != nil {
+				return nil, err
 			}
-			createdSet[commonPath] = struct{}{}
 		}
+		notify(options.progress, ProgressEvent{Kind: PassCompleted, Pass: pass})
 		if createdInPass == 0 {
 			break
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := commit(); err != nil {
+		return nil, err
+	}
+
 	// Collect and sort created paths
 	created := make([]string, 0, len(createdSet))
 	for p := range createdSet {
@@ -384,7 +740,21 @@ func pathDepth(p string) int {
 	return depth
 }
 
-func assertFileExists(ops fileOps, path string) error {
+// hasNestedDirs reports whether the directory of any of the given files is an
+// ancestor of the directory of another one.
+func (o Options) hasNestedDirs(files []string) bool {
+	for i, a := range files {
+		da := o.ownerDir(a) + string(filepath.Separator)
+		for j, b := range files {
+			if i != j && strings.HasPrefix(o.ownerDir(b), da) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func assertFileExists(ops FileSystem, path string) error {
 	st, err := ops.Stat(path)
 	if err != nil {
 		return err
@@ -395,13 +765,30 @@ func assertFileExists(ops fileOps, path string) error {
 	return nil
 }
 
-func isEmptyYAML(b []byte) bool {
-	var v any
-	if err := syaml.Unmarshal(b, &v); err != nil {
-		// On parse error treat as non-empty to avoid accidental no-ops
-		return false
+// withoutPath returns v without the entry at the given key path, dropping maps
+// left empty.
+func withoutPath(v any, keys []string) any {
+	m, ok := v.(map[string]any)
+	if !ok || len(keys) == 0 {
+		return v
+	}
+	out := make(map[string]any, len(m))
+	for k, vv := range m {
+		out[k] = vv
+	}
+	if len(keys) == 1 {
+		delete(out, keys[0])
+		return out
+	}
+	if sub, ok := out[keys[0]]; ok {
+		sub = withoutPath(sub, keys[1:])
+		if isEmpty(sub) {
+			delete(out, keys[0])
+		} else {
+			out[keys[0]] = sub
+		}
 	}
-	return isEmpty(v)
+	return out
 }
 
 func isEmpty(v any) bool {
@@ -417,32 +804,3 @@ func isEmpty(v any) bool {
 		return false
 	}
 }
-
-// writeFileAtomic writes data to a temp file in the same directory and renames it in place.
-func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
-	dir := filepath.Dir(path)
-	tmp, err := os.CreateTemp(dir, ".values-*.tmp")
-	if err != nil {
-		return err
-	}
-	name := tmp.Name()
-	defer func() {
-		_ = tmp.Close()
-		_ = os.Remove(name)
-	}()
-
-	if _, err := tmp.Write(data); err != nil {
-		return err
-	}
-	if err := tmp.Chmod(perm); err != nil {
-		return err
-	}
-	if err := tmp.Sync(); err != nil {
-		return err
-	}
-
-	if err := tmp.Close(); err != nil {
-		return err
-	}
-	return os.Rename(name, path)
-}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/inercia/go-values-yaml/pkg/yaml"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestValues_DeepCopyInto(t *testing.T) {
@@ -411,6 +412,24 @@ func TestValues_Rebase(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "index creates a list",
+			v:    Values{"config": "1234"},
+			base: "items[1]",
+			want: Values{"items": []interface{}{nil, Values{"config": "1234"}}},
+		},
+		{
+			name: "malformed base",
+			v:    Values{"config": "1234"},
+			base: "a[0.b",
+			want: Values{"a[0": Values{"b": Values{"config": "1234"}}},
+		},
+		{
+			name: "base starting with an index",
+			v:    Values{"config": "1234"},
+			base: "[0].a",
+			want: Values{"[0]": Values{"a": Values{"config": "1234"}}},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -824,36 +843,80 @@ func TestValues_SetEdgeCases(t *testing.T) {
 	}
 }
 
-// TestParseIndex tests the parseIndex helper function
-func TestParseIndex(t *testing.T) {
+// TestNewValuesListFromYAML tests loading multi-document YAML streams
+func TestNewValuesListFromYAML(t *testing.T) {
 	t.Parallel()
 
-	tests := []struct {
-		name      string
-		input     string
-		wantKey   string
-		wantIndex int
-		wantErr   bool
-	}{
-		{"no index", "key", "key", -1, false},
-		{"with index", "key[0]", "key", 0, false},
-		{"index 10", "key[10]", "key", 10, false},
-		{"missing close bracket", "key[0", "", -1, true},
-		{"missing open bracket", "key0]", "", -1, true},
-		{"invalid index", "key[abc]", "", -1, true},
-		{"negative index", "key[-1]", "", -1, true},
-	}
+	stream := []byte("release: api\nreplicas: 2\n---\n---\nrelease: web\n")
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			key, index, err := parseIndex(tt.input)
-			if tt.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-				assert.Equal(t, tt.wantKey, key)
-				assert.Equal(t, tt.wantIndex, index)
-			}
-		})
-	}
+	t.Run("one Values per document", func(t *testing.T) {
+		list, err := NewValuesListFromYAML(stream)
+		assert.NoError(t, err)
+		assert.Len(t, list, 3)
+		assert.Equal(t, "api", (*list[0])["release"])
+		assert.True(t, list[1].Empty())
+		assert.Equal(t, "web", (*list[2])["release"])
+
+		out, err := ValuesListToYAML(list)
+		assert.NoError(t, err)
+		equal, err := yaml.EqualYAMLStreams([]byte("release: api\nreplicas: 2\n---\n{}\n---\nrelease: web\n"), out)
+		assert.NoError(t, err)
+		assert.True(t, equal, string(out))
+	})
+
+	t.Run("single document constructor rejects streams", func(t *testing.T) {
+		_, err := NewValuesFromYAML(stream)
+		assert.ErrorIs(t, err, ErrMultipleDocuments)
+	})
+}
+
+func TestNewValuesFromYAMLWithFidelity(t *testing.T) {
+	t.Parallel()
+
+	in := []byte("big: 12345678901234567890\nenabled: on\nfloat: 1.0\nimage:\n  tag: \"1.10\"\nmode: 0755\n")
+
+	t.Run("round trip keeps scalars", func(t *testing.T) {
+		v, err := NewValuesFromYAMLWithFidelity(in)
+		require.NoError(t, err)
+		out, err := v.ToYAML()
+		require.NoError(t, err)
+		assert.Equal(t, string(in), string(out))
+
+		j, err := v.ToJSON()
+		require.NoError(t, err)
+		assert.Contains(t, string(j), `"big":12345678901234567890`)
+	})
+
+	t.Run("lookups", func(t *testing.T) {
+		v, err := NewValuesFromYAMLWithFidelity(in)
+		require.NoError(t, err)
+		s, err := v.LookupString("mode")
+		assert.NoError(t, err)
+		assert.Equal(t, "0755", s)
+		i, err := v.LookupInt("mode")
+		assert.NoError(t, err)
+		assert.Equal(t, 493, i)
+		tag, err := v.LookupString("image.tag")
+		assert.NoError(t, err)
+		assert.Equal(t, "1.10", tag)
+	})
+
+	t.Run("merge keeps scalars", func(t *testing.T) {
+		base, err := NewValuesFromYAMLWithFidelity(in)
+		require.NoError(t, err)
+		overlay, err := NewValuesFromYAMLWithFidelity([]byte("float: 2.50\nimage:\n  tag: '2.0'\n  pull: yes\nmode:\n  octal: false\n"))
+		require.NoError(t, err)
+		out, err := base.Merge(overlay).ToYAML()
+		require.NoError(t, err)
+		want := "big: 12345678901234567890\nenabled: on\nfloat: 2.50\nimage:\n  pull: yes\n  tag: '2.0'\nmode:\n  octal: false\n"
+		assert.Equal(t, want, string(out))
+	})
+
+	t.Run("default constructor normalizes scalars", func(t *testing.T) {
+		v, err := NewValuesFromYAML(in)
+		require.NoError(t, err)
+		out, err := v.ToYAML()
+		require.NoError(t, err)
+		assert.NotEqual(t, string(in), string(out))
+	})
 }
@@ -11,9 +11,13 @@ import (
 
 // EqualYAMLs compares two YAML documents by unmarshalling them and comparing the resulting objects.
 // Note well that this function does not take into account spaces and comments: it only
-// compares the contents.
+// compares the contents. Streams with more than one document are rejected with
+// ErrMultipleDocuments; use EqualYAMLStreams for them.
 func EqualYAMLs(a []byte, b []byte) (bool, error) {
 	var err error
+	if err := assertSingleDocument(a, b); err != nil {
+		return false, err
+	}
 
 	var aYAMLBytes []byte
 	if len(a) > 0 {
@@ -0,0 +1,267 @@
+package yaml
+
+import (
+	"bytes"
+	"fmt"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	syaml "sigs.k8s.io/yaml"
+)
+
+// CommonConflicts lists the leaves of a common output that cannot be merged
+// into existing, the content already in the file the common output is written
+// to, without changing what existing gives to the documents below it. These
+// are the leaves where existing holds a different value and, with
+// WithNullTombstones, every key existing already defines, as the documents
+// lacking it would get a tombstone deleting it.
+//
+// The result is a list of patterns (see WithExcludePaths) matching exactly
+// those leaves: extracting again with them excluded keeps the conflicting
+// values in the remainders. Documents of streams are paired by position or by
+// WithStreamMatchKey.
+func CommonConflicts(existing, common []byte, opts ...Option) ([]string, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	existingDocs := SplitDocuments(existing)
+	commonDocs := SplitDocuments(common)
+	pairs, err := options.pairDocuments(existingDocs, commonDocs)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	seen := make(map[string]struct{})
+	for i, j := range pairs {
+		if j < 0 {
+			continue
+		}
+		ev, err := options.unmarshal(existingDocs[j])
+		if err != nil {
+			return nil, fmt.Errorf("existing document %d: %w", j, err)
+		}
+		cv, err := options.unmarshal(commonDocs[i])
+		if err != nil {
+			return nil, fmt.Errorf("common document %d: %w", i, err)
+		}
+		if ev == nil || cv == nil {
+			continue
+		}
+		var found []string
+		options.conflictsAt(ev, cv, nil, &found)
+		for _, p := range found {
+			if _, dup := seen[p]; !dup {
+				seen[p] = struct{}{}
+				conflicts = append(conflicts, p)
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// conflictsAt appends to out the patterns of the leaves of c conflicting with e.
+func (o Options) conflictsAt(e, c any, prefix []string, out *[]string) {
+	em, eok := asStringMap(e)
+	cm, cok := asStringMap(c)
+	if !eok || !cok {
+		if !o.equal(e, c) {
+			if len(prefix) == 0 {
+				*out = append(*out, "**")
+			} else {
+				*out = append(*out, LiteralPattern(prefix...))
+			}
+		}
+		return
+	}
+	for _, k := range sortedKeys(cm) {
+		ev, ok := em[k]
+		if !ok {
+			continue
+		}
+		keys := append(prefix[:len(prefix):len(prefix)], k)
+		if o.TombstoneMinShare > 0 && !o.onMatchKey(keys) {
+			*out = append(*out, LiteralPattern(keys...))
+			continue
+		}
+		o.conflictsAt(ev, cm[k], keys, out)
+	}
+}
+
+// onMatchKey reports whether the key path leads to the stream match key, which
+// is kept in every document and never gets a tombstone.
+func (o Options) onMatchKey(keys []string) bool {
+	if o.StreamMatchKey == "" {
+		return false
+	}
+	match := splitPath(o.StreamMatchKey)
+	return len(keys) <= len(match) && matchPattern(match[:len(keys)], keys)
+}
+
+// MergeInto merges a common output into existing, the content already in the
+// file it is written to: maps are merged and anything else is replaced by the
+// common output. Comments, key order and scalars of existing are kept, and the
+// new keys are added after the existing ones. Documents of streams are paired
+// by position or by WithStreamMatchKey; common documents without a pair are
+// appended.
+//
+// Use CommonConflicts first to make sure the merge does not change values
+// existing already defines.
+func MergeInto(existing, common []byte, opts ...Option) ([]byte, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	existingDocs := SplitDocuments(existing)
+	commonDocs := SplitDocuments(common)
+	pairs, err := options.pairDocuments(existingDocs, commonDocs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, len(existingDocs), len(existingDocs)+len(commonDocs))
+	copy(out, existingDocs)
+	for i, j := range pairs {
+		if j < 0 {
+			out = append(out, commonDocs[i])
+			continue
+		}
+		if out[j], err = options.mergeDocumentInto(existingDocs[j], commonDocs[i]); err != nil {
+			return nil, fmt.Errorf("document %d: %w", j, err)
+		}
+	}
+	return JoinDocuments(out), nil
+}
+
+// mergeDocumentInto merges a single common document into an existing one. The
+// documents are merged node by node, unless existing uses merge keys, whose
+// keys cannot be updated in place.
+func (o Options) mergeDocumentInto(existing, common []byte) ([]byte, error) {
+	edoc, err := parseDocNode(existing)
+	if err != nil {
+		return nil, err
+	}
+	cdoc, err := parseDocNode(common)
+	if err != nil {
+		return nil, err
+	}
+	croot := docRoot(cdoc)
+	if croot == nil {
+		return existing, nil
+	}
+	eroot := docRoot(edoc)
+	if eroot == nil {
+		return mergeIntoEmpty(existing, edoc, croot)
+	}
+	if usesMergeKeys(eroot) {
+		return MergeYAML(existing, common, WithFidelity(o.Fidelity))
+	}
+	return encodeDocNode(edoc, mergeNodes(eroot, croot))
+}
+
+// mergeIntoEmpty renders the common root in place of an existing document
+// without content, keeping its comments. yaml.v3 drops the comments of a
+// document holding nothing else, so these are kept as written.
+func mergeIntoEmpty(existing []byte, edoc, croot *yamlv3.Node) ([]byte, error) {
+	if !hasContent(existing) {
+		common, err := encodeDocNode(&yamlv3.Node{Kind: yamlv3.DocumentNode}, croot)
+		if err != nil {
+			return nil, err
+		}
+		head := bytes.TrimRight(existing, " \t\r\n")
+		if len(head) == 0 {
+			return common, nil
+		}
+		return append(append(head, '\n'), common...), nil
+	}
+	root := copyNode(croot)
+	if len(edoc.Content) > 0 {
+		fillComments(root, edoc.Content[0])
+	}
+	return encodeDocNode(edoc, root)
+}
+
+// mergeNodes merges overlay into base: mappings are merged key by key, and any
+// other node is replaced.
+func mergeNodes(base, overlay *yamlv3.Node) *yamlv3.Node {
+	if base.Kind != yamlv3.MappingNode || overlay.Kind != yamlv3.MappingNode {
+		return overlay
+	}
+	out := copyNode(base)
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		k, v := overlay.Content[i], overlay.Content[i+1]
+		if j := mappingKeyIndex(out, k.Value); j >= 0 {
+			out.Content[j+1] = mergeNodes(out.Content[j+1], v)
+		} else {
+			out.Content = append(out.Content, k, v)
+		}
+	}
+	return out
+}
+
+// mappingKeyIndex returns the index of the key node named key in a mapping
+// node, or -1.
+func mappingKeyIndex(n *yamlv3.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// usesMergeKeys reports whether any mapping under n uses merge keys.
+func usesMergeKeys(n *yamlv3.Node) bool {
+	if n.Kind == yamlv3.MappingNode && hasMergeKey(n) {
+		return true
+	}
+	for _, c := range n.Content {
+		if usesMergeKeys(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// pairDocuments returns, for every document of common, the index of the
+// document of existing it is paired with, or -1. Documents are paired by
+// position or, with a stream match key, by the scalar found at that key path.
+func (o Options) pairDocuments(existing, common [][]byte) ([]int, error) {
+	pairs := make([]int, len(common))
+	if o.StreamMatchKey == "" {
+		for i := range common {
+			pairs[i] = -1
+			if i < len(existing) {
+				pairs[i] = i
+			}
+		}
+		return pairs, nil
+	}
+
+	byKey := make(map[string]int)
+	for j, d := range existing {
+		var v any
+		if err := syaml.Unmarshal(d, &v); err != nil {
+			return nil, fmt.Errorf("existing document %d: %w", j, err)
+		}
+		if _, key, ok := scalarAtPath(v, o.StreamMatchKey); ok {
+			if _, dup := byKey[key]; dup {
+				return nil, fmt.Errorf("duplicate existing documents for %s=%s", o.StreamMatchKey, key)
+			}
+			byKey[key] = j
+		}
+	}
+	for i, d := range common {
+		pairs[i] = -1
+		var v any
+		if err := syaml.Unmarshal(d, &v); err != nil {
+			return nil, fmt.Errorf("common document %d: %w", i, err)
+		}
+		if _, key, ok := scalarAtPath(v, o.StreamMatchKey); ok {
+			if j, ok := byKey[key]; ok {
+				pairs[i] = j
+			}
+		}
+	}
+	return pairs, nil
+}
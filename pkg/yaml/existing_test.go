@@ -0,0 +1,103 @@
+package yaml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommonConflicts(t *testing.T) {
+	existing := []byte("replicas: 1\nimage:\n  registry: docker.io\n  tag: v1\nannotations:\n  app.kubernetes.io/part-of: shop\n")
+	common := []byte("replicas: 3\nimage:\n  registry: docker.io\n  pullPolicy: Always\nannotations:\n  app.kubernetes.io/part-of: store\nport: 80\n")
+
+	conflicts, err := CommonConflicts(existing, common)
+	if err != nil {
+		t.Fatalf("CommonConflicts error: %v", err)
+	}
+	want := []string{`annotations.app\.kubernetes\.io/part-of`, "replicas"}
+	if !reflect.DeepEqual(want, conflicts) {
+		t.Fatalf("unexpected conflicts: %q", conflicts)
+	}
+
+	// Every key already defined conflicts with tombstones, as documents lacking
+	// it would delete it
+	conflicts, err = CommonConflicts(existing, common, WithNullTombstones(0.5))
+	if err != nil {
+		t.Fatalf("CommonConflicts error: %v", err)
+	}
+	want = []string{"annotations", "image", "replicas"}
+	if !reflect.DeepEqual(want, conflicts) {
+		t.Fatalf("unexpected conflicts with tombstones: %q", conflicts)
+	}
+}
+
+func TestCommonConflicts_ExcludedOnExtraction(t *testing.T) {
+	existing := []byte("annotations:\n  app.kubernetes.io/part-of: shop\n")
+	inputs := [][]byte{
+		[]byte("annotations:\n  app.kubernetes.io/part-of: store\n  team: core\n"),
+		[]byte("annotations:\n  app.kubernetes.io/part-of: store\n  team: core\n"),
+	}
+	common, _, err := ExtractCommonN(inputs)
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	conflicts, err := CommonConflicts(existing, common)
+	if err != nil {
+		t.Fatalf("CommonConflicts error: %v", err)
+	}
+	common, rems, err := ExtractCommonN(inputs, WithExcludePaths(conflicts...))
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("annotations:\n  team: core\n"), common)
+	for _, r := range rems {
+		assertYAMLEqual(t, []byte("annotations:\n  app.kubernetes.io/part-of: store\n"), r)
+	}
+}
+
+func TestMergeInto(t *testing.T) {
+	existing := []byte("# Region defaults\nregion: eu # keep me\nimage:\n  registry: docker.io\n")
+	common := []byte("image:\n  tag: v1\nreplicas: 3\n")
+
+	merged, err := MergeInto(existing, common)
+	if err != nil {
+		t.Fatalf("MergeInto error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("region: eu\nimage:\n  registry: docker.io\n  tag: v1\nreplicas: 3\n"), merged)
+	for _, s := range []string{"# Region defaults", "# keep me"} {
+		if !strings.Contains(string(merged), s) {
+			t.Fatalf("comment %q lost:\n%s", s, merged)
+		}
+	}
+	if strings.Index(string(merged), "region") > strings.Index(string(merged), "replicas") {
+		t.Fatalf("existing keys should come first:\n%s", merged)
+	}
+}
+
+func TestMergeInto_StreamsByKey(t *testing.T) {
+	existing := []byte("name: api\nregion: eu\n---\nname: web\nregion: us\n")
+	common := []byte("name: web\nport: 80\n---\nname: worker\nqueue: jobs\n")
+
+	merged, err := MergeInto(existing, common, WithStreamMatchKey("name"))
+	if err != nil {
+		t.Fatalf("MergeInto error: %v", err)
+	}
+	ok, err := EqualYAMLStreams([]byte("name: api\nregion: eu\n---\nname: web\nregion: us\nport: 80\n---\nname: worker\nqueue: jobs\n"), merged)
+	if err != nil || !ok {
+		t.Fatalf("unexpected merge (err %v):\n%s", err, merged)
+	}
+}
+
+func TestMergeInto_CommentOnly(t *testing.T) {
+	common := []byte("replicas: 3\n")
+	for _, existing := range []string{"# Region defaults\n", "# Region defaults\nnull\n"} {
+		merged, err := MergeInto([]byte(existing), common)
+		if err != nil {
+			t.Fatalf("MergeInto error: %v", err)
+		}
+		assertYAMLEqual(t, common, merged)
+		if !strings.Contains(string(merged), "# Region defaults") {
+			t.Fatalf("comment lost for %q:\n%s", existing, merged)
+		}
+	}
+}
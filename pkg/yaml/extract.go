@@ -1,11 +1,15 @@
 package yaml
 
 import (
-	"reflect"
-
-	syaml "sigs.k8s.io/yaml"
+	"errors"
+	"fmt"
+	"math"
 )
 
+// ErrMergeProperty is returned when merge(common, remainder) does not
+// reproduce the original document.
+var ErrMergeProperty = errors.New("merge property violated")
+
 // Options controls how common structures are extracted.
 //
 // IncludeEqualListsInCommon controls whether lists (YAML sequences) that are
@@ -13,9 +17,49 @@ import (
 // If false, even equal lists will remain in the updated outputs instead of in
 // the common output. Default is true.
 //
+// MajorityMinShare enables majority-value hoisting in ExtractCommonN when
+// greater than zero: a scalar (or list) present in every document is moved to
+// the common output when at least this share of the documents (and no fewer
+// than two) agree on its value. Documents with a different value keep it as an
+// override in their remainder, so the merge property still holds. Default is 0
+// (only values shared by every document are hoisted).
+//
+// TombstoneMinShare enables null-tombstone extraction in ExtractCommonN when
+// greater than zero: a key present (with a non-null value) in at least this
+// share of the documents, and in no fewer than two, is considered for hoisting
+// even if some documents lack it. Documents without the key get an explicit
+// `key: null` in their remainder, which Helm treats as a deletion. Since this
+// relies on null meaning "absent", the result is verified with
+// VerifyMergeProperty before being returned. Default is 0 (disabled).
+//
+// StreamMatchKey is the dotted key path used by ExtractCommonStreams to pair
+// documents across streams. Default is "" (pair by position).
+//
+// Fidelity decodes the documents with UnmarshalFidelity instead of going
+// through JSON, so every scalar keeps its tag, lexical form and quoting style
+// in the outputs, and two scalars are only equal when they are written the same
+// way: `1.0` and `1`, or `"yes"` and `yes`, are different values. Set
+// CompareDecodedScalars to compare them by their decoded value instead. Default
+// is false for both.
+//
+// ExcludePaths and OnlyPaths are key path patterns (see WithExcludePaths) that
+// keep values out of the common output or restrict it to some subtrees. Values
+// left out of the common output stay in every remainder. Default is no rules.
+//
 // Additional options can be added via the Option pattern.
 type Options struct {
 	IncludeEqualListsInCommon bool
+	MajorityMinShare          float64
+	TombstoneMinShare         float64
+	StreamMatchKey            string
+	Fidelity                  bool
+	CompareDecodedScalars     bool
+	ExcludePaths              []string
+	OnlyPaths                 []string
+
+	// keepPaths are dotted key paths that always stay in the remainders, even
+	// when hoisted to the common output.
+	keepPaths []string
 }
 
 // Option is a functional option for ExtractCommon.
@@ -26,6 +70,23 @@ func WithIncludeEqualListsInCommon(include bool) Option {
 	return func(o *Options) { o.IncludeEqualListsInCommon = include }
 }
 
+// WithMajorityDefaults hoists the most common value of a key when at least
+// minShare (between 0 and 1) of the documents share it. See Options.
+func WithMajorityDefaults(minShare float64) Option {
+	return func(o *Options) { o.MajorityMinShare = minShare }
+}
+
+// withKeepPath keeps the value at the dotted key path in every remainder.
+func withKeepPath(path string) Option {
+	return func(o *Options) { o.keepPaths = append(o.keepPaths, path) }
+}
+
+// WithNullTombstones hoists keys present in at least minShare (between 0 and 1)
+// of the documents, writing null tombstones where they are missing. See Options.
+func WithNullTombstones(minShare float64) Option {
+	return func(o *Options) { o.TombstoneMinShare = minShare }
+}
+
 func defaultOptions() Options {
 	return Options{IncludeEqualListsInCommon: true}
 }
@@ -43,21 +104,29 @@ func ExtractCommon(yaml1, yaml2 []byte, opts ...Option) ([]byte, []byte, []byte,
 	for _, opt := range opts {
 		opt(&options)
 	}
+	if err := assertSingleDocument(yaml1, yaml2); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := options.checkPatterns(); err != nil {
+		return nil, nil, nil, err
+	}
 
-	var v1 any
-	var v2 any
-	if len(yaml1) > 0 {
-		if err := syaml.Unmarshal(yaml1, &v1); err != nil {
-			return nil, nil, nil, err
-		}
+	v1, err := options.unmarshal(yaml1)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	if len(yaml2) > 0 {
-		if err := syaml.Unmarshal(yaml2, &v2); err != nil {
-			return nil, nil, nil, err
-		}
+	v2, err := options.unmarshal(yaml2)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	common, r1, r2 := extractCommonValue(v1, v2, options)
+	if len(options.ExcludePaths) > 0 || len(options.OnlyPaths) > 0 {
+		// Values kept out of the common output go back to both remainders.
+		common = options.filterPaths(common)
+		r1 = subtractCommon(v1, common, options)
+		r2 = subtractCommon(v2, common, options)
+	}
 
 	// Normalize: represent empty documents as {} rather than null
 	common = normalizeDocRoot(common)
@@ -65,15 +134,15 @@ func ExtractCommon(yaml1, yaml2 []byte, opts ...Option) ([]byte, []byte, []byte,
 	r2 = normalizeDocRoot(r2)
 
 	// Marshal results to YAML
-	commonY, err := syaml.Marshal(common)
+	commonY, err := options.marshal(common)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	r1Y, err := syaml.Marshal(r1)
+	r1Y, err := options.marshal(r1)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	r2Y, err := syaml.Marshal(r2)
+	r2Y, err := options.marshal(r2)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -85,41 +154,149 @@ func ExtractCommon(yaml1, yaml2 []byte, opts ...Option) ([]byte, []byte, []byte,
 //  2. N remainders (each input without the common part)
 //
 // The merge property holds for each i: merge(remainders[i], common) == original[i].
+// With WithMajorityDefaults, the most common value of a key may be hoisted even
+// when some documents disagree; those documents keep their own value.
 func ExtractCommonN(yamls [][]byte, opts ...Option) ([]byte, [][]byte, error) {
 	options := defaultOptions()
 	for _, opt := range opts {
 		opt(&options)
 	}
+	if err := assertSingleDocument(yamls...); err != nil {
+		return nil, nil, err
+	}
+	if err := options.checkPatterns(); err != nil {
+		return nil, nil, err
+	}
 	values := make([]any, len(yamls))
 	for i, y := range yamls {
-		var v any
-		if len(y) > 0 {
-			if err := syaml.Unmarshal(y, &v); err != nil {
-				return nil, nil, err
-			}
+		v, err := options.unmarshal(y)
+		if err != nil {
+			return nil, nil, err
 		}
 		values[i] = v
 	}
+	values = options.normalizeRoots(values)
 	common := computeCommonAcross(values, options)
+	common = options.filterPaths(common)
 	common = normalizeDocRoot(common)
 
 	remainders := make([][]byte, len(values))
 	for i, v := range values {
 		r := subtractCommon(v, common, options)
+		r = options.restoreKept(r, v)
 		r = normalizeDocRoot(r)
-		b, err := syaml.Marshal(r)
+		b, err := options.marshal(r)
 		if err != nil {
 			return nil, nil, err
 		}
 		remainders[i] = b
 	}
-	commonY, err := syaml.Marshal(common)
+	commonY, err := options.marshal(common)
 	if err != nil {
 		return nil, nil, err
 	}
+	if options.TombstoneMinShare > 0 {
+		if err := options.verifyMergeProperty(yamls, commonY, remainders); err != nil {
+			return nil, nil, err
+		}
+	}
 	return commonY, remainders, nil
 }
 
+// normalizeRoots treats empty documents as empty maps when tombstones are
+// enabled, so that they can receive tombstones like any other document.
+func (o Options) normalizeRoots(values []any) []any {
+	if o.TombstoneMinShare <= 0 {
+		return values
+	}
+	out := make([]any, len(values))
+	for i, v := range values {
+		if v == nil {
+			v = map[string]any{}
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// restoreKept puts back into the remainder r the values that v holds at the
+// paths that must stay in every remainder.
+func (o Options) restoreKept(r any, v any) any {
+	for _, p := range o.keepPaths {
+		val, ok := valueAtPath(v, p)
+		if !ok {
+			continue
+		}
+		rm, _ := asStringMap(r)
+		if r != nil && rm == nil {
+			continue
+		}
+		r = setAtPath(rm, p, val)
+	}
+	return r
+}
+
+// VerifyMergeProperty checks that merge(common, remainders[i]) reproduces
+// originals[i] for every document, using Helm semantics where a null value is
+// equivalent to an absent key. It returns an error wrapping ErrMergeProperty
+// for the first document that does not match. With WithFidelity, scalars must
+// also be written the same way, unless WithCompareDecodedScalars is used.
+func VerifyMergeProperty(originals [][]byte, common []byte, remainders [][]byte, opts ...Option) error {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options.verifyMergeProperty(originals, common, remainders)
+}
+
+func (o Options) verifyMergeProperty(originals [][]byte, common []byte, remainders [][]byte) error {
+	if len(originals) != len(remainders) {
+		return fmt.Errorf("%w: %d originals but %d remainders", ErrMergeProperty, len(originals), len(remainders))
+	}
+	mergeOpts := []Option{WithFidelity(o.Fidelity)}
+	for i := range originals {
+		merged, err := MergeYAML(common, remainders[i], mergeOpts...)
+		if err != nil {
+			return err
+		}
+		want, err := o.unmarshal(originals[i])
+		if err != nil {
+			return err
+		}
+		got, err := o.unmarshal(merged)
+		if err != nil {
+			return err
+		}
+		if !o.equal(normalizeDocRoot(pruneNulls(want)), normalizeDocRoot(pruneNulls(got))) {
+			return fmt.Errorf("%w: document %d", ErrMergeProperty, i)
+		}
+	}
+	return nil
+}
+
+// pruneNulls removes map entries holding null values, recursively.
+func pruneNulls(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, vv := range t {
+			if vv == nil {
+				continue
+			}
+			out[k] = pruneNulls(vv)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i := range t {
+			out[i] = pruneNulls(t[i])
+		}
+		return out
+	default:
+		return v
+	}
+}
+
 // computeCommonAcross returns the common structure across all provided values.
 func computeCommonAcross(values []any, options Options) any {
 	if len(values) == 0 {
@@ -144,8 +321,8 @@ func computeCommonAcross(values []any, options Options) any {
 	if allScalars {
 		base := values[0]
 		for _, v := range values[1:] {
-			if !reflect.DeepEqual(base, v) {
-				return nil
+			if !options.equal(base, v) {
+				return majorityValue(values, options)
 			}
 		}
 		return base
@@ -157,12 +334,15 @@ func computeCommonAcross(values []any, options Options) any {
 		base, _ := asList(values[0])
 		for _, v := range values[1:] {
 			l, _ := asList(v)
-			if !reflect.DeepEqual(base, l) {
-				return nil
+			if !options.equal(base, l) {
+				return majorityValue(values, options)
 			}
 		}
 		return base
 	}
+	if allMaps && options.TombstoneMinShare > 0 {
+		return computeCommonWithTombstones(values, options)
+	}
 	if allMaps {
 		// Intersect keys present in all maps, then recursively compute common
 		// for each key.
@@ -200,6 +380,69 @@ func computeCommonAcross(values []any, options Options) any {
 	return nil
 }
 
+// computeCommonWithTombstones computes the common map across maps where keys
+// only need to be present (with a non-null value) in enough of them. The common
+// value of each key is computed across the maps that have it.
+func computeCommonWithTombstones(values []any, options Options) any {
+	minCount := int(math.Ceil(options.TombstoneMinShare * float64(len(values))))
+	if minCount < 2 {
+		minCount = 2
+	}
+	counts := make(map[string]int)
+	for _, v := range values {
+		m, _ := asStringMap(v)
+		for k, vv := range m {
+			if vv != nil {
+				counts[k]++
+			}
+		}
+	}
+	out := make(map[string]any)
+	for k, count := range counts {
+		if count < minCount {
+			continue
+		}
+		keyVals := make([]any, 0, count)
+		for _, v := range values {
+			m, _ := asStringMap(v)
+			if vv := m[k]; vv != nil {
+				keyVals = append(keyVals, vv)
+			}
+		}
+		c := computeCommonAcross(keyVals, options)
+		if !isEmpty(c) {
+			out[k] = c
+		}
+	}
+	return mapOrNil(out)
+}
+
+// majorityValue returns the most frequent value across values when majority
+// hoisting is enabled and the value is shared by enough documents. Ties are
+// resolved in favor of the value that appears first.
+func majorityValue(values []any, options Options) any {
+	if options.MajorityMinShare <= 0 || len(values) == 0 {
+		return nil
+	}
+	var best any
+	bestCount := 0
+	for i, v := range values {
+		count := 0
+		for _, w := range values {
+			if options.equal(v, w) {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = values[i], count
+		}
+	}
+	if bestCount < 2 || float64(bestCount)/float64(len(values)) < options.MajorityMinShare {
+		return nil
+	}
+	return best
+}
+
 // subtractCommon removes common from v and returns the remainder that when merged
 // with common reconstructs v.
 func subtractCommon(v any, common any, options Options) any {
@@ -207,7 +450,7 @@ func subtractCommon(v any, common any, options Options) any {
 		return v
 	}
 	if isScalar(v) || isScalar(common) {
-		if reflect.DeepEqual(v, common) {
+		if options.equal(v, common) {
 			return nil
 		}
 		return v
@@ -217,6 +460,11 @@ func subtractCommon(v any, common any, options Options) any {
 			out := make(map[string]any)
 			// keys in v that are not in common are kept as-is
 			for k, vv := range vm {
+				if vv == nil {
+					// explicit nulls always stay, as they override the common value
+					out[k] = nil
+					continue
+				}
 				if cv, ok := cm[k]; ok {
 					r := subtractCommon(vv, cv, options)
 					if !isEmpty(r) {
@@ -226,6 +474,14 @@ func subtractCommon(v any, common any, options Options) any {
 					out[k] = vv
 				}
 			}
+			// keys in common that v lacks are deleted with a null tombstone
+			if options.TombstoneMinShare > 0 {
+				for k := range cm {
+					if _, ok := vm[k]; !ok {
+						out[k] = nil
+					}
+				}
+			}
 			return mapOrNil(out)
 		}
 		// map vs non-map -> nothing in common for this branch
@@ -233,7 +489,7 @@ func subtractCommon(v any, common any, options Options) any {
 	}
 	if vl, ok := asList(v); ok {
 		if cl, ok := asList(common); ok {
-			if options.IncludeEqualListsInCommon && reflect.DeepEqual(vl, cl) {
+			if options.IncludeEqualListsInCommon && options.equal(vl, cl) {
 				return nil
 			}
 			return v
@@ -249,7 +505,7 @@ func subtractCommon(v any, common any, options Options) any {
 func extractCommonValue(a, b any, options Options) (common any, ra any, rb any) {
 	// Fast path: identical scalars or identical lists with option enabled.
 	if isScalar(a) && isScalar(b) {
-		if reflect.DeepEqual(a, b) {
+		if options.equal(a, b) {
 			return a, nil, nil
 		}
 		return nil, a, b
@@ -299,7 +555,7 @@ func extractCommonValue(a, b any, options Options) (common any, ra any, rb any)
 	aList, aIsList := asList(a)
 	bList, bIsList := asList(b)
 	if aIsList && bIsList {
-		if options.IncludeEqualListsInCommon && reflect.DeepEqual(aList, bList) {
+		if options.IncludeEqualListsInCommon && options.equal(aList, bList) {
 			return aList, nil, nil
 		}
 		// No partial extraction from lists; treat as entirely different
@@ -313,7 +569,7 @@ func extractCommonValue(a, b any, options Options) (common any, ra any, rb any)
 	if !isZero(a) && isZero(b) {
 		return nil, a, nil
 	}
-	if reflect.DeepEqual(a, b) {
+	if options.equal(a, b) {
 		return a, nil, nil
 	}
 	return nil, a, b
@@ -361,7 +617,7 @@ func isScalar(v any) bool {
 		return false
 	}
 	switch v.(type) {
-	case string, bool, int, int64, int32, int16, int8, uint, uint64, uint32, uint16, uint8, float32, float64:
+	case string, bool, int, int64, int32, int16, int8, uint, uint64, uint32, uint16, uint8, float32, float64, Scalar:
 		return true
 	default:
 		return false
@@ -385,14 +641,19 @@ func normalizeDocRoot(v any) any {
 // MergeYAML merges two YAML documents in-memory by deep-merging maps and applying
 // a "last wins on conflict" policy (Helm-style). Lists and scalars are replaced
 // by the overlay. Intended for tests to validate that merge(common, remainder)
-// reconstructs the original.
-func MergeYAML(baseYAML, overlayYAML []byte) ([]byte, error) {
-	var base any
-	var overlay any
-	if err := syaml.Unmarshal(baseYAML, &base); err != nil {
+// reconstructs the original. With WithFidelity, scalars keep their original
+// form in the merged document.
+func MergeYAML(baseYAML, overlayYAML []byte, opts ...Option) ([]byte, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	base, err := options.unmarshal(baseYAML)
+	if err != nil {
 		return nil, err
 	}
-	if err := syaml.Unmarshal(overlayYAML, &overlay); err != nil {
+	overlay, err := options.unmarshal(overlayYAML)
+	if err != nil {
 		return nil, err
 	}
 
@@ -400,7 +661,7 @@ func MergeYAML(baseYAML, overlayYAML []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return syaml.Marshal(merged)
+	return options.marshal(merged)
 }
 
 func mergeValues(a, b any) (any, error) {
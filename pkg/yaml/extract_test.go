@@ -1,6 +1,7 @@
 package yaml
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -842,3 +843,138 @@ func TestExtractCommonN_OneEmpty_NoCommon(t *testing.T) {
 	assertYAMLEqual(t, []byte("a: 1\n"), rems[1])
 	assertYAMLEqual(t, []byte("a: 1\n"), rems[2])
 }
+
+func TestExtractCommonN_MajorityDefaults(t *testing.T) {
+	inputs := make([][]byte, 0, 10)
+	for i := 0; i < 9; i++ {
+		inputs = append(inputs, []byte("replicas: 3\nports: [80]\nname: c"+string(rune('0'+i))+"\n"))
+	}
+	inputs = append(inputs, []byte("replicas: 5\nports: [8080]\nname: c9\n"))
+
+	// Without the option nothing disagreeing is hoisted.
+	common, _, err := ExtractCommonN(inputs)
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("{}\n"), common)
+
+	common, rems, err := ExtractCommonN(inputs, WithMajorityDefaults(0.8))
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("replicas: 3\nports: [80]\n"), common)
+	assertYAMLEqual(t, []byte("name: c0\n"), rems[0])
+	assertYAMLEqual(t, []byte("replicas: 5\nports: [8080]\nname: c9\n"), rems[9])
+	for i := range rems {
+		m, err := MergeYAML(common, rems[i])
+		if err != nil {
+			t.Fatalf("MergeYAML error: %v", err)
+		}
+		assertYAMLEqual(t, inputs[i], m)
+	}
+
+	// A share above the actual agreement disables hoisting for that key.
+	common, _, err = ExtractCommonN(inputs, WithMajorityDefaults(0.95))
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("{}\n"), common)
+}
+
+func TestExtractCommonN_MajorityDefaults_MergeProperty(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("a:\n  x: 1\n  y: true\nb: v\n"),
+		[]byte("a:\n  x: 2\n  y: true\nb: v\n"),
+		[]byte("a:\n  x: 1\n  y: false\nb: w\n"),
+		[]byte("a:\n  x: 3\n  y: true\nb: null\n"),
+	}
+	for _, share := range []float64{0.25, 0.5, 0.75, 1} {
+		common, rems, err := ExtractCommonN(inputs, WithMajorityDefaults(share))
+		if err != nil {
+			t.Fatalf("ExtractCommonN error: %v", err)
+		}
+		for i := range rems {
+			m, err := MergeYAML(common, rems[i])
+			if err != nil {
+				t.Fatalf("MergeYAML error: %v", err)
+			}
+			assertYAMLEqual(t, inputs[i], m)
+		}
+	}
+}
+
+func TestExtractCommonN_NullTombstones(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("a: 1\nnested:\n  x: true\n  y: 1\nown: a\n"),
+		[]byte("a: 1\nnested:\n  x: true\nown: b\n"),
+		[]byte("nested:\n  y: 1\nown: c\n"),
+		[]byte(""),
+	}
+
+	// Without the option only keys present everywhere are candidates.
+	common, _, err := ExtractCommonN(inputs)
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("{}\n"), common)
+
+	common, rems, err := ExtractCommonN(inputs, WithNullTombstones(0.5))
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("a: 1\nnested:\n  x: true\n  y: 1\n"), common)
+	assertYAMLEqual(t, []byte("own: a\n"), rems[0])
+	assertYAMLEqual(t, []byte("nested:\n  y: null\nown: b\n"), rems[1])
+	assertYAMLEqual(t, []byte("a: null\nnested:\n  x: null\nown: c\n"), rems[2])
+	assertYAMLEqual(t, []byte("a: null\nnested: null\n"), rems[3])
+
+	if err := VerifyMergeProperty(inputs, common, rems); err != nil {
+		t.Fatalf("VerifyMergeProperty error: %v", err)
+	}
+
+	nodeCommon, nodeRems, err := ExtractCommonNodesN(inputs, WithNullTombstones(0.5))
+	if err != nil {
+		t.Fatalf("ExtractCommonNodesN error: %v", err)
+	}
+	assertYAMLEqual(t, common, nodeCommon)
+	for i := range rems {
+		assertYAMLEqual(t, rems[i], nodeRems[i])
+	}
+}
+
+func TestVerifyMergeProperty(t *testing.T) {
+	originals := [][]byte{[]byte("a: 1\nb: 2\n"), []byte("b: 2\n")}
+	common := []byte("a: 1\n")
+	if err := VerifyMergeProperty(originals, common, [][]byte{[]byte("b: 2\n"), []byte("a: null\nb: 2\n")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := VerifyMergeProperty(originals, common, [][]byte{[]byte("b: 2\n"), []byte("b: 2\n")})
+	if !errors.Is(err, ErrMergeProperty) {
+		t.Fatalf("expected ErrMergeProperty, got %v", err)
+	}
+
+	// With fidelity, scalars must keep the way they are written
+	originals = [][]byte{[]byte("mode: 0x1F\nenabled: yes\n"), []byte("mode: 0x1F\n")}
+	rems := [][]byte{[]byte("enabled: yes\n"), []byte("{}\n")}
+	if err := VerifyMergeProperty(originals, []byte("mode: 0x1F\n"), rems, WithFidelity(true)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rewritten := [][]byte{[]byte("enabled: true\n"), []byte("{}\n")}
+	for _, c := range [][]byte{[]byte("mode: 31\n"), []byte("mode: 0x1F\n")} {
+		if err := VerifyMergeProperty(originals, c, rewritten); err != nil {
+			t.Fatalf("unexpected error without fidelity: %v", err)
+		}
+	}
+	err = VerifyMergeProperty(originals, []byte("mode: 31\n"), rems, WithFidelity(true))
+	if !errors.Is(err, ErrMergeProperty) {
+		t.Fatalf("expected ErrMergeProperty for a rewritten number, got %v", err)
+	}
+	err = VerifyMergeProperty(originals, []byte("mode: 0x1F\n"), rewritten, WithFidelity(true))
+	if !errors.Is(err, ErrMergeProperty) {
+		t.Fatalf("expected ErrMergeProperty for a rewritten boolean, got %v", err)
+	}
+	err = VerifyMergeProperty(originals, []byte("mode: 0x1F\n"), rewritten, WithFidelity(true), WithCompareDecodedScalars(true))
+	if err != nil {
+		t.Fatalf("unexpected error comparing decoded scalars: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package yaml
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	syaml "sigs.k8s.io/yaml"
+)
+
+// Scalar is a YAML scalar kept exactly as it was written: its resolved tag
+// (for example "!!int" or "!!str"), its lexical form and its quoting style.
+// Scalars are produced by UnmarshalFidelity and by the functions running with
+// WithFidelity, and are rendered back unchanged, so `1.0`, `0755`, `on` or
+// `12345678901234567890` survive a round trip.
+type Scalar struct {
+	Tag   string
+	Value string
+	Style yamlv3.Style
+}
+
+// String returns the lexical form of the scalar.
+func (s Scalar) String() string {
+	return s.Value
+}
+
+// Interface returns the Go value the scalar decodes to with gopkg.in/yaml.v3.
+// If the scalar cannot be decoded, its lexical form is returned.
+func (s Scalar) Interface() any {
+	var v any
+	if err := s.node().Decode(&v); err != nil {
+		return s.Value
+	}
+	return v
+}
+
+// MarshalYAML renders the scalar with its original tag, form and style.
+func (s Scalar) MarshalYAML() (any, error) {
+	return s.node(), nil
+}
+
+// MarshalJSON renders numbers with their lexical form when it is valid JSON,
+// so that big integers do not lose precision, and other scalars with their
+// decoded value.
+func (s Scalar) MarshalJSON() ([]byte, error) {
+	if (s.Tag == "!!int" || s.Tag == "!!float") && json.Valid([]byte(s.Value)) {
+		return []byte(s.Value), nil
+	}
+	return json.Marshal(s.Interface())
+}
+
+func (s Scalar) node() *yamlv3.Node {
+	return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: s.Tag, Value: s.Value, Style: s.Style}
+}
+
+// WithFidelity keeps every scalar with its original tag, lexical form and
+// quoting style. Scalars are only considered equal when they are written the
+// same way, unless WithCompareDecodedScalars is also used. See Options.
+func WithFidelity(enabled bool) Option {
+	return func(o *Options) { o.Fidelity = enabled }
+}
+
+// WithCompareDecodedScalars makes the fidelity mode compare scalars by their
+// decoded value, so `"80"` and `'80'` or `yes` and `true` are considered equal.
+// The form used in the first document wins in the common output.
+func WithCompareDecodedScalars(enabled bool) Option {
+	return func(o *Options) { o.CompareDecodedScalars = enabled }
+}
+
+// UnmarshalFidelity decodes a single YAML document into maps, lists and
+// Scalar values. Nulls are decoded as nil, as they mean "absent" for Helm, and
+// aliases and merge keys are resolved.
+func UnmarshalFidelity(b []byte) (any, error) {
+	if err := assertSingleDocument(b); err != nil {
+		return nil, err
+	}
+	doc, err := parseDocNode(b)
+	if err != nil {
+		return nil, err
+	}
+	return fidelityValue(docRoot(doc))
+}
+
+// MarshalFidelity encodes a value holding Scalar values (as returned by
+// UnmarshalFidelity) as YAML. Map keys are sorted and a nil value is rendered
+// as an empty mapping.
+func MarshalFidelity(v any) ([]byte, error) {
+	n, err := encodeValue(normalizeDocRoot(v))
+	if err != nil {
+		return nil, err
+	}
+	return encodeDocNode(&yamlv3.Node{Kind: yamlv3.DocumentNode}, n)
+}
+
+// fidelityValue converts a node into maps, lists and Scalar values.
+func fidelityValue(n *yamlv3.Node) (any, error) {
+	if n == nil {
+		return nil, nil
+	}
+	switch n.Kind {
+	case yamlv3.ScalarNode:
+		if n.ShortTag() == "!!null" {
+			return nil, nil
+		}
+		return Scalar{Tag: n.ShortTag(), Value: n.Value, Style: n.Style &^ yamlv3.FlowStyle}, nil
+	case yamlv3.SequenceNode:
+		out := make([]any, len(n.Content))
+		for i, c := range n.Content {
+			v, err := fidelityValue(c)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case yamlv3.MappingNode:
+		out := make(map[string]any, len(n.Content)/2)
+		merged := make(map[string]any)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.ShortTag() == "!!merge" {
+				if err := mergeFidelityKey(merged, v); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			fv, err := fidelityValue(v)
+			if err != nil {
+				return nil, err
+			}
+			out[k.Value] = fv
+		}
+		for k, v := range merged {
+			if _, ok := out[k]; !ok {
+				out[k] = v
+			}
+		}
+		return out, nil
+	case yamlv3.AliasNode:
+		return fidelityValue(n.Alias)
+	case yamlv3.DocumentNode:
+		return fidelityValue(docRoot(n))
+	default:
+		return nil, fmt.Errorf("unsupported YAML node kind %v at line %d", n.Kind, n.Line)
+	}
+}
+
+// mergeFidelityKey adds to merged the entries of the mapping (or list of
+// mappings) v referenced by a "<<" merge key. Earlier mappings take precedence.
+func mergeFidelityKey(merged map[string]any, v *yamlv3.Node) error {
+	if v.Kind == yamlv3.AliasNode {
+		v = v.Alias
+	}
+	sources := []*yamlv3.Node{v}
+	if v.Kind == yamlv3.SequenceNode {
+		sources = v.Content
+	}
+	for _, src := range sources {
+		fv, err := fidelityValue(src)
+		if err != nil {
+			return err
+		}
+		m, ok := asStringMap(fv)
+		if !ok {
+			return fmt.Errorf("merge key at line %d does not reference a mapping", v.Line)
+		}
+		for k, mv := range m {
+			if _, ok := merged[k]; !ok {
+				merged[k] = mv
+			}
+		}
+	}
+	return nil
+}
+
+// decodeScalars replaces every Scalar in v by the value sigs.k8s.io/yaml
+// decodes it to, which is how scalars are compared outside the fidelity mode.
+func decodeScalars(v any) any {
+	switch t := v.(type) {
+	case Scalar:
+		b, err := yamlv3.Marshal(t)
+		if err != nil {
+			return t.Value
+		}
+		var out any
+		if err := syaml.Unmarshal(b, &out); err != nil {
+			return t.Value
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, vv := range t {
+			out[k] = decodeScalars(vv)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i := range t {
+			out[i] = decodeScalars(t[i])
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// equal compares two decoded values. In fidelity mode scalars must be written
+// the same way, unless CompareDecodedScalars is set.
+func (o Options) equal(a, b any) bool {
+	if o.Fidelity && o.CompareDecodedScalars {
+		return reflect.DeepEqual(decodeScalars(a), decodeScalars(b))
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// unmarshal decodes a document, keeping the exact scalars in fidelity mode.
+// Empty documents are decoded as nil.
+func (o Options) unmarshal(b []byte) (any, error) {
+	if o.Fidelity {
+		return UnmarshalFidelity(b)
+	}
+	var v any
+	if len(b) > 0 {
+		if err := syaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// marshal encodes a value decoded with unmarshal.
+func (o Options) marshal(v any) ([]byte, error) {
+	if o.Fidelity {
+		return MarshalFidelity(v)
+	}
+	return syaml.Marshal(v)
+}
@@ -0,0 +1,143 @@
+package yaml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalFidelity_RoundTrip(t *testing.T) {
+	in := `big: 12345678901234567890
+enabled: on
+float: 1.0
+list:
+  - yes
+  - 'x'
+mode: 0755
+port: "80"
+script: |
+  echo hi
+tagged: !!str 123
+`
+	v, err := UnmarshalFidelity([]byte(in))
+	if err != nil {
+		t.Fatalf("UnmarshalFidelity error: %v", err)
+	}
+	m := v.(map[string]any)
+	if got, want := m["big"], (Scalar{Tag: "!!int", Value: "12345678901234567890"}); got != want {
+		t.Fatalf("unexpected scalar: %#v", got)
+	}
+	out, err := MarshalFidelity(v)
+	if err != nil {
+		t.Fatalf("MarshalFidelity error: %v", err)
+	}
+	if string(out) != in {
+		t.Fatalf("round trip changed the document\n---- got ----\n%s\n---- expect ----\n%s", out, in)
+	}
+}
+
+func TestUnmarshalFidelity_AliasesAndMergeKeys(t *testing.T) {
+	v, err := UnmarshalFidelity([]byte("base: &base\n  a: 1.0\n  b: x\nsvc:\n  <<: *base\n  b: y\nnothing: ~\n"))
+	if err != nil {
+		t.Fatalf("UnmarshalFidelity error: %v", err)
+	}
+	out, err := MarshalFidelity(v)
+	if err != nil {
+		t.Fatalf("MarshalFidelity error: %v", err)
+	}
+	want := "base:\n  a: 1.0\n  b: x\nnothing: null\nsvc:\n  a: 1.0\n  b: y\n"
+	if string(out) != want {
+		t.Fatalf("unexpected output\n---- got ----\n%s\n---- expect ----\n%s", out, want)
+	}
+}
+
+func TestExtractCommon_Fidelity(t *testing.T) {
+	y1 := []byte("a: 1.0\nport: \"80\"\nbig: 12345678901234567890\nmode: 0755\n")
+	y2 := []byte("a: 1\nport: \"80\"\nbig: 12345678901234567890\nmode: 0755\n")
+
+	// Without fidelity, 1.0 and 1 are the same value and the big integer loses
+	// its precision.
+	common, _, _, err := ExtractCommon(y1, y2)
+	if err != nil {
+		t.Fatalf("ExtractCommon error: %v", err)
+	}
+	if strings.Contains(string(common), "12345678901234567890") {
+		t.Fatalf("expected precision loss without fidelity:\n%s", common)
+	}
+
+	common, u1, u2, err := ExtractCommon(y1, y2, WithFidelity(true))
+	if err != nil {
+		t.Fatalf("ExtractCommon error: %v", err)
+	}
+	if want := "big: 12345678901234567890\nmode: 0755\nport: \"80\"\n"; string(common) != want {
+		t.Fatalf("unexpected common\n---- got ----\n%s\n---- expect ----\n%s", common, want)
+	}
+	if string(u1) != "a: 1.0\n" || string(u2) != "a: 1\n" {
+		t.Fatalf("unexpected remainders: %q, %q", u1, u2)
+	}
+
+	for i, pair := range [][2][]byte{{y1, u1}, {y2, u2}} {
+		m, err := MergeYAML(common, pair[1], WithFidelity(true))
+		if err != nil {
+			t.Fatalf("MergeYAML error: %v", err)
+		}
+		want, err := MarshalFidelity(mustUnmarshalFidelity(t, pair[0]))
+		if err != nil {
+			t.Fatalf("MarshalFidelity error: %v", err)
+		}
+		if string(m) != string(want) {
+			t.Fatalf("document %d does not merge back exactly\n---- got ----\n%s\n---- expect ----\n%s", i, m, want)
+		}
+	}
+}
+
+func TestExtractCommonN_FidelityDecodedScalars(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("a: 1.0\nport: \"80\"\nname: x\n"),
+		[]byte("a: 1\nport: '80'\nname: y\n"),
+		[]byte("a: 1.00\nport: 80\nname: z\n"),
+	}
+	common, rems, err := ExtractCommonN(inputs, WithFidelity(true))
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	if string(common) != "{}\n" {
+		t.Fatalf("expected nothing in common, got:\n%s", common)
+	}
+
+	common, rems, err = ExtractCommonN(inputs, WithFidelity(true), WithCompareDecodedScalars(true))
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	if want := "a: 1.0\n"; string(common) != want {
+		t.Fatalf("unexpected common\n---- got ----\n%s\n---- expect ----\n%s", common, want)
+	}
+	if want := "name: z\nport: 80\n"; string(rems[2]) != want {
+		t.Fatalf("unexpected remainder\n---- got ----\n%s\n---- expect ----\n%s", rems[2], want)
+	}
+}
+
+func TestExtractCommonNodesN_Fidelity(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("# replicas\nreplicas: 1.0 # float on purpose\nenabled: yes\n"),
+		[]byte("replicas: 1\nenabled: yes\n"),
+	}
+	common, rems, err := ExtractCommonNodesN(inputs, WithFidelity(true))
+	if err != nil {
+		t.Fatalf("ExtractCommonNodesN error: %v", err)
+	}
+	if want := "enabled: yes\n"; string(common) != want {
+		t.Fatalf("unexpected common\n---- got ----\n%s\n---- expect ----\n%s", common, want)
+	}
+	if want := "# replicas\nreplicas: 1.0 # float on purpose\n"; string(rems[0]) != want {
+		t.Fatalf("unexpected remainder\n---- got ----\n%s\n---- expect ----\n%s", rems[0], want)
+	}
+}
+
+func mustUnmarshalFidelity(t *testing.T, b []byte) any {
+	t.Helper()
+	v, err := UnmarshalFidelity(b)
+	if err != nil {
+		t.Fatalf("UnmarshalFidelity error: %v", err)
+	}
+	return v
+}
@@ -0,0 +1,399 @@
+package yaml
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ExtractCommonNodes is the comment- and order-preserving counterpart of
+// ExtractCommon. The decision of what is common is exactly the same as in
+// ExtractCommonN, but the outputs are rendered from the original yaml.v3 nodes:
+//   - head, line and foot comments stay attached to the nodes that remain in
+//     each remainder,
+//   - comments of hoisted nodes are carried over to the common document,
+//   - keys keep the order they had in the first input that contains them.
+func ExtractCommonNodes(yaml1, yaml2 []byte, opts ...Option) ([]byte, []byte, []byte, error) {
+	common, remainders, err := ExtractCommonNodesN([][]byte{yaml1, yaml2}, opts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return common, remainders[0], remainders[1], nil
+}
+
+// ExtractCommonNodesN is the comment- and order-preserving counterpart of
+// ExtractCommonN. See ExtractCommonNodes for the preservation rules.
+//
+// The merge property holds for each i: merge(common, remainders[i]) == original[i].
+func ExtractCommonNodesN(yamls [][]byte, opts ...Option) ([]byte, [][]byte, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := assertSingleDocument(yamls...); err != nil {
+		return nil, nil, err
+	}
+	if err := options.checkPatterns(); err != nil {
+		return nil, nil, err
+	}
+
+	docs := make([]*yamlv3.Node, len(yamls))
+	values := make([]any, len(yamls))
+	for i, y := range yamls {
+		doc, err := parseDocNode(y)
+		if err != nil {
+			return nil, nil, err
+		}
+		docs[i] = doc
+		v, err := options.unmarshal(y)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[i] = v
+	}
+	values = options.normalizeRoots(values)
+
+	common := computeCommonAcross(values, options)
+	common = options.filterPaths(common)
+
+	roots := make([]*yamlv3.Node, len(docs))
+	for i, doc := range docs {
+		roots[i] = docRoot(doc)
+	}
+	commonNode, err := projectCommon(roots, values, common)
+	if err != nil {
+		return nil, nil, err
+	}
+	commonY, err := encodeDocNode(&yamlv3.Node{Kind: yamlv3.DocumentNode}, commonNode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	remainders := make([][]byte, len(docs))
+	for i, doc := range docs {
+		r := subtractCommon(values[i], common, options)
+		r = options.restoreKept(r, values[i])
+		var rn *yamlv3.Node
+		if !isEmpty(r) {
+			rn, err = pruneNode(roots[i], values[i], r)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		b, err := encodeDocNode(doc, rn)
+		if err != nil {
+			return nil, nil, err
+		}
+		remainders[i] = b
+	}
+	if options.TombstoneMinShare > 0 {
+		if err := options.verifyMergeProperty(yamls, commonY, remainders); err != nil {
+			return nil, nil, err
+		}
+	}
+	return commonY, remainders, nil
+}
+
+// parseDocNode parses a single YAML document into its document node. Aliases
+// are expanded so that nodes can be moved between documents without leaving
+// dangling references behind.
+func parseDocNode(b []byte) (*yamlv3.Node, error) {
+	doc := &yamlv3.Node{}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return &yamlv3.Node{Kind: yamlv3.DocumentNode}, nil
+	}
+	if err := yamlv3.Unmarshal(b, doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc.Kind = yamlv3.DocumentNode
+	}
+	expandAliases(doc)
+	return doc, nil
+}
+
+// docRoot returns the root content node of a document, or nil for an empty or
+// null document.
+func docRoot(doc *yamlv3.Node) *yamlv3.Node {
+	if doc == nil || len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind == yamlv3.ScalarNode && root.Tag == "!!null" {
+		return nil
+	}
+	return root
+}
+
+// encodeDocNode renders root inside a copy of the document node doc, keeping the
+// document-level comments. A nil root is rendered as an empty mapping.
+func encodeDocNode(doc *yamlv3.Node, root *yamlv3.Node) ([]byte, error) {
+	if root == nil {
+		root = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+	}
+	if root.Kind == yamlv3.MappingNode && len(root.Content) == 0 {
+		root.Style |= yamlv3.FlowStyle
+	}
+	out := *doc
+	out.Content = []*yamlv3.Node{root}
+
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&out); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// expandAliases replaces every alias node under n with a copy of the node it
+// points to.
+func expandAliases(n *yamlv3.Node) {
+	for i, c := range n.Content {
+		if c.Kind == yamlv3.AliasNode && c.Alias != nil {
+			cp := copyNode(c.Alias)
+			cp.Anchor = ""
+			n.Content[i] = cp
+			c = cp
+		}
+		expandAliases(c)
+	}
+}
+
+// copyNode returns a deep copy of n.
+func copyNode(n *yamlv3.Node) *yamlv3.Node {
+	if n == nil {
+		return nil
+	}
+	cp := *n
+	if len(n.Content) > 0 {
+		cp.Content = make([]*yamlv3.Node, len(n.Content))
+		for i, c := range n.Content {
+			cp.Content[i] = copyNode(c)
+		}
+	}
+	return &cp
+}
+
+// hasMergeKey reports whether the mapping node uses YAML merge keys ("<<").
+// Such mappings cannot be pruned key by key as their decoded form differs from
+// their node form.
+func hasMergeKey(n *yamlv3.Node) bool {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Tag == "!!merge" || n.Content[i].Value == "<<" {
+			return true
+		}
+	}
+	return false
+}
+
+// projectCommon renders the common value as a node tree. Nodes (and their
+// comments) are taken from the first source whose content matches, and keys are
+// ordered as they appear in the sources. Values that cannot be found in any
+// source node are encoded from scratch.
+func projectCommon(sources []*yamlv3.Node, values []any, common any) (*yamlv3.Node, error) {
+	if common == nil {
+		return nil, nil
+	}
+
+	if cm, ok := asStringMap(common); ok {
+		out := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		styled := false
+		emitted := make(map[string]struct{}, len(cm))
+		for i, src := range sources {
+			if src == nil || src.Kind != yamlv3.MappingNode || hasMergeKey(src) {
+				continue
+			}
+			if !styled {
+				out.Style = src.Style
+				styled = true
+			}
+			fillComments(out, src)
+			for j := 0; j+1 < len(src.Content); j += 2 {
+				key := src.Content[j]
+				cv, ok := cm[key.Value]
+				if !ok {
+					continue
+				}
+				if _, done := emitted[key.Value]; done {
+					continue
+				}
+				emitted[key.Value] = struct{}{}
+
+				subSources, subValues := childrenAt(sources, values, key.Value)
+				// Prefer the key's own source so comments travel together.
+				subSources[0], subSources[i] = subSources[i], subSources[0]
+				subValues[0], subValues[i] = subValues[i], subValues[0]
+				vn, err := projectCommon(subSources, subValues, cv)
+				if err != nil {
+					return nil, err
+				}
+				kn := copyNode(key)
+				fillComments(kn, keyNodesAt(sources, key.Value)...)
+				out.Content = append(out.Content, kn, vn)
+			}
+		}
+		// Keys not found in any source (e.g. produced through merge keys).
+		for _, k := range sortedKeys(cm) {
+			if _, done := emitted[k]; done {
+				continue
+			}
+			kn, vn, err := encodePair(k, cm[k])
+			if err != nil {
+				return nil, err
+			}
+			out.Content = append(out.Content, kn, vn)
+		}
+		return out, nil
+	}
+
+	var out *yamlv3.Node
+	for i, src := range sources {
+		if src == nil || !reflect.DeepEqual(values[i], common) {
+			continue
+		}
+		if out == nil {
+			out = copyNode(src)
+		}
+		fillComments(out, src)
+	}
+	if out != nil {
+		return out, nil
+	}
+	return encodeValue(common)
+}
+
+// fillComments sets every empty comment of n to the comment of the first of
+// the nodes that has one, so that comments found only in some of the inputs
+// are kept.
+func fillComments(n *yamlv3.Node, from ...*yamlv3.Node) {
+	for _, f := range from {
+		if f == nil {
+			continue
+		}
+		if n.HeadComment == "" {
+			n.HeadComment = f.HeadComment
+		}
+		if n.LineComment == "" {
+			n.LineComment = f.LineComment
+		}
+		if n.FootComment == "" {
+			n.FootComment = f.FootComment
+		}
+	}
+}
+
+// keyNodesAt returns the key nodes of key in every source mapping.
+func keyNodesAt(sources []*yamlv3.Node, key string) []*yamlv3.Node {
+	var keys []*yamlv3.Node
+	for _, src := range sources {
+		if src == nil || src.Kind != yamlv3.MappingNode || hasMergeKey(src) {
+			continue
+		}
+		for j := 0; j+1 < len(src.Content); j += 2 {
+			if src.Content[j].Value == key {
+				keys = append(keys, src.Content[j])
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// childrenAt returns, for every source mapping, the value node and decoded value
+// stored under key. Missing entries are nil.
+func childrenAt(sources []*yamlv3.Node, values []any, key string) ([]*yamlv3.Node, []any) {
+	subSources := make([]*yamlv3.Node, len(sources))
+	subValues := make([]any, len(sources))
+	for i, src := range sources {
+		if vm, ok := asStringMap(values[i]); ok {
+			subValues[i] = vm[key]
+		}
+		if src == nil || src.Kind != yamlv3.MappingNode || hasMergeKey(src) {
+			continue
+		}
+		for j := 0; j+1 < len(src.Content); j += 2 {
+			if src.Content[j].Value == key {
+				subSources[i] = src.Content[j+1]
+				break
+			}
+		}
+	}
+	return subSources, subValues
+}
+
+// pruneNode returns a copy of n that only keeps the parts present in the
+// remainder r, where v is the decoded form of n. Entries present in r but not
+// in n (such as null tombstones) are appended at the end.
+func pruneNode(n *yamlv3.Node, v any, r any) (*yamlv3.Node, error) {
+	rm, rok := asStringMap(r)
+	vm, vok := asStringMap(v)
+	if n == nil {
+		return encodeValue(r)
+	}
+	if !rok || !vok || n.Kind != yamlv3.MappingNode || hasMergeKey(n) {
+		return n, nil
+	}
+
+	out := *n
+	out.Content = nil
+	seen := make(map[string]struct{}, len(rm))
+	for j := 0; j+1 < len(n.Content); j += 2 {
+		key, val := n.Content[j], n.Content[j+1]
+		rv, ok := rm[key.Value]
+		if !ok {
+			continue
+		}
+		seen[key.Value] = struct{}{}
+		pv, err := pruneNode(val, vm[key.Value], rv)
+		if err != nil {
+			return nil, err
+		}
+		out.Content = append(out.Content, key, pv)
+	}
+	for _, k := range sortedKeys(rm) {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		kn, vn, err := encodePair(k, rm[k])
+		if err != nil {
+			return nil, err
+		}
+		out.Content = append(out.Content, kn, vn)
+	}
+	return &out, nil
+}
+
+// encodeValue builds a node from a decoded value.
+func encodeValue(v any) (*yamlv3.Node, error) {
+	n := &yamlv3.Node{}
+	if err := n.Encode(v); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// encodePair builds the key and value nodes of a single mapping entry.
+func encodePair(k string, v any) (*yamlv3.Node, *yamlv3.Node, error) {
+	vn, err := encodeValue(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	kn := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: k}
+	return kn, vn, nil
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,150 @@
+package yaml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractCommonNodes_PreservesComments(t *testing.T) {
+	y1 := []byte(`# header for the first file
+
+image:
+  # pinned on purpose, see TICKET-123
+  repository: nginx # do not change
+  tag: "1.2"
+replicas: 3
+`)
+	y2 := []byte(`image:
+  repository: nginx
+  tag: "1.3" # bumped by the release train
+replicas: 3
+`)
+
+	common, u1, u2, err := ExtractCommonNodes(y1, y2)
+	if err != nil {
+		t.Fatalf("ExtractCommonNodes error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("image:\n  repository: nginx\nreplicas: 3\n"), common)
+	assertYAMLEqual(t, []byte("image:\n  tag: \"1.2\"\n"), u1)
+	assertYAMLEqual(t, []byte("image:\n  tag: \"1.3\"\n"), u2)
+
+	for _, want := range []string{"# pinned on purpose, see TICKET-123", "# do not change"} {
+		if !strings.Contains(string(common), want) {
+			t.Fatalf("common lost comment %q:\n%s", want, common)
+		}
+	}
+	if !strings.Contains(string(u1), "# header for the first file") {
+		t.Fatalf("remainder lost document comment:\n%s", u1)
+	}
+	if !strings.Contains(string(u2), "# bumped by the release train") {
+		t.Fatalf("remainder lost line comment:\n%s", u2)
+	}
+
+	m1, err := MergeYAML(common, u1)
+	if err != nil {
+		t.Fatalf("MergeYAML m1 error: %v", err)
+	}
+	m2, err := MergeYAML(common, u2)
+	if err != nil {
+		t.Fatalf("MergeYAML m2 error: %v", err)
+	}
+	assertYAMLEqual(t, y1, m1)
+	assertYAMLEqual(t, y2, m2)
+}
+
+func TestExtractCommonNodes_CommentsFromAnyInput(t *testing.T) {
+	a := []byte(`zeta: 1 # keep zeta
+# about image
+image:
+  # the repo
+  repository: nginx
+  tag: "1.2"
+`)
+	b := []byte(`zeta: 1
+image:
+  repository: nginx
+  tag: "1.3"
+`)
+
+	// The comments are kept whatever the position of the input holding them
+	for _, inputs := range [][2][]byte{{a, b}, {b, a}} {
+		common, _, _, err := ExtractCommonNodes(inputs[0], inputs[1])
+		if err != nil {
+			t.Fatalf("ExtractCommonNodes error: %v", err)
+		}
+		assertYAMLEqual(t, []byte("zeta: 1\nimage:\n  repository: nginx\n"), common)
+		for _, want := range []string{"# keep zeta", "# about image", "# the repo"} {
+			if !strings.Contains(string(common), want) {
+				t.Fatalf("common lost comment %q:\n%s", want, common)
+			}
+		}
+	}
+}
+
+func TestExtractCommonNodesN_KeepsKeyOrderOfFirstInput(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("zeta: 1\nalpha: 2\nmiddle:\n  z: true\n  a: true\nown: a\n"),
+		[]byte("alpha: 2\nmiddle:\n  a: true\n  z: true\nzeta: 1\nown: b\n"),
+		[]byte("middle:\n  z: true\n  a: true\nzeta: 1\nalpha: 2\nown: c\n"),
+	}
+	common, rems, err := ExtractCommonNodesN(inputs)
+	if err != nil {
+		t.Fatalf("ExtractCommonNodesN error: %v", err)
+	}
+	want := "zeta: 1\nalpha: 2\nmiddle:\n  z: true\n  a: true\n"
+	if string(common) != want {
+		t.Fatalf("unexpected common order\n---- got ----\n%s\n---- expect ----\n%s", common, want)
+	}
+	for i := range rems {
+		m, err := MergeYAML(common, rems[i])
+		if err != nil {
+			t.Fatalf("MergeYAML error: %v", err)
+		}
+		assertYAMLEqual(t, inputs[i], m)
+	}
+}
+
+func TestExtractCommonNodesN_MatchesValueBasedExtraction(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("a:\n  x: 1\n  y: 2\nb: 1\nl: [1, 2]\n"),
+		[]byte("a:\n  x: 1\n  z: 3\nb: 2\nl: [1, 2]\n"),
+		[]byte("a:\n  x: 1\n  y: 9\nc: 3\nl: [1, 2]\n"),
+	}
+	wantCommon, wantRems, err := ExtractCommonN(inputs)
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	common, rems, err := ExtractCommonNodesN(inputs)
+	if err != nil {
+		t.Fatalf("ExtractCommonNodesN error: %v", err)
+	}
+	assertYAMLEqual(t, wantCommon, common)
+	for i := range rems {
+		assertYAMLEqual(t, wantRems[i], rems[i])
+	}
+}
+
+func TestExtractCommonNodes_AliasesAndEmptyDocs(t *testing.T) {
+	y1 := []byte(`defaults: &defaults
+  port: 80
+svc: *defaults
+`)
+	y2 := []byte(`svc:
+  port: 80
+`)
+	common, u1, u2, err := ExtractCommonNodes(y1, y2)
+	if err != nil {
+		t.Fatalf("ExtractCommonNodes error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("svc:\n  port: 80\n"), common)
+	assertYAMLEqual(t, []byte("defaults:\n  port: 80\n"), u1)
+	assertYAMLEqual(t, []byte("{}\n"), u2)
+
+	common, u1, u2, err = ExtractCommonNodes(nil, []byte("a: 1\n"))
+	if err != nil {
+		t.Fatalf("ExtractCommonNodes error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("{}\n"), common)
+	assertYAMLEqual(t, []byte("{}\n"), u1)
+	assertYAMLEqual(t, []byte("a: 1\n"), u2)
+}
@@ -0,0 +1,161 @@
+package yaml
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// WithExcludePaths keeps the values matching any of the given patterns out of
+// the common output, so they stay in every remainder. Patterns are dotted key
+// paths where "*" matches a single key (or part of it, as in path.Match) and
+// "**" matches any number of keys, for example "*.image.tag" or "global.**".
+// A pattern matching a map excludes everything below it. A backslash matches
+// the next character literally, so `annotations.app\.kubernetes\.io/name`
+// matches a key holding dots. The option can be given several times; patterns
+// accumulate.
+func WithExcludePaths(patterns ...string) Option {
+	return func(o *Options) { o.ExcludePaths = append(o.ExcludePaths, patterns...) }
+}
+
+// WithOnlyPaths restricts the common output to the values matching any of the
+// given patterns; everything else stays in the remainders. Patterns follow the
+// syntax of WithExcludePaths, and a pattern matching a map includes everything
+// below it. The option can be given several times; patterns accumulate.
+func WithOnlyPaths(patterns ...string) Option {
+	return func(o *Options) { o.OnlyPaths = append(o.OnlyPaths, patterns...) }
+}
+
+// checkPatterns validates the exclude and only patterns.
+func (o Options) checkPatterns() error {
+	for _, patterns := range [][]string{o.ExcludePaths, o.OnlyPaths} {
+		for _, p := range patterns {
+			if p == "" {
+				return fmt.Errorf("empty path pattern")
+			}
+			for _, seg := range splitPattern(p) {
+				if _, err := path.Match(seg, ""); err != nil {
+					return fmt.Errorf("invalid path pattern %q: %w", p, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// filterPaths drops from the common value the leaves the path rules keep out
+// of it. Paths kept in every remainder (such as a stream match key) are never
+// dropped.
+func (o Options) filterPaths(common any) any {
+	if len(o.ExcludePaths) == 0 && len(o.OnlyPaths) == 0 {
+		return common
+	}
+	return o.filterPathsAt(common, nil, false)
+}
+
+func (o Options) filterPathsAt(v any, prefix []string, included bool) any {
+	if len(prefix) > 0 {
+		if o.isKeptPath(prefix) {
+			return v
+		}
+		if matchAnyPattern(o.ExcludePaths, prefix) {
+			return nil
+		}
+		included = included || len(o.OnlyPaths) == 0 || matchAnyPattern(o.OnlyPaths, prefix)
+	}
+	m, ok := asStringMap(v)
+	if !ok {
+		if included {
+			return v
+		}
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, vv := range m {
+		if fv := o.filterPathsAt(vv, append(prefix[:len(prefix):len(prefix)], k), included); !isEmpty(fv) {
+			out[k] = fv
+		}
+	}
+	return mapOrNil(out)
+}
+
+// isKeptPath reports whether the key path is one of the keepPaths.
+func (o Options) isKeptPath(keys []string) bool {
+	for _, p := range o.keepPaths {
+		if matchPattern(splitPath(p), keys) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyPattern reports whether the key path matches any of the patterns.
+func matchAnyPattern(patterns []string, keys []string) bool {
+	for _, p := range patterns {
+		if matchPattern(splitPattern(p), keys) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches a key path against the segments of a pattern, where
+// "**" matches any number of keys.
+func matchPattern(pattern []string, keys []string) bool {
+	if len(pattern) == 0 {
+		return len(keys) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(keys); i++ {
+			if matchPattern(pattern[1:], keys[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(keys) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], keys[0]); !ok {
+		return false
+	}
+	return matchPattern(pattern[1:], keys[1:])
+}
+
+// splitPattern splits a pattern into its segments at the dots not escaped with
+// a backslash. Escapes are kept, as path.Match understands them.
+func splitPattern(pattern string) []string {
+	var segs []string
+	var cur strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			cur.WriteByte(c)
+			i++
+			cur.WriteByte(pattern[i])
+		case c == '.':
+			segs = append(segs, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(segs, cur.String())
+}
+
+// LiteralPattern returns a pattern (see WithExcludePaths) matching exactly the
+// given key path, escaping the dots and pattern characters in the keys.
+func LiteralPattern(keys ...string) string {
+	escaped := make([]string, len(keys))
+	for i, k := range keys {
+		var b strings.Builder
+		for _, r := range k {
+			if strings.ContainsRune(`\*?[.`, r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		escaped[i] = b.String()
+	}
+	return strings.Join(escaped, ".")
+}
@@ -0,0 +1,87 @@
+package yaml
+
+import (
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"global.clusterName", "global.clusterName", true},
+		{"global.clusterName", "global.region", false},
+		{"*.image.tag", "api.image.tag", true},
+		{"*.image.tag", "image.tag", false},
+		{"**.image.tag", "image.tag", true},
+		{"**.image.tag", "a.b.image.tag", true},
+		{"global.**", "global", true},
+		{"global.**", "global.x.y", true},
+		{"global.**", "globals.x", false},
+		{"image.t*", "image.tag", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+	}
+	for _, tc := range tests {
+		if got := matchPattern(splitPattern(tc.pattern), splitPath(tc.path)); got != tc.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestExtractCommonN_ExcludePaths(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("global:\n  clusterName: prod\n  region: eu\napi:\n  image:\n    repository: api\n    tag: v1\n"),
+		[]byte("global:\n  clusterName: prod\n  region: eu\napi:\n  image:\n    repository: api\n    tag: v1\n"),
+	}
+	common, rems, err := ExtractCommonN(inputs, WithExcludePaths("global.clusterName", "*.image.tag"))
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("global:\n  region: eu\napi:\n  image:\n    repository: api\n"), common)
+	for i := range rems {
+		assertYAMLEqual(t, []byte("global:\n  clusterName: prod\napi:\n  image:\n    tag: v1\n"), rems[i])
+		m, err := MergeYAML(common, rems[i])
+		if err != nil {
+			t.Fatalf("MergeYAML error: %v", err)
+		}
+		assertYAMLEqual(t, inputs[i], m)
+	}
+}
+
+func TestExtractCommon_OnlyPaths(t *testing.T) {
+	y1 := []byte("global:\n  env: prod\n  tls:\n    enabled: true\nreplicas: 2\n")
+	y2 := []byte("global:\n  env: prod\n  tls:\n    enabled: true\nreplicas: 2\n")
+	common, u1, u2, err := ExtractCommon(y1, y2, WithOnlyPaths("global.**"))
+	if err != nil {
+		t.Fatalf("ExtractCommon error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("global:\n  env: prod\n  tls:\n    enabled: true\n"), common)
+	assertYAMLEqual(t, []byte("replicas: 2\n"), u1)
+	assertYAMLEqual(t, []byte("replicas: 2\n"), u2)
+
+	common, _, err = ExtractCommonNodesN([][]byte{y1, y2}, WithOnlyPaths("global"), WithExcludePaths("**.tls"))
+	if err != nil {
+		t.Fatalf("ExtractCommonNodesN error: %v", err)
+	}
+	assertYAMLEqual(t, []byte("global:\n  env: prod\n"), common)
+
+	if _, _, err := ExtractCommonN([][]byte{y1, y2}, WithOnlyPaths("global.[")); err == nil {
+		t.Fatalf("expected an error for an invalid pattern")
+	}
+}
+
+func TestLiteralPattern(t *testing.T) {
+	keys := []string{"labels", "app.kubernetes.io/name", "*", "**"}
+	p := LiteralPattern(keys...)
+	if got := splitPattern(p); len(got) != len(keys) {
+		t.Fatalf("splitPattern(%q) = %q", p, got)
+	}
+	if !matchPattern(splitPattern(p), keys) {
+		t.Fatalf("%q should match %q", p, keys)
+	}
+	if matchPattern(splitPattern(p), []string{"labels", "app.kubernetes.io/name", "x", "**"}) {
+		t.Fatalf("%q should only match %q", p, keys)
+	}
+}
@@ -0,0 +1,125 @@
+package yaml
+
+import (
+	"fmt"
+	"sort"
+)
+
+// HoistedLeaf describes a leaf of a common output and where it came from.
+// Inputs are referred to by their index in the list passed to the extractor.
+type HoistedLeaf struct {
+	// Document is the index of the document in the common stream, always 0 for
+	// single-document inputs.
+	Document int
+	// Path is the dotted key path of the leaf, for example "image.tag", with
+	// the dots and pattern characters in keys escaped as LiteralPattern does,
+	// so it can be given to WithExcludePaths. Lists are leaves.
+	Path string
+	// Sources are the inputs that held the same value at Path.
+	Sources []int
+	// RemovedFrom are the inputs that held Path and whose remainder no longer
+	// does.
+	RemovedFrom []int
+}
+
+// HoistedLeaves compares the inputs of an extraction (ExtractCommon,
+// ExtractCommonN or their stream and node variants) with its outputs and
+// returns every leaf of the common output with its provenance, ordered by
+// document and path. The options must be the ones used for the extraction, as
+// they define how stream documents are paired and how scalars are compared.
+func HoistedLeaves(originals [][]byte, common []byte, remainders [][]byte, opts ...Option) ([]HoistedLeaf, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if len(originals) != len(remainders) {
+		return nil, fmt.Errorf("%d originals but %d remainders", len(originals), len(remainders))
+	}
+
+	origDocs := make([][][]byte, len(originals))
+	remDocs := make([][][]byte, len(remainders))
+	for i := range originals {
+		origDocs[i] = SplitDocuments(originals[i])
+		remDocs[i] = SplitDocuments(remainders[i])
+		if len(origDocs[i]) != len(remDocs[i]) {
+			return nil, fmt.Errorf("input %d: %d documents but %d in its remainder", i, len(origDocs[i]), len(remDocs[i]))
+		}
+	}
+	groups, err := groupStreamDocuments(origDocs, options.StreamMatchKey)
+	if err != nil {
+		return nil, err
+	}
+	commonDocs := SplitDocuments(common)
+	if len(commonDocs) != len(groups) {
+		return nil, fmt.Errorf("%d common documents for %d groups of input documents", len(commonDocs), len(groups))
+	}
+
+	var out []HoistedLeaf
+	for g, group := range groups {
+		cv, err := options.unmarshal(commonDocs[g])
+		if err != nil {
+			return nil, fmt.Errorf("common document %d: %w", g, err)
+		}
+		leaves := make(map[string]leaf)
+		collectLeaves(cv, nil, leaves)
+		if options.StreamMatchKey != "" {
+			delete(leaves, LiteralPattern(splitPath(options.StreamMatchKey)...))
+		}
+		if len(leaves) == 0 {
+			continue
+		}
+
+		origs := make([]any, len(group.members))
+		rems := make([]any, len(group.members))
+		for i, m := range group.members {
+			if origs[i], err = options.unmarshal(origDocs[m.stream][m.index]); err != nil {
+				return nil, fmt.Errorf("input %d, document %d: %w", m.stream, m.index, err)
+			}
+			if rems[i], err = options.unmarshal(remDocs[m.stream][m.index]); err != nil {
+				return nil, fmt.Errorf("remainder %d, document %d: %w", m.stream, m.index, err)
+			}
+		}
+
+		paths := make([]string, 0, len(leaves))
+		for p := range leaves {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			l := leaves[p]
+			hoisted := HoistedLeaf{Document: g, Path: p}
+			for i, m := range group.members {
+				ov, had := valueAtKeys(origs[i], l.keys)
+				if had && options.equal(ov, l.value) {
+					hoisted.Sources = append(hoisted.Sources, m.stream)
+				}
+				if _, kept := valueAtKeys(rems[i], l.keys); had && !kept {
+					hoisted.RemovedFrom = append(hoisted.RemovedFrom, m.stream)
+				}
+			}
+			out = append(out, hoisted)
+		}
+	}
+	return out, nil
+}
+
+// leaf is a non-map value and the keys leading to it.
+type leaf struct {
+	keys  []string
+	value any
+}
+
+// collectLeaves stores in leaves every non-map value found under v, keyed by
+// its escaped dotted path.
+func collectLeaves(v any, prefix []string, leaves map[string]leaf) {
+	m, ok := asStringMap(v)
+	if !ok {
+		if len(prefix) > 0 {
+			leaves[LiteralPattern(prefix...)] = leaf{keys: prefix, value: v}
+		}
+		return
+	}
+	for k, vv := range m {
+		collectLeaves(vv, append(prefix[:len(prefix):len(prefix)], k), leaves)
+	}
+}
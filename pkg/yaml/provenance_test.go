@@ -0,0 +1,75 @@
+package yaml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHoistedLeaves(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("image:\n  repository: nginx\n  tag: \"1.2\"\nreplicas: 3\nports: [80]\n"),
+		[]byte("image:\n  repository: nginx\n  tag: \"1.2\"\nreplicas: 3\nports: [80]\n"),
+		[]byte("image:\n  repository: nginx\n  tag: \"1.3\"\nreplicas: 3\nports: [80]\n"),
+	}
+	opts := []Option{WithMajorityDefaults(0.6)}
+	common, rems, err := ExtractCommonN(inputs, opts...)
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	leaves, err := HoistedLeaves(inputs, common, rems, opts...)
+	if err != nil {
+		t.Fatalf("HoistedLeaves error: %v", err)
+	}
+	want := []HoistedLeaf{
+		{Path: "image.repository", Sources: []int{0, 1, 2}, RemovedFrom: []int{0, 1, 2}},
+		{Path: "image.tag", Sources: []int{0, 1}, RemovedFrom: []int{0, 1}},
+		{Path: "ports", Sources: []int{0, 1, 2}, RemovedFrom: []int{0, 1, 2}},
+		{Path: "replicas", Sources: []int{0, 1, 2}, RemovedFrom: []int{0, 1, 2}},
+	}
+	if !reflect.DeepEqual(want, leaves) {
+		t.Fatalf("unexpected leaves\n---- got ----\n%+v\n---- expect ----\n%+v", leaves, want)
+	}
+}
+
+func TestHoistedLeaves_StreamsByKey(t *testing.T) {
+	streams := [][]byte{
+		[]byte("name: api\nreplicas: 2\n---\nname: web\nport: 80\n"),
+		[]byte("name: web\nport: 80\n---\nname: api\nreplicas: 3\n"),
+	}
+	opts := []Option{WithStreamMatchKey("name")}
+	common, rems, err := ExtractCommonStreams(streams, opts...)
+	if err != nil {
+		t.Fatalf("ExtractCommonStreams error: %v", err)
+	}
+	leaves, err := HoistedLeaves(streams, common, rems, opts...)
+	if err != nil {
+		t.Fatalf("HoistedLeaves error: %v", err)
+	}
+	want := []HoistedLeaf{
+		{Document: 1, Path: "port", Sources: []int{0, 1}, RemovedFrom: []int{0, 1}},
+	}
+	if !reflect.DeepEqual(want, leaves) {
+		t.Fatalf("unexpected leaves\n---- got ----\n%+v\n---- expect ----\n%+v", leaves, want)
+	}
+}
+
+func TestHoistedLeaves_DottedKeys(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("podAnnotations:\n  translation.adobe.io/ams-env: prod\n  a.b: x\nreplicas: 1\n"),
+		[]byte("podAnnotations:\n  translation.adobe.io/ams-env: prod\n  a.b: y\nreplicas: 2\n"),
+	}
+	common, rems, err := ExtractCommonN(inputs)
+	if err != nil {
+		t.Fatalf("ExtractCommonN error: %v", err)
+	}
+	leaves, err := HoistedLeaves(inputs, common, rems)
+	if err != nil {
+		t.Fatalf("HoistedLeaves error: %v", err)
+	}
+	want := []HoistedLeaf{
+		{Path: `podAnnotations.translation\.adobe\.io/ams-env`, Sources: []int{0, 1}, RemovedFrom: []int{0, 1}},
+	}
+	if !reflect.DeepEqual(want, leaves) {
+		t.Fatalf("unexpected leaves\n---- got ----\n%+v\n---- expect ----\n%+v", leaves, want)
+	}
+}
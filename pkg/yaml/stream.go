@@ -0,0 +1,325 @@
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	syaml "sigs.k8s.io/yaml"
+)
+
+// ErrMultipleDocuments is returned by the single-document functions when their
+// input is a stream with more than one YAML document.
+var ErrMultipleDocuments = errors.New("multiple YAML documents found, use the stream functions")
+
+// WithStreamMatchKey pairs the documents of the streams passed to
+// ExtractCommonStreams by the (dotted) key path given, for example "name" or
+// "metadata.name", instead of by their position in the stream.
+func WithStreamMatchKey(path string) Option {
+	return func(o *Options) { o.StreamMatchKey = path }
+}
+
+// SplitDocuments splits a YAML stream into its documents. Document separators
+// ("---") and end markers ("...") are removed; comments and directives that
+// precede the first separator are kept with the first document. An input
+// without separators is returned as a single document.
+func SplitDocuments(b []byte) [][]byte {
+	var docs [][]byte
+	var cur bytes.Buffer
+	separated := false
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		trimmed := strings.TrimRight(string(line), "\r\n")
+		switch {
+		case trimmed == "---" || strings.HasPrefix(trimmed, "--- ") || strings.HasPrefix(trimmed, "---\t"):
+			if separated || hasContent(cur.Bytes()) {
+				docs = append(docs, bytes.Clone(cur.Bytes()))
+				cur.Reset()
+			}
+			separated = true
+			if rest := strings.TrimSpace(trimmed[3:]); rest != "" {
+				cur.WriteString(rest)
+				cur.WriteByte('\n')
+			}
+		case trimmed == "...":
+			docs = append(docs, bytes.Clone(cur.Bytes()))
+			cur.Reset()
+			separated = false
+		default:
+			cur.Write(line)
+		}
+	}
+	if len(docs) == 0 || len(bytes.TrimSpace(cur.Bytes())) > 0 {
+		docs = append(docs, bytes.Clone(cur.Bytes()))
+	}
+	return docs
+}
+
+// JoinDocuments joins documents into a YAML stream separated by "---".
+func JoinDocuments(docs [][]byte) []byte {
+	var buf bytes.Buffer
+	for i, d := range docs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(d)
+		if len(d) > 0 && d[len(d)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// IsStream reports whether b holds more than one YAML document.
+func IsStream(b []byte) bool {
+	return len(SplitDocuments(b)) > 1
+}
+
+// hasContent reports whether b has anything other than blank lines, comments
+// and directives.
+func hasContent(b []byte) bool {
+	for _, line := range strings.Split(string(b), "\n") {
+		l := strings.TrimSpace(line)
+		if l != "" && !strings.HasPrefix(l, "#") && !strings.HasPrefix(l, "%") {
+			return true
+		}
+	}
+	return false
+}
+
+// assertSingleDocument returns ErrMultipleDocuments if any input is a stream.
+func assertSingleDocument(yamls ...[]byte) error {
+	for _, y := range yamls {
+		if IsStream(y) {
+			return ErrMultipleDocuments
+		}
+	}
+	return nil
+}
+
+// EqualYAMLStreams compares two YAML streams document by document with
+// EqualYAMLs. Streams with a different number of documents are not equal.
+func EqualYAMLStreams(a []byte, b []byte) (bool, error) {
+	da := SplitDocuments(a)
+	db := SplitDocuments(b)
+	if len(da) != len(db) {
+		return false, nil
+	}
+	for i := range da {
+		equal, err := EqualYAMLs(da[i], db[i])
+		if err != nil {
+			return false, fmt.Errorf("document %d: %w", i, err)
+		}
+		if !equal {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ExtractCommonStreams is the stream-aware counterpart of ExtractCommonN. The
+// documents of the streams are paired by position, or by the value found at
+// the key path set with WithStreamMatchKey, and ExtractCommonN is applied to
+// every group of paired documents. It returns:
+//  1. a stream with one common document per group, in order of first appearance
+//  2. N remainder streams with the same document layout as the inputs
+//
+// Groups with a single document produce no common content. When pairing by key,
+// the key is kept in both the common and the remainder documents.
+func ExtractCommonStreams(streams [][]byte, opts ...Option) ([]byte, [][]byte, error) {
+	return extractCommonStreams(streams, ExtractCommonN, opts)
+}
+
+// ExtractCommonNodesStreams is the comment- and order-preserving counterpart of
+// ExtractCommonStreams, built on ExtractCommonNodesN.
+func ExtractCommonNodesStreams(streams [][]byte, opts ...Option) ([]byte, [][]byte, error) {
+	return extractCommonStreams(streams, ExtractCommonNodesN, opts)
+}
+
+// streamMember identifies a document inside one of the input streams.
+type streamMember struct {
+	stream int
+	index  int
+}
+
+type streamGroup struct {
+	// key is the canonical form of the match key value, or the position of
+	// the documents when they are paired by position.
+	key string
+	// value is the match key value.
+	value   any
+	members []streamMember
+}
+
+func extractCommonStreams(streams [][]byte, extractN func([][]byte, ...Option) ([]byte, [][]byte, error), opts []Option) ([]byte, [][]byte, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	docs := make([][][]byte, len(streams))
+	for s, stream := range streams {
+		docs[s] = SplitDocuments(stream)
+	}
+	groups, err := groupStreamDocuments(docs, options.StreamMatchKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groupOpts := opts
+	if options.StreamMatchKey != "" {
+		groupOpts = append(append([]Option{}, opts...), withKeepPath(options.StreamMatchKey))
+	}
+
+	commonDocs := make([][]byte, len(groups))
+	remainderDocs := make([][][]byte, len(streams))
+	for s := range streams {
+		remainderDocs[s] = make([][]byte, len(docs[s]))
+	}
+	for g, group := range groups {
+		if len(group.members) < 2 {
+			commonDocs[g] = []byte("{}\n")
+			if options.StreamMatchKey != "" {
+				if commonDocs[g], err = syaml.Marshal(nestAtPath(options.StreamMatchKey, group.value)); err != nil {
+					return nil, nil, err
+				}
+			}
+			m := group.members[0]
+			remainderDocs[m.stream][m.index] = docs[m.stream][m.index]
+			continue
+		}
+		inputs := make([][]byte, len(group.members))
+		for i, m := range group.members {
+			inputs[i] = docs[m.stream][m.index]
+		}
+		common, remainders, err := extractN(inputs, groupOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("document group %d: %w", g, err)
+		}
+		commonDocs[g] = common
+		for i, m := range group.members {
+			remainderDocs[m.stream][m.index] = remainders[i]
+		}
+	}
+
+	out := make([][]byte, len(streams))
+	for s := range streams {
+		out[s] = JoinDocuments(remainderDocs[s])
+	}
+	return JoinDocuments(commonDocs), out, nil
+}
+
+// groupStreamDocuments pairs the documents of the streams by position or, when
+// matchKey is set, by the scalar found at that key path.
+func groupStreamDocuments(docs [][][]byte, matchKey string) ([]streamGroup, error) {
+	var groups []streamGroup
+	if matchKey == "" {
+		for s := range docs {
+			for i := range docs[s] {
+				if i >= len(groups) {
+					groups = append(groups, streamGroup{key: fmt.Sprint(i)})
+				}
+				groups[i].members = append(groups[i].members, streamMember{stream: s, index: i})
+			}
+		}
+		return groups, nil
+	}
+
+	byKey := make(map[string]int)
+	for s := range docs {
+		seen := make(map[string]struct{})
+		for i, d := range docs[s] {
+			var v any
+			if err := syaml.Unmarshal(d, &v); err != nil {
+				return nil, fmt.Errorf("stream %d, document %d: %w", s, i, err)
+			}
+			value, key, ok := scalarAtPath(v, matchKey)
+			if !ok {
+				return nil, fmt.Errorf("stream %d, document %d: no scalar value at %q", s, i, matchKey)
+			}
+			if _, dup := seen[key]; dup {
+				return nil, fmt.Errorf("stream %d: duplicate documents for %s=%s", s, matchKey, key)
+			}
+			seen[key] = struct{}{}
+			g, ok := byKey[key]
+			if !ok {
+				g = len(groups)
+				byKey[key] = g
+				groups = append(groups, streamGroup{key: key, value: value})
+			}
+			groups[g].members = append(groups[g].members, streamMember{stream: s, index: i})
+		}
+	}
+	return groups, nil
+}
+
+// splitPath splits a dotted key path into its components.
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// valueAtPath returns the value found at the dotted key path in v.
+func valueAtPath(v any, path string) (any, bool) {
+	return valueAtKeys(v, splitPath(path))
+}
+
+// valueAtKeys returns the value found at the key path in v.
+func valueAtKeys(v any, keys []string) (any, bool) {
+	cur := v
+	for _, k := range keys {
+		m, ok := asStringMap(cur)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// scalarAtPath returns the scalar found at the dotted key path in v and its
+// canonical form, its JSON encoding, which tells apart scalars of different
+// types such as 1 and "1".
+func scalarAtPath(v any, path string) (any, string, bool) {
+	val, ok := valueAtPath(v, path)
+	if !ok || !isScalar(val) {
+		return nil, "", false
+	}
+	b, err := json.Marshal(val)
+	if err != nil {
+		return nil, "", false
+	}
+	return val, string(b), true
+}
+
+// nestAtPath builds a map holding value at the dotted key path.
+func nestAtPath(path string, value any) map[string]any {
+	keys := splitPath(path)
+	out := map[string]any{keys[len(keys)-1]: value}
+	for i := len(keys) - 2; i >= 0; i-- {
+		out = map[string]any{keys[i]: out}
+	}
+	return out
+}
+
+// setAtPath stores value at the dotted key path in m, creating intermediate
+// maps as needed, and returns the resulting map.
+func setAtPath(m map[string]any, path string, value any) map[string]any {
+	if m == nil {
+		m = make(map[string]any)
+	}
+	keys := splitPath(path)
+	cur := m
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := asStringMap(cur[k])
+		if !ok {
+			next = make(map[string]any)
+			cur[k] = next
+		}
+		cur = next
+	}
+	cur[keys[len(keys)-1]] = value
+	return m
+}
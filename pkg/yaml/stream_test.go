@@ -0,0 +1,173 @@
+package yaml
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSplitDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{
+			name: "single document",
+			in:   "a: 1\n",
+			want: []string{"a: 1\n"},
+		},
+		{
+			name: "empty input",
+			in:   "",
+			want: []string{""},
+		},
+		{
+			name: "leading separator and header comment",
+			in:   "# header\n---\na: 1\n---\nb: 2\n",
+			want: []string{"# header\na: 1\n", "b: 2\n"},
+		},
+		{
+			name: "separator with inline content and trailing separator",
+			in:   "a: 1\n--- # second\nb: 2\n---\n",
+			want: []string{"a: 1\n", "# second\nb: 2\n"},
+		},
+		{
+			name: "empty document in the middle and end marker",
+			in:   "a: 1\n---\n---\nb: 2\n...\n",
+			want: []string{"a: 1\n", "", "b: 2\n"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := SplitDocuments([]byte(tc.in))
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected %d documents, got %d: %q", len(tc.want), len(got), got)
+			}
+			for i := range got {
+				if string(got[i]) != tc.want[i] {
+					t.Fatalf("document %d: expected %q, got %q", i, tc.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSingleDocumentFunctions_RejectStreams(t *testing.T) {
+	stream := []byte("a: 1\n---\na: 2\n")
+	if _, _, _, err := ExtractCommon(stream, []byte("a: 1\n")); !errors.Is(err, ErrMultipleDocuments) {
+		t.Fatalf("ExtractCommon: expected ErrMultipleDocuments, got %v", err)
+	}
+	if _, _, err := ExtractCommonN([][]byte{stream, stream}); !errors.Is(err, ErrMultipleDocuments) {
+		t.Fatalf("ExtractCommonN: expected ErrMultipleDocuments, got %v", err)
+	}
+	if _, err := EqualYAMLs(stream, stream); !errors.Is(err, ErrMultipleDocuments) {
+		t.Fatalf("EqualYAMLs: expected ErrMultipleDocuments, got %v", err)
+	}
+}
+
+func TestEqualYAMLStreams(t *testing.T) {
+	equal, err := EqualYAMLStreams([]byte("a: 1\nb: 2\n---\nc: 3\n"), []byte("b: 2\na: 1\n---\nc: 3\n"))
+	if err != nil || !equal {
+		t.Fatalf("expected equal streams, got %v, %v", equal, err)
+	}
+	equal, err = EqualYAMLStreams([]byte("a: 1\n---\nc: 3\n"), []byte("a: 1\n"))
+	if err != nil || equal {
+		t.Fatalf("expected different streams, got %v, %v", equal, err)
+	}
+}
+
+func TestExtractCommonStreams_ByIndex(t *testing.T) {
+	streams := [][]byte{
+		[]byte("release: api\nreplicas: 2\nimage: api:v1\n---\nrelease: web\nport: 80\n"),
+		[]byte("release: api\nreplicas: 2\nimage: api:v2\n---\nrelease: web\nport: 8080\n---\nextra: true\n"),
+	}
+	common, rems, err := ExtractCommonStreams(streams)
+	if err != nil {
+		t.Fatalf("ExtractCommonStreams error: %v", err)
+	}
+	equal, err := EqualYAMLStreams([]byte("release: api\nreplicas: 2\n---\nrelease: web\n---\n{}\n"), common)
+	if err != nil || !equal {
+		t.Fatalf("unexpected common stream:\n%s", common)
+	}
+	equal, err = EqualYAMLStreams([]byte("image: api:v2\n---\nport: 8080\n---\nextra: true\n"), rems[1])
+	if err != nil || !equal {
+		t.Fatalf("unexpected remainder stream:\n%s", rems[1])
+	}
+	assertStreamsMerge(t, streams, common, rems)
+}
+
+func TestExtractCommonStreams_ByKey(t *testing.T) {
+	streams := [][]byte{
+		[]byte("name: api\nreplicas: 2\n---\nname: web\nport: 80\ntls: true\n"),
+		[]byte("name: web\nport: 8080\ntls: true\n---\nname: api\nreplicas: 2\n---\nname: worker\nqueue: jobs\n"),
+	}
+	common, rems, err := ExtractCommonNodesStreams(streams, WithStreamMatchKey("name"))
+	if err != nil {
+		t.Fatalf("ExtractCommonNodesStreams error: %v", err)
+	}
+	equal, err := EqualYAMLStreams([]byte("name: api\nreplicas: 2\n---\nname: web\ntls: true\n---\nname: worker\n"), common)
+	if err != nil || !equal {
+		t.Fatalf("unexpected common stream:\n%s", common)
+	}
+	equal, err = EqualYAMLStreams([]byte("name: web\nport: 8080\n---\nname: api\n---\nname: worker\nqueue: jobs\n"), rems[1])
+	if err != nil || !equal {
+		t.Fatalf("unexpected remainder stream:\n%s", rems[1])
+	}
+
+	// Pairing by key keeps the key in the remainders, so they merge back by key.
+	commonDocs := SplitDocuments(common)
+	for s := range streams {
+		for i, doc := range SplitDocuments(rems[s]) {
+			var matched bool
+			for _, c := range commonDocs {
+				if m, err := MergeYAML(c, doc); err == nil {
+					if ok, _ := EqualYAMLs(SplitDocuments(streams[s])[i], m); ok {
+						matched = true
+						break
+					}
+				}
+			}
+			if !matched {
+				t.Fatalf("stream %d, document %d does not merge back", s, i)
+			}
+		}
+	}
+
+	if _, _, err := ExtractCommonStreams([][]byte{[]byte("a: 1\n---\nname: x\n"), []byte("name: x\n")}, WithStreamMatchKey("name")); err == nil {
+		t.Fatalf("expected error for documents without the match key")
+	}
+
+	// Keys of different types are different documents, and unpaired documents
+	// keep the type of their key
+	typed := [][]byte{
+		[]byte("id: 1\nport: 80\n---\nid: \"2\"\nport: 80\n"),
+		[]byte("id: \"1\"\nport: 80\n---\nid: \"2\"\nport: 80\n"),
+	}
+	common, _, err = ExtractCommonStreams(typed, WithStreamMatchKey("id"))
+	if err != nil {
+		t.Fatalf("ExtractCommonStreams error: %v", err)
+	}
+	equal, err = EqualYAMLStreams([]byte("id: 1\n---\nid: \"2\"\nport: 80\n---\nid: \"1\"\n"), common)
+	if err != nil || !equal {
+		t.Fatalf("unexpected common stream for typed keys:\n%s", common)
+	}
+}
+
+func assertStreamsMerge(t *testing.T, streams [][]byte, common []byte, rems [][]byte) {
+	t.Helper()
+	commonDocs := SplitDocuments(common)
+	for s := range streams {
+		originals := SplitDocuments(streams[s])
+		remDocs := SplitDocuments(rems[s])
+		if len(originals) != len(remDocs) {
+			t.Fatalf("stream %d: expected %d documents, got %d", s, len(originals), len(remDocs))
+		}
+		for i := range originals {
+			m, err := MergeYAML(commonDocs[i], remDocs[i])
+			if err != nil {
+				t.Fatalf("MergeYAML error: %v", err)
+			}
+			assertYAMLEqual(t, originals[i], m)
+		}
+	}
+}